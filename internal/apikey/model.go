@@ -0,0 +1,55 @@
+package apikey
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ScopeJobsRead  = "jobs:read"
+	ScopeJobsWrite = "jobs:write"
+
+	// DefaultRateLimit is the number of requests per minute allowed for a key
+	DefaultRateLimit = 60
+
+	keyPrefix = "jb_"
+)
+
+var ValidScopes = map[string]struct{}{
+	ScopeJobsRead:  {},
+	ScopeJobsWrite: {},
+}
+
+type APIKey struct {
+	ID                     string
+	UserID                 string
+	Name                   string
+	Prefix                 string
+	Scopes                 []string
+	RateLimit              int
+	CreatedAt              time.Time
+	LastUsedAt             pq.NullTime
+	RevokedAt              pq.NullTime
+	RequestCount           int
+	RequestCountLast30Days int
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k APIKey) ScopesString() string {
+	return strings.Join(k.Scopes, ",")
+}
+
+type CreateRq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
@@ -0,0 +1,56 @@
+package apikey
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is an in memory token bucket per key, refilling
+// ratePerMinute tokens every minute up to a burst of ratePerMinute
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket)}
+}
+
+func (l *rateLimiter) allow(id string, ratePerMinute int) (bool, time.Duration) {
+	if ratePerMinute <= 0 {
+		ratePerMinute = DefaultRateLimit
+	}
+	capacity := float64(ratePerMinute)
+	refillPerSecond := capacity / 60
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: capacity, lastSeen: now}
+		l.buckets[id] = b
+	}
+	b.tokens += now.Sub(b.lastSeen).Seconds() * refillPerSecond
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.lastSeen = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / refillPerSecond * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) forget(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, id)
+}
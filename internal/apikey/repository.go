@@ -0,0 +1,145 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+type Repository struct {
+	db      *sql.DB
+	limiter *rateLimiter
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db, newRateLimiter()}
+}
+
+// Create issues a new key for the given user. The plain text key is only
+// returned here, the database only stores its sha256 hash
+func (r *Repository) Create(userID, name string, scopes []string) (APIKey, string, error) {
+	k := APIKey{}
+	if len(scopes) == 0 {
+		return k, "", errors.New("at least one scope is required")
+	}
+	for _, s := range scopes {
+		if _, ok := ValidScopes[s]; !ok {
+			return k, "", errors.New("invalid scope " + s)
+		}
+	}
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return k, "", err
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return k, "", err
+	}
+	plain := keyPrefix + hex.EncodeToString(secret)
+	k.ID = id.String()
+	k.UserID = userID
+	k.Name = name
+	k.Prefix = plain[:len(keyPrefix)+8]
+	k.Scopes = scopes
+	k.RateLimit = DefaultRateLimit
+	k.CreatedAt = time.Now().UTC()
+	_, err = r.db.Exec(
+		`INSERT INTO api_key (id, user_id, name, prefix, key_hash, scopes, rate_limit, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		k.ID,
+		k.UserID,
+		k.Name,
+		k.Prefix,
+		hashKey(plain),
+		k.ScopesString(),
+		k.RateLimit,
+		k.CreatedAt,
+	)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	return k, plain, nil
+}
+
+// GetActiveByKey returns the non revoked key matching the given plain text key
+func (r *Repository) GetActiveByKey(plain string) (APIKey, error) {
+	k := APIKey{}
+	var scopes string
+	row := r.db.QueryRow(`SELECT id, user_id, name, prefix, scopes, rate_limit, created_at, last_used_at, revoked_at FROM api_key WHERE key_hash = $1 AND revoked_at IS NULL`, hashKey(plain))
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &scopes, &k.RateLimit, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+		return k, err
+	}
+	k.Scopes = strings.Split(scopes, ",")
+	return k, nil
+}
+
+// GetForUser returns all keys for a user together with their usage counters
+func (r *Repository) GetForUser(userID string) ([]*APIKey, error) {
+	keys := []*APIKey{}
+	rows, err := r.db.Query(`SELECT k.id, k.user_id, k.name, k.prefix, k.scopes, k.rate_limit, k.created_at, k.last_used_at, k.revoked_at,
+		COALESCE(SUM(u.request_count), 0) AS request_count,
+		COALESCE(SUM(u.request_count) FILTER (WHERE u.day > CURRENT_DATE - INTERVAL '30 days'), 0) AS request_count_30_days
+		FROM api_key k
+		LEFT JOIN api_key_usage u ON u.api_key_id = k.id
+		WHERE k.user_id = $1
+		GROUP BY k.id
+		ORDER BY k.created_at DESC`, userID)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		k := &APIKey{}
+		var scopes string
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &scopes, &k.RateLimit, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt, &k.RequestCount, &k.RequestCountLast30Days); err != nil {
+			return keys, err
+		}
+		k.Scopes = strings.Split(scopes, ",")
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+func (r *Repository) Revoke(id, userID string) error {
+	res, err := r.db.Exec(`UPDATE api_key SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	r.limiter.forget(id)
+	return nil
+}
+
+// TrackUsage increments today's request counter for the given key
+func (r *Repository) TrackUsage(id string) error {
+	if _, err := r.db.Exec(`UPDATE api_key SET last_used_at = NOW() WHERE id = $1`, id); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`INSERT INTO api_key_usage (api_key_id, day, request_count) VALUES ($1, CURRENT_DATE, 1) ON CONFLICT (api_key_id, day) DO UPDATE SET request_count = api_key_usage.request_count + 1`, id)
+	return err
+}
+
+// Allow takes a token from the key's bucket, returning false and the time to
+// wait before retrying when the key is over its rate limit
+func (r *Repository) Allow(k APIKey) (bool, time.Duration) {
+	return r.limiter.allow(k.ID, k.RateLimit)
+}
+
+func hashKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
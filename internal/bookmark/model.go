@@ -25,3 +25,22 @@ type Bookmark struct {
 
 	HasApplyRecord bool
 }
+
+// Application is a job the user applied to, with the stage the employer has moved the
+// application to. ApplyToken is empty once the application has been withdrawn
+type Application struct {
+	JobPostID     int
+	JobSlug       string
+	JobTitle      string
+	CompanyName   string
+	CompanyURLEnc string
+	JobExternalID string
+	JobLocation   string
+
+	ApplyToken     string
+	Stage          string
+	AppliedAt      time.Time
+	ConfirmedAt    pq.NullTime
+	StageUpdatedAt pq.NullTime
+	WithdrawnAt    pq.NullTime
+}
@@ -3,6 +3,7 @@ package bookmark
 import (
 	"database/sql"
 	"net/url"
+	"time"
 )
 
 type Repository struct {
@@ -104,12 +105,14 @@ func (r *Repository) BookmarkJob(userID string, jobID int, setApplied bool) erro
 		appliedAtExpr = "NOW()"
 	}
 
+	// applying again to a job after withdrawing starts over
 	stmt := `
 		INSERT INTO bookmark (user_id, job_id, created_at, applied_at)
 		VALUES ($1, $2, NOW(), ` + appliedAtExpr + `)
 		ON CONFLICT (user_id, job_id) DO UPDATE
-			SET applied_at = EXCLUDED.applied_at
-			WHERE bookmark.applied_at IS NULL`
+			SET applied_at = EXCLUDED.applied_at, withdrawn_at = NULL
+			WHERE bookmark.applied_at IS NULL
+				OR (bookmark.withdrawn_at IS NOT NULL AND EXCLUDED.applied_at IS NOT NULL)`
 	_, err := r.db.Exec(stmt, userID, jobID)
 	return err
 }
@@ -122,3 +125,74 @@ func (r *Repository) RemoveBookmark(userID string, jobID int) error {
 	)
 	return err
 }
+
+// GetApplicationsForUser returns the jobs the user applied to, either signed in (bookmark.applied_at)
+// or with the same email address (apply_token), most recent first
+func (r *Repository) GetApplicationsForUser(userID string) ([]*Application, error) {
+	applications := []*Application{}
+	rows, err := r.db.Query(
+		`SELECT j.id, j.slug, j.job_title, j.company, j.external_id, j.location,
+			COALESCE(a.token, ''),
+			COALESCE(a.stage, ''),
+			COALESCE(b.applied_at, a.created_at) AS applied_at,
+			a.confirmed_at,
+			a.stage_updated_at,
+			b.withdrawn_at
+		FROM (
+			SELECT a.job_id FROM apply_token a JOIN users u ON u.email = a.email WHERE u.id = $1
+			UNION
+			SELECT job_id FROM bookmark WHERE user_id = $1 AND applied_at IS NOT NULL
+		) AS applied
+		JOIN job j ON j.id = applied.job_id
+		JOIN users u ON u.id = $1
+		LEFT JOIN bookmark b ON b.user_id = u.id AND b.job_id = j.id
+		LEFT JOIN LATERAL (
+			SELECT token, stage, created_at, confirmed_at, stage_updated_at
+			FROM apply_token
+			WHERE job_id = j.id AND email = u.email
+			ORDER BY created_at DESC
+			LIMIT 1
+		) a ON TRUE
+		ORDER BY applied_at DESC`,
+		userID)
+	if err != nil {
+		return applications, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a := &Application{}
+		if err := rows.Scan(
+			&a.JobPostID,
+			&a.JobSlug,
+			&a.JobTitle,
+			&a.CompanyName,
+			&a.JobExternalID,
+			&a.JobLocation,
+			&a.ApplyToken,
+			&a.Stage,
+			&a.AppliedAt,
+			&a.ConfirmedAt,
+			&a.StageUpdatedAt,
+			&a.WithdrawnAt,
+		); err != nil {
+			return applications, err
+		}
+		a.CompanyURLEnc = url.PathEscape(a.CompanyName)
+		applications = append(applications, a)
+	}
+	return applications, rows.Err()
+}
+
+// MarkApplicationWithdrawn keeps track of a withdrawn application once its apply token is gone
+func (r *Repository) MarkApplicationWithdrawn(userID string, jobID int, appliedAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO bookmark (user_id, job_id, created_at, applied_at, withdrawn_at)
+		VALUES ($1, $2, NOW(), $3, NOW())
+		ON CONFLICT (user_id, job_id) DO UPDATE
+			SET applied_at = COALESCE(bookmark.applied_at, EXCLUDED.applied_at), withdrawn_at = NOW()`,
+		userID,
+		jobID,
+		appliedAt,
+	)
+	return err
+}
@@ -24,6 +24,9 @@ type Config struct {
 	SmtpUser                 string
 	SmtpPassword             string
 	SmtpHost                 string
+	EmailTransport           string // one of smtp, log or file, defaults to log in dev and smtp otherwise
+	EmailDropDir             string // directory where emails are written when using the file transport
+	EmailWebhookToken        string // shared secret for the bounce and complaint webhook, the webhook is disabled when empty
 	AdminEmail               string
 	SupportEmail             string // displayed on the site for support queries
 	NoReplyEmail             string // used for transactional emails
@@ -80,6 +83,18 @@ type Config struct {
 	DevOfferCode2            string
 	DevOfferCode3            string
 	DevOfferCode4            string
+	CvRetentionDays          int      // days applicant CVs are kept after the job expires
+	CvRetentionNoticeDays    int      // days before CVs are deleted that the employer is notified
+	DevDirectoryGraceDays    int      // days developer directory access is kept after a subscription period ends
+	InvoiceIssuer            []string // seller name and address lines printed on invoices, "|" separated in INVOICE_ISSUER
+	InvoiceIssuerVATID       string
+	PlanCurrencies           []string          // currencies job ads can be paid in, the PlanID*Price prices are in USD
+	PlanCurrencyPrices       map[string][3]int // monthly plan prices in the currency's minor unit, FX-derived when missing
+	JobCreditPackSizes       []int             // credits in each job credit pack on sale, a credit is a month of a job ad
+	JobCreditPackDiscount    int               // percentage off the plan price when buying a credit pack
+	JobCreditExpiryDays      int               // days job credits can be spent after the pack is bought
+	JobPlanRenewalNoticeDays int               // days before a job plan expires that the poster is offered a renewal
+	JobPlanExpiredOfferDays  int               // days after a job plan expired that it is still downgraded and offered a renewal
 }
 
 func LoadConfig() (Config, error) {
@@ -139,6 +154,18 @@ func LoadConfig() (Config, error) {
 	if env == "" {
 		return Config{}, fmt.Errorf("ENV cannot be empty")
 	}
+	emailTransport := strings.ToLower(os.Getenv("EMAIL_TRANSPORT"))
+	if emailTransport == "" {
+		emailTransport = "smtp"
+		if env == "dev" {
+			emailTransport = "log"
+		}
+	}
+	emailDropDir := os.Getenv("EMAIL_DROP_DIR")
+	if emailDropDir == "" {
+		emailDropDir = "./tmp/emails"
+	}
+	emailWebhookToken := os.Getenv("EMAIL_WEBHOOK_TOKEN")
 	sessionKeyString := os.Getenv("SESSION_KEY")
 	if sessionKeyString == "" {
 		return Config{}, fmt.Errorf("SESSION_KEY cannot be empty")
@@ -326,6 +353,105 @@ func LoadConfig() (Config, error) {
 	devOfferCode2 := os.Getenv("DEV_OFFER_CODE_2")
 	devOfferCode3 := os.Getenv("DEV_OFFER_CODE_3")
 	devOfferCode4 := os.Getenv("DEV_OFFER_CODE_4")
+	cvRetentionDays := 90
+	if cvRetentionDaysStr := os.Getenv("CV_RETENTION_DAYS"); cvRetentionDaysStr != "" {
+		cvRetentionDays, err = strconv.Atoi(cvRetentionDaysStr)
+		if err != nil || cvRetentionDays < 1 {
+			return Config{}, fmt.Errorf("CV_RETENTION_DAYS must be a positive number of days")
+		}
+	}
+	cvRetentionNoticeDays := 7
+	if cvRetentionNoticeDaysStr := os.Getenv("CV_RETENTION_NOTICE_DAYS"); cvRetentionNoticeDaysStr != "" {
+		cvRetentionNoticeDays, err = strconv.Atoi(cvRetentionNoticeDaysStr)
+		if err != nil || cvRetentionNoticeDays < 1 {
+			return Config{}, fmt.Errorf("CV_RETENTION_NOTICE_DAYS must be a positive number of days")
+		}
+	}
+	devDirectoryGraceDays := 7
+	if devDirectoryGraceDaysStr := os.Getenv("DEV_DIRECTORY_GRACE_DAYS"); devDirectoryGraceDaysStr != "" {
+		devDirectoryGraceDays, err = strconv.Atoi(devDirectoryGraceDaysStr)
+		if err != nil || devDirectoryGraceDays < 0 {
+			return Config{}, fmt.Errorf("DEV_DIRECTORY_GRACE_DAYS must be a number of days")
+		}
+	}
+	invoiceIssuer := []string{siteName}
+	if invoiceIssuerStr := os.Getenv("INVOICE_ISSUER"); invoiceIssuerStr != "" {
+		invoiceIssuer = strings.Split(invoiceIssuerStr, "|")
+	}
+	invoiceIssuerVATID := os.Getenv("INVOICE_ISSUER_VAT_ID")
+	availableCurrencies := []string{"USD", "EUR", "JPY", "GBP", "AUD", "CAD", "CHF", "CNY", "HKD", "NZD", "SEK", "KRW", "SGD", "NOK", "MXN", "INR", "ZAR", "TRY", "BRL"}
+	planCurrencies := []string{"USD"}
+	planCurrencyPrices := make(map[string][3]int)
+	for _, currency := range strings.Split(os.Getenv("PLAN_CURRENCIES"), ",") {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if currency == "" || currency == "USD" {
+			continue
+		}
+		var available bool
+		for _, c := range availableCurrencies {
+			available = available || c == currency
+		}
+		if !available {
+			return Config{}, fmt.Errorf("PLAN_CURRENCIES contains unsupported currency %s", currency)
+		}
+		planCurrencies = append(planCurrencies, currency)
+		// e.g. PLAN_PRICES_EUR=5500,9500,18500 for the basic, pro and platinum plans
+		pricesStr := os.Getenv("PLAN_PRICES_" + currency)
+		if pricesStr == "" {
+			continue
+		}
+		parts := strings.Split(pricesStr, ",")
+		if len(parts) != 3 {
+			return Config{}, fmt.Errorf("PLAN_PRICES_%s must have a price for each of the 3 plans", currency)
+		}
+		var prices [3]int
+		for i, part := range parts {
+			prices[i], err = strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || prices[i] < 1 {
+				return Config{}, fmt.Errorf("PLAN_PRICES_%s must be positive prices in the currency's minor unit", currency)
+			}
+		}
+		planCurrencyPrices[currency] = prices
+	}
+	jobCreditPackSizes := []int{5, 10}
+	if jobCreditPackSizesStr := os.Getenv("JOB_CREDIT_PACK_SIZES"); jobCreditPackSizesStr != "" {
+		jobCreditPackSizes = make([]int, 0)
+		for _, sizeStr := range strings.Split(jobCreditPackSizesStr, ",") {
+			size, err := strconv.Atoi(strings.TrimSpace(sizeStr))
+			if err != nil || size < 1 {
+				return Config{}, fmt.Errorf("JOB_CREDIT_PACK_SIZES must be positive numbers of credits")
+			}
+			jobCreditPackSizes = append(jobCreditPackSizes, size)
+		}
+	}
+	jobCreditPackDiscount := 20
+	if jobCreditPackDiscountStr := os.Getenv("JOB_CREDIT_PACK_DISCOUNT"); jobCreditPackDiscountStr != "" {
+		jobCreditPackDiscount, err = strconv.Atoi(jobCreditPackDiscountStr)
+		if err != nil || jobCreditPackDiscount < 0 || jobCreditPackDiscount > 99 {
+			return Config{}, fmt.Errorf("JOB_CREDIT_PACK_DISCOUNT must be a percentage between 0 and 99")
+		}
+	}
+	jobCreditExpiryDays := 365
+	if jobCreditExpiryDaysStr := os.Getenv("JOB_CREDIT_EXPIRY_DAYS"); jobCreditExpiryDaysStr != "" {
+		jobCreditExpiryDays, err = strconv.Atoi(jobCreditExpiryDaysStr)
+		if err != nil || jobCreditExpiryDays < 1 {
+			return Config{}, fmt.Errorf("JOB_CREDIT_EXPIRY_DAYS must be a positive number of days")
+		}
+	}
+	jobPlanRenewalNoticeDays := 3
+	if jobPlanRenewalNoticeDaysStr := os.Getenv("JOB_PLAN_RENEWAL_NOTICE_DAYS"); jobPlanRenewalNoticeDaysStr != "" {
+		jobPlanRenewalNoticeDays, err = strconv.Atoi(jobPlanRenewalNoticeDaysStr)
+		if err != nil || jobPlanRenewalNoticeDays < 1 {
+			return Config{}, fmt.Errorf("JOB_PLAN_RENEWAL_NOTICE_DAYS must be a positive number of days")
+		}
+	}
+	jobPlanExpiredOfferDays := 30
+	if jobPlanExpiredOfferDaysStr := os.Getenv("JOB_PLAN_EXPIRED_OFFER_DAYS"); jobPlanExpiredOfferDaysStr != "" {
+		jobPlanExpiredOfferDays, err = strconv.Atoi(jobPlanExpiredOfferDaysStr)
+		if err != nil || jobPlanExpiredOfferDays < 1 {
+			return Config{}, fmt.Errorf("JOB_PLAN_EXPIRED_OFFER_DAYS must be a positive number of days")
+		}
+	}
 
 	return Config{
 		Port:                     port,
@@ -341,6 +467,9 @@ func LoadConfig() (Config, error) {
 		SmtpUser:                 smtpUser,
 		SmtpPassword:             smtpPassword,
 		SmtpHost:                 smtpHost,
+		EmailTransport:           emailTransport,
+		EmailDropDir:             emailDropDir,
+		EmailWebhookToken:        emailWebhookToken,
 		AdminEmail:               adminEmail,
 		SupportEmail:             supportEmail,
 		NoReplyEmail:             noReplyEmail,
@@ -374,7 +503,7 @@ func LoadConfig() (Config, error) {
 		PrimaryColor:             primaryColor,
 		SecondaryColor:           secondaryColor,
 		SiteLogoImageID:          siteLogoImageID,
-		AvailableCurrencies:      []string{"USD", "EUR", "JPY", "GBP", "AUD", "CAD", "CHF", "CNY", "HKD", "NZD", "SEK", "KRW", "SGD", "NOK", "MXN", "INR", "ZAR", "TRY", "BRL"},
+		AvailableCurrencies:      availableCurrencies,
 		AvailableSalaryBands:     []int{10000, 20000, 30000, 40000, 50000, 60000, 70000, 80000, 90000, 100000, 110000, 120000, 130000, 140000, 150000, 160000, 170000, 180000, 190000, 200000, 210000, 220000, 230000, 240000, 250000},
 		PlanID1Price:             planID1Price,
 		PlanID2Price:             planID2Price,
@@ -397,5 +526,17 @@ func LoadConfig() (Config, error) {
 		DevOfferCode2:            devOfferCode2,
 		DevOfferCode3:            devOfferCode3,
 		DevOfferCode4:            devOfferCode4,
+		CvRetentionDays:          cvRetentionDays,
+		CvRetentionNoticeDays:    cvRetentionNoticeDays,
+		DevDirectoryGraceDays:    devDirectoryGraceDays,
+		InvoiceIssuer:            invoiceIssuer,
+		InvoiceIssuerVATID:       invoiceIssuerVATID,
+		PlanCurrencies:           planCurrencies,
+		PlanCurrencyPrices:       planCurrencyPrices,
+		JobCreditPackSizes:       jobCreditPackSizes,
+		JobCreditPackDiscount:    jobCreditPackDiscount,
+		JobCreditExpiryDays:      jobCreditExpiryDays,
+		JobPlanRenewalNoticeDays: jobPlanRenewalNoticeDays,
+		JobPlanExpiredOfferDays:  jobPlanExpiredOfferDays,
 	}, nil
 }
@@ -0,0 +1,125 @@
+package cv
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ContentTypePDF  = "application/pdf"
+	ContentTypeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	ContentTypeODT  = "application/vnd.oasis.opendocument.text"
+	ContentTypeText = "text/plain; charset=utf-8"
+
+	// MaxTextLength caps the extracted text stored with the application
+	MaxTextLength = 100000
+)
+
+// ErrUnsupportedFormat is returned for files that are not a PDF, DOCX, ODT or plain text document
+var ErrUnsupportedFormat = errors.New("unsupported cv format")
+
+var whitespace = regexp.MustCompile(`[ \t\f\v]+`)
+var blankLines = regexp.MustCompile(`\n\s*\n+`)
+
+// DetectContentType sniffs the CV format, office documents are zip archives told apart by their content
+func DetectContentType(b []byte) (string, error) {
+	contentType := http.DetectContentType(b)
+	switch {
+	case contentType == ContentTypePDF:
+		return ContentTypePDF, nil
+	case strings.HasPrefix(contentType, "text/plain"):
+		if !utf8.Valid(b) {
+			return "", ErrUnsupportedFormat
+		}
+		return ContentTypeText, nil
+	case contentType == "application/zip":
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return "", ErrUnsupportedFormat
+		}
+		for _, f := range zr.File {
+			switch f.Name {
+			case "word/document.xml":
+				return ContentTypeDOCX, nil
+			case "mimetype":
+				mimetype, err := readZipFile(f)
+				if err == nil && strings.TrimSpace(string(mimetype)) == ContentTypeODT {
+					return ContentTypeODT, nil
+				}
+			}
+		}
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Extension returns the file extension used when serving a CV of the given content type
+func Extension(contentType string) string {
+	switch contentType {
+	case ContentTypeDOCX:
+		return ".docx"
+	case ContentTypeODT:
+		return ".odt"
+	case ContentTypeText:
+		return ".txt"
+	}
+	return ".pdf"
+}
+
+// ExtractText returns the text content of the CV, collapsing whitespace. Text extraction from PDFs is
+// best effort, PDFs using embedded font encodings may yield little or no text
+func ExtractText(b []byte, contentType string) (string, error) {
+	var text string
+	var err error
+	switch contentType {
+	case ContentTypePDF:
+		text, err = pdfText(b)
+	case ContentTypeDOCX:
+		text, err = zipXMLText(b, "word/document.xml", docxTextBreaks)
+	case ContentTypeODT:
+		text, err = zipXMLText(b, "content.xml", odtTextBreaks)
+	case ContentTypeText:
+		text = string(b)
+	default:
+		return "", ErrUnsupportedFormat
+	}
+	if err != nil {
+		return "", err
+	}
+	return normalizeText(text), nil
+}
+
+func normalizeText(text string) string {
+	text = strings.ToValidUTF8(text, "")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	// drop control characters, postgres doesn't store NUL bytes in text columns
+	text = strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = whitespace.ReplaceAllString(text, " ")
+	text = blankLines.ReplaceAllString(text, "\n\n")
+	text = strings.TrimSpace(text)
+	if len(text) > MaxTextLength {
+		text = strings.ToValidUTF8(text[:MaxTextLength], "")
+	}
+	return text
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
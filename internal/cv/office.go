@@ -0,0 +1,77 @@
+package cv
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// docxTextBreaks maps WordprocessingML elements to the text they stand for
+var docxTextBreaks = map[string]string{
+	"p":   "\n",
+	"br":  "\n",
+	"cr":  "\n",
+	"tab": "\t",
+	"tc":  "\t",
+}
+
+// odtTextBreaks maps OpenDocument text elements to the text they stand for
+var odtTextBreaks = map[string]string{
+	"p":          "\n",
+	"h":          "\n",
+	"line-break": "\n",
+	"tab":        "\t",
+	"s":          " ",
+	"table-cell": "\t",
+}
+
+// zipXMLText returns the character data of the named xml document in the zip archive,
+// adding the given breaks after the matching elements
+func zipXMLText(b []byte, name string, breaks map[string]string) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return "", errors.Wrap(err, "open document archive")
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", errors.Wrapf(err, "open %s", name)
+		}
+		defer rc.Close()
+		return xmlText(io.LimitReader(rc, 20*MaxTextLength), breaks)
+	}
+	return "", errors.Errorf("%s not found in document", name)
+}
+
+func xmlText(r io.Reader, breaks map[string]string) (string, error) {
+	var sb strings.Builder
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return sb.String(), nil
+		}
+		if err != nil {
+			// keep what was extracted from a truncated or malformed document
+			return sb.String(), nil
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.EndElement:
+			if br, ok := breaks[t.Name.Local]; ok {
+				sb.WriteString(br)
+			}
+		}
+		if sb.Len() > MaxTextLength {
+			return sb.String(), nil
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package cv
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	pdfStream = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfHex    = regexp.MustCompile(`^[0-9A-Fa-f\s]*$`)
+)
+
+// pdfText extracts the strings shown by the text operators (Tj, TJ, ' and ") of the page content
+// streams. Only uncompressed and FlateDecode streams are read
+func pdfText(b []byte) (string, error) {
+	var sb strings.Builder
+	for _, loc := range pdfStream.FindAllSubmatchIndex(b, -1) {
+		dict := b[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(b[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		data := b[start : start+end]
+		// fonts, images and other binary streams carry no text
+		if bytes.Contains(dict, []byte("/Subtype")) || bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+			// a truncated stream still yields the text decoded so far
+			data, _ = ioutil.ReadAll(io.LimitReader(zr, 20*MaxTextLength))
+			zr.Close()
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+		pdfContentText(data, &sb)
+		if sb.Len() > MaxTextLength {
+			break
+		}
+	}
+	return sb.String(), nil
+}
+
+// pdfContentText scans a content stream, keeping the operands of the text showing operators
+func pdfContentText(data []byte, sb *strings.Builder) {
+	var operands []string
+	inText := false
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteralString(data[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '<' && i+1 < len(data) && data[i+1] != '<':
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, pdfHexString(data[i+1:i+end]))
+			i += end + 1
+		case c == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case isPDFRegular(c) && c != '[' && c != ']':
+			start := i
+			for i < len(data) && isPDFRegular(data[i]) && data[i] != '[' && data[i] != ']' && data[i] != '(' && data[i] != '<' {
+				i++
+			}
+			op := string(data[start:i])
+			if i == start {
+				i++
+				continue
+			}
+			switch op {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				sb.WriteString("\n")
+			case "Tj", "TJ":
+				if inText {
+					sb.WriteString(strings.Join(operands, ""))
+				}
+			case "'", "\"":
+				if inText {
+					sb.WriteString("\n")
+					sb.WriteString(strings.Join(operands, ""))
+				}
+			case "T*", "Td", "TD":
+				if inText {
+					sb.WriteString("\n")
+				}
+			default:
+				// numbers and names are operands of the next operator
+				if _, err := strconv.ParseFloat(op, 64); err == nil || strings.HasPrefix(op, "/") {
+					continue
+				}
+			}
+			operands = operands[:0]
+		default:
+			i++
+		}
+	}
+}
+
+func isPDFRegular(c byte) bool {
+	return c > ' ' && c != '%' && c != '(' && c != ')' && c != '{' && c != '}'
+}
+
+// pdfLiteralString decodes a (literal string) with balanced parentheses and escapes,
+// returning the string and the number of bytes read
+func pdfLiteralString(data []byte) (string, int) {
+	var sb strings.Builder
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				sb.WriteByte(c)
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return sb.String(), i + 1
+			}
+			sb.WriteByte(c)
+		case '\\':
+			i++
+			if i >= len(data) {
+				return sb.String(), i
+			}
+			switch e := data[i]; e {
+			case 'n', 'r':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					j := i
+					for j < len(data) && j < i+3 && data[j] >= '0' && data[j] <= '7' {
+						j++
+					}
+					n, _ := strconv.ParseUint(string(data[i:j]), 8, 8)
+					sb.WriteRune(rune(n))
+					i = j - 1
+				} else {
+					sb.WriteByte(e)
+				}
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), len(data)
+}
+
+// pdfHexString decodes a <hex string>, two byte glyph codes are treated as UTF-16
+func pdfHexString(data []byte) string {
+	if !pdfHex.Match(data) {
+		return ""
+	}
+	hex := strings.Join(strings.Fields(string(data)), "")
+	if len(hex)%2 == 1 {
+		hex += "0"
+	}
+	raw := make([]byte, 0, len(hex)/2)
+	for i := 0; i < len(hex); i += 2 {
+		n, _ := strconv.ParseUint(hex[i:i+2], 16, 8)
+		raw = append(raw, byte(n))
+	}
+	if len(raw) >= 2 && len(raw)%2 == 0 && raw[0] == 0 {
+		var sb strings.Builder
+		for i := 0; i < len(raw); i += 2 {
+			sb.WriteRune(rune(raw[i])<<8 | rune(raw[i+1]))
+		}
+		return sb.String()
+	}
+	return string(raw)
+}
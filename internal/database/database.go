@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/golang-cafe/job-board/internal/promocode"
 	"github.com/segmentio/ksuid"
 )
 
@@ -198,6 +199,13 @@ func AddFXRate(conn *sql.DB, fx FXRate) error {
 	return err
 }
 
+// GetFXRate returns the latest rate of target per unit of base, sql.ErrNoRows if it was never fetched
+func GetFXRate(conn *sql.DB, base, target string) (FXRate, error) {
+	var fx FXRate
+	err := conn.QueryRow(`SELECT base, target, value, updated_at FROM fx_rate WHERE base = $1 AND target = $2`, base, target).Scan(&fx.Base, &fx.Target, &fx.Value, &fx.UpdatedAt)
+	return fx, err
+}
+
 type EmailSubscriber struct {
 	Email       string
 	Token       string
@@ -220,6 +228,11 @@ func RemoveEmailSubscriber(conn *sql.DB, token string) error {
 	return err
 }
 
+func RemoveEmailSubscriberByEmail(conn *sql.DB, email string) error {
+	_, err := conn.Exec(`DELETE FROM email_subscribers WHERE LOWER(email) = LOWER($1)`, email)
+	return err
+}
+
 func GetEmailSubscribers(conn *sql.DB) ([]EmailSubscriber, error) {
 	rows, err := conn.Query(`SELECT * FROM email_subscribers WHERE confirmed_at IS NOT NULL`)
 	res := make([]EmailSubscriber, 0)
@@ -249,6 +262,28 @@ func GetEmailSubscribers(conn *sql.DB) ([]EmailSubscriber, error) {
 	return res, nil
 }
 
+// GetEmailSubscribersBatch returns up to limit confirmed subscribers ordered by email, starting after the given email
+func GetEmailSubscribersBatch(conn *sql.DB, afterEmail string, limit int) ([]EmailSubscriber, error) {
+	res := make([]EmailSubscriber, 0)
+	rows, err := conn.Query(`SELECT email, token, created_at, confirmed_at FROM email_subscribers WHERE confirmed_at IS NOT NULL AND email > $1 ORDER BY email ASC LIMIT $2`, afterEmail, limit)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e EmailSubscriber
+		var confirmedAt sql.NullTime
+		if err := rows.Scan(&e.Email, &e.Token, &e.CreatedAt, &confirmedAt); err != nil {
+			return res, err
+		}
+		if confirmedAt.Valid {
+			e.ConfirmedAt = &confirmedAt.Time
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
 func CountEmailSubscribers(conn *sql.DB) (int, error) {
 	row := conn.QueryRow(`SELECT count(*) FROM email_subscribers WHERE confirmed_at IS NOT NULL`)
 	var count int
@@ -569,8 +604,9 @@ type PurchaseEvent struct {
 	StripeSessionID string
 	CreatedAt       time.Time
 	CompletedAt     time.Time
-	Amount          int
+	Amount          int // in the minor unit of Currency
 	Currency        string
+	BaseAmount      int // Amount in US cents at the FX rate of the checkout
 	Description     string
 	Email           string
 	JobID           int
@@ -580,7 +616,7 @@ type PurchaseEvent struct {
 
 func GetPurchaseEvents(conn *sql.DB, jobID int) ([]PurchaseEvent, error) {
 	var purchases []PurchaseEvent
-	rows, err := conn.Query(`SELECT stripe_session_id, created_at, completed_at, amount/100 as amount, currency, description, plan_type, plan_duration, job_id FROM purchase_event WHERE job_id = $1 AND completed_at IS NOT NULL`, jobID)
+	rows, err := conn.Query(`SELECT stripe_session_id, created_at, completed_at, amount, currency, base_amount, description, plan_type, plan_duration, job_id FROM purchase_event WHERE job_id = $1 AND completed_at IS NOT NULL`, jobID)
 	if err == sql.ErrNoRows {
 		return purchases, nil
 	}
@@ -589,7 +625,7 @@ func GetPurchaseEvents(conn *sql.DB, jobID int) ([]PurchaseEvent, error) {
 	}
 	for rows.Next() {
 		var p PurchaseEvent
-		if err := rows.Scan(&p.StripeSessionID, &p.CreatedAt, &p.CompletedAt, &p.Amount, &p.Currency, &p.Description, &p.PlanType, &p.PlanDuration, &p.JobID); err != nil {
+		if err := rows.Scan(&p.StripeSessionID, &p.CreatedAt, &p.CompletedAt, &p.Amount, &p.Currency, &p.BaseAmount, &p.Description, &p.PlanType, &p.PlanDuration, &p.JobID); err != nil {
 			return purchases, err
 		}
 		purchases = append(purchases, p)
@@ -598,10 +634,40 @@ func GetPurchaseEvents(conn *sql.DB, jobID int) ([]PurchaseEvent, error) {
 	return purchases, nil
 }
 
-func InitiatePaymentEventForJobAd(conn *sql.DB, sessionID string, amount int64, description string, email string, jobID int, planType string, planDuration int64) error {
-	stmt := `INSERT INTO purchase_event (stripe_session_id, amount, currency, description, ad_type, email, job_id, created_at, plan_type, plan_duration) VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8, $9)`
-	_, err := conn.Exec(stmt, sessionID, amount, "USD", description, 0, email, jobID, planType, planDuration)
-	return err
+// InitiatePaymentEventForJobAd records the checkout of a job ad plan, amount is the price paid in currency after
+// the discount of the promo code redeemed if any and baseAmount the same price in US cents for reporting.
+// The checkout reserves a redemption of the promo code, it returns promocode.ErrFullyRedeemed when completed
+// purchases and checkouts started in the last 24 hours, as long as stripe keeps a session open, use them all up
+func InitiatePaymentEventForJobAd(conn *sql.DB, sessionID string, amount int64, currency string, baseAmount int64, description string, email string, jobID int, planType string, planDuration int64, promoCodeID string, discountAmount int64) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	if promoCodeID != "" {
+		var maxRedemptions, redemptions int
+		// the promo code row lock serialises concurrent checkouts with the same code
+		err = tx.QueryRow(`SELECT max_redemptions FROM promo_code WHERE id = $1 FOR UPDATE`, promoCodeID).Scan(&maxRedemptions)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.QueryRow(`SELECT COUNT(*) FROM purchase_event WHERE promo_code_id = $1 AND (completed_at IS NOT NULL OR created_at > NOW() - INTERVAL '24 hours')`, promoCodeID).Scan(&redemptions)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if maxRedemptions > 0 && redemptions >= maxRedemptions {
+			tx.Rollback()
+			return promocode.ErrFullyRedeemed
+		}
+	}
+	stmt := `INSERT INTO purchase_event (stripe_session_id, amount, currency, base_amount, description, ad_type, email, job_id, created_at, plan_type, plan_duration, promo_code_id, discount_amount) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), $9, $10, NULLIF($11, ''), $12)`
+	_, err = tx.Exec(stmt, sessionID, amount, currency, baseAmount, description, 0, email, jobID, planType, planDuration, promoCodeID, discountAmount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 func InitiatePaymentEventForDeveloperDirectoryAccess(conn *sql.DB, sessionID string, amount int64, description string, recruiterID string, email string, planDuration int64) error {
@@ -610,8 +676,19 @@ func InitiatePaymentEventForDeveloperDirectoryAccess(conn *sql.DB, sessionID str
 	return err
 }
 
-func SaveSuccessfulPaymentForJobAd(conn *sql.DB, sessionID string) (int, error) {
-	res := conn.QueryRow(`WITH rows AS (UPDATE purchase_event SET completed_at = NOW() WHERE stripe_session_id = $1 AND completed_at IS NULL RETURNING 1) SELECT count(*) as c FROM rows;`, sessionID)
+// SaveDevDirectorySubscriptionPayment records a paid developer directory subscription invoice with the
+// one-off purchases, with the invoice ID in place of the checkout session ID. Invoices already recorded
+// are skipped
+func SaveDevDirectorySubscriptionPayment(conn *sql.DB, invoiceID string, paymentIntentID string, amount int64, currency string, description string, recruiterID string, email string, planDuration int64, expiredAt time.Time) error {
+	stmt := `INSERT INTO developer_directory_purchase_event (stripe_session_id, amount, currency, description, created_at, completed_at, expired_at, recruiter_id, email, duration, stripe_payment_intent_id)
+		VALUES ($1, $2, $3, $4, NOW(), NOW(), $5, $6, $7, $8, NULLIF($9, ''))
+		ON CONFLICT (stripe_session_id) DO NOTHING`
+	_, err := conn.Exec(stmt, invoiceID, amount, currency, description, expiredAt, recruiterID, email, planDuration, paymentIntentID)
+	return err
+}
+
+func SaveSuccessfulPaymentForJobAd(conn *sql.DB, sessionID string, paymentIntentID string) (int, error) {
+	res := conn.QueryRow(`WITH rows AS (UPDATE purchase_event SET completed_at = NOW(), stripe_payment_intent_id = NULLIF($2, '') WHERE stripe_session_id = $1 AND completed_at IS NULL RETURNING 1) SELECT count(*) as c FROM rows;`, sessionID, paymentIntentID)
 	var affected int
 	err := res.Scan(&affected)
 	if err != nil {
@@ -620,8 +697,8 @@ func SaveSuccessfulPaymentForJobAd(conn *sql.DB, sessionID string) (int, error)
 	return affected, nil
 }
 
-func SaveSuccessfulPaymentForDevDirectory(conn *sql.DB, sessionID string) (int, error) {
-	res := conn.QueryRow(`WITH rows AS (UPDATE developer_directory_purchase_event SET completed_at = NOW() WHERE stripe_session_id = $1 AND completed_at IS NULL RETURNING 1) SELECT count(*) as c FROM rows;`, sessionID)
+func SaveSuccessfulPaymentForDevDirectory(conn *sql.DB, sessionID string, paymentIntentID string) (int, error) {
+	res := conn.QueryRow(`WITH rows AS (UPDATE developer_directory_purchase_event SET completed_at = NOW(), stripe_payment_intent_id = NULLIF($2, '') WHERE stripe_session_id = $1 AND completed_at IS NULL RETURNING 1) SELECT count(*) as c FROM rows;`, sessionID, paymentIntentID)
 	var affected int
 	err := res.Scan(&affected)
 	if err != nil {
@@ -685,6 +762,61 @@ func GetJobAdPurchaseEventBySessionID(conn *sql.DB, sessionID string) (PurchaseE
 	return p, nil
 }
 
+const (
+	PaymentReversalRefund  = "refund"
+	PaymentReversalDispute = "dispute"
+)
+
+// CheckoutSessionIDByPaymentIntentID returns the checkout session of the job ad, developer directory or
+// job credit pack purchase paid with the payment intent, or an empty string if none was recorded
+func CheckoutSessionIDByPaymentIntentID(conn *sql.DB, paymentIntentID string) (string, error) {
+	res := conn.QueryRow(
+		`SELECT stripe_session_id FROM purchase_event WHERE stripe_payment_intent_id = $1
+		UNION ALL
+		SELECT stripe_session_id FROM developer_directory_purchase_event WHERE stripe_payment_intent_id = $1
+		UNION ALL
+		SELECT stripe_session_id FROM job_credit_pack WHERE stripe_payment_intent_id = $1
+		LIMIT 1`,
+		paymentIntentID,
+	)
+	var sessionID string
+	err := res.Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return sessionID, err
+}
+
+// SaveReversedPaymentForJobAd records a refund or dispute against a job ad purchase
+func SaveReversedPaymentForJobAd(conn *sql.DB, sessionID string, reversal string) error {
+	_, err := conn.Exec(
+		`UPDATE purchase_event SET
+			refunded_at = CASE WHEN $2::text = $3 THEN NOW() ELSE refunded_at END,
+			disputed_at = CASE WHEN $2::text = $4 THEN NOW() ELSE disputed_at END
+		WHERE stripe_session_id = $1`,
+		sessionID,
+		reversal,
+		PaymentReversalRefund,
+		PaymentReversalDispute,
+	)
+	return err
+}
+
+// SaveReversedPaymentForDevDirectory records a refund or dispute against a developer directory purchase
+func SaveReversedPaymentForDevDirectory(conn *sql.DB, sessionID string, reversal string) error {
+	_, err := conn.Exec(
+		`UPDATE developer_directory_purchase_event SET
+			refunded_at = CASE WHEN $2::text = $3 THEN NOW() ELSE refunded_at END,
+			disputed_at = CASE WHEN $2::text = $4 THEN NOW() ELSE disputed_at END
+		WHERE stripe_session_id = $1`,
+		sessionID,
+		reversal,
+		PaymentReversalRefund,
+		PaymentReversalDispute,
+	)
+	return err
+}
+
 type Media struct {
 	Bytes     []byte
 	MediaType string
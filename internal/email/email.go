@@ -1,28 +1,40 @@
 package email
 
 import (
+	"bytes"
 	"encoding/base64"
-	"net/smtp"
+	"errors"
 	"fmt"
+	"html"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
+var ErrRecipientSuppressed = errors.New("email recipient is on the suppression list")
+
 type Client struct {
 	senderAddress  string
 	noReplyAddress string
 	siteName       string
 	client         http.Client
-	smtpUser       string
-	smtpPassword   string
-	smtpHost       string
+	sender         Sender
+	repo           *Repository
 	baseURL        string
-	isLocal        bool
 }
 
 type Attachment struct {
-	Name    string `json:"name"`
-	B64Data string `json:"content"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType,omitempty"`
+	B64Data     string `json:"content"`
 }
 
 type Address struct {
@@ -44,16 +56,19 @@ type EmailMessageWithAttachment struct {
 	Attachment []Attachment `json:"attachment,omitempty"`
 }
 
-func NewClient(smtpUser, smtpPassword, smtpHost, senderAddress, noReplyAddress, siteName string, isLocal bool) (Client, error) {
+// NewClient returns an email client delivering through sender, when repo is not nil messages
+// are enqueued and delivered later by the outbox worker and suppressed recipients are skipped
+func NewClient(sender Sender, repo *Repository, senderAddress, noReplyAddress, siteName string) (Client, error) {
+	if sender == nil {
+		return Client{}, errors.New("email sender cannot be nil")
+	}
 	return Client{
 		client:         *http.DefaultClient,
-		smtpUser:       smtpUser,
-		smtpPassword:   smtpPassword,
-		smtpHost:       smtpHost,
+		sender:         sender,
+		repo:           repo,
 		senderAddress:  senderAddress,
 		siteName:       siteName,
 		noReplyAddress: noReplyAddress,
-		isLocal:        isLocal,
 		baseURL:        "https://api.sendinblue.com"}, nil
 }
 
@@ -77,37 +92,281 @@ func (e Client) DefaultAdminAddress() string {
 	return e.senderAddress
 }
 
+// SendHTMLEmail sends a plain text email, the html alternative is generated from the text body
 func (e Client) SendHTMLEmail(from, to, replyTo Address, subject, text string) error {
-	if e.isLocal {
-		log.Printf(
-			"SendHTMLEmail: from: %v, to: %s, replyTo: %v, subject: %s, text: %s",
-			from,
-			to,
-			replyTo,
-			subject,
-			text,
-		)
-		return nil
-	}
-	auth := smtp.PlainAuth("", e.smtpUser, e.smtpPassword, e.smtpHost)
-	header := make(map[string]string)
-	header["From"] = e.smtpUser
-	header["To"] = to.Email
-	header["Subject"] = subject
-	header["MIME-Version"] = "1.0"
-	header["Content-Type"] = "text/plain; charset=\"utf-8\""
-	header["Content-Transfer-Encoding"] = "base64"
-	message := ""
-	for k, v := range header {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
-	message += "\r\n" + base64.StdEncoding.EncodeToString([]byte(text))
-
-	err := smtp.SendMail(e.smtpHost+":25", auth, e.smtpUser, []string{to.Email}, []byte(message))
+	return e.SendEmail(EmailMessageWithAttachment{
+		EmailMessage: EmailMessage{
+			Sender:      from,
+			To:          []Address{to},
+			ReplyTo:     replyTo,
+			Subject:     subject,
+			TextContent: text,
+			HtmlContent: TextToHTML(text),
+		},
+	})
+}
+
+// SendEmail sends a multipart message with the text and html bodies of msg as alternatives and
+// any attachments as additional parts. The message is enqueued in the outbox when one is configured
+func (e Client) SendEmail(msg EmailMessageWithAttachment) error {
+	_, err := e.QueueEmail(msg)
+	return err
+}
+
+// QueueEmail sends msg like SendEmail and returns the ID of its outbox message, to follow its delivery.
+// The ID is empty when there is no outbox and the message was delivered straight away
+func (e Client) QueueEmail(msg EmailMessageWithAttachment) (string, error) {
+	if len(msg.To) == 0 {
+		return "", errors.New("email message has no recipients")
+	}
+	if e.repo == nil {
+		return "", e.sender.Send(msg)
+	}
+	msg, err := e.withoutSuppressedRecipients(msg)
+	if err != nil {
+		return "", err
+	}
+	return e.repo.Enqueue(msg)
+}
+
+// withoutSuppressedRecipients drops recipients on the suppression list, it returns
+// ErrRecipientSuppressed when none are left
+func (e Client) withoutSuppressedRecipients(msg EmailMessageWithAttachment) (EmailMessageWithAttachment, error) {
+	addresses := make([]string, 0, len(msg.To))
+	for _, to := range msg.To {
+		addresses = append(addresses, to.Email)
+	}
+	suppressed, err := e.repo.SuppressedAddresses(addresses)
+	if err != nil {
+		return msg, err
+	}
+	if len(suppressed) == 0 {
+		return msg, nil
+	}
+	to := make([]Address, 0, len(msg.To))
+	for _, a := range msg.To {
+		if _, ok := suppressed[strings.ToLower(a.Email)]; !ok {
+			to = append(to, a)
+		}
+	}
+	if len(to) == 0 {
+		return msg, ErrRecipientSuppressed
+	}
+	msg.To = to
+	return msg, nil
+}
+
+// ProcessOutbox delivers up to batchSize due messages from the outbox
+func (e Client) ProcessOutbox(batchSize int) (int, int, error) {
+	if e.repo == nil {
+		return 0, 0, errors.New("email outbox is not configured")
+	}
+	messages, err := e.repo.ClaimDue(batchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	var sent, failed int
+	for _, m := range messages {
+		// the address may have bounced since the message was enqueued
+		msg, err := e.withoutSuppressedRecipients(m.Message)
+		if err == ErrRecipientSuppressed {
+			failed++
+			if err := e.repo.MarkDead(m.ID, err); err != nil {
+				return sent, failed, err
+			}
+			continue
+		}
+		if err != nil {
+			return sent, failed, err
+		}
+		if sendErr := e.sender.Send(msg); sendErr != nil {
+			failed++
+			if err := e.repo.MarkFailed(m, sendErr); err != nil {
+				return sent, failed, err
+			}
+			continue
+		}
+		sent++
+		if err := e.repo.MarkSent(m.ID); err != nil {
+			return sent, failed, err
+		}
+	}
+	return sent, failed, nil
+}
+
+// RunOutboxWorker processes the outbox every interval, it blocks and is meant to be run in its own goroutine
+func (e Client) RunOutboxWorker(interval time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for {
+			sent, failed, err := e.ProcessOutbox(batchSize)
+			if err != nil {
+				log.Printf("email outbox worker: %v", err)
+				break
+			}
+			if failed > 0 {
+				log.Printf("email outbox worker: %d messages failed, %d sent", failed, sent)
+			}
+			// keep draining while batches come back full
+			if sent+failed < batchSize {
+				break
+			}
+		}
+	}
+}
+
+// BuildMessage renders msg as a MIME message including headers, ready to be handed to an SMTP server
+func BuildMessage(msg EmailMessageWithAttachment, date time.Time) ([]byte, error) {
+	to := make([]string, 0, len(msg.To))
+	for _, a := range msg.To {
+		to = append(to, formatAddress(a))
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", formatAddress(msg.Sender))
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(to, ", "))
+	if msg.ReplyTo.Email != "" {
+		fmt.Fprintf(buf, "Reply-To: %s\r\n", formatAddress(msg.ReplyTo))
+	}
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	header, content, err := bodyPart(msg.TextContent, msg.HtmlContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.Attachment) == 0 {
+		writeHeader(buf, header)
+		buf.Write(content)
+		return buf.Bytes(), nil
+	}
+
+	body := &bytes.Buffer{}
+	writer := newMultipartWriter(body)
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", writer.Boundary())
+	w, err := writer.CreatePart(header)
 	if err != nil {
-		log.Println("error send mail", err.Error())
-		return err
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	for _, a := range msg.Attachment {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(a.Name))
+		}
+		// types guessed from the extension may carry parameters, e.g. text/plain; charset=utf-8
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			mediaType, params = "application/octet-stream", map[string]string{}
+		}
+		params["name"] = a.Name
+		partHeader := textproto.MIMEHeader{}
+		partHeader.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+		partHeader.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		partHeader.Set("Content-Transfer-Encoding", "base64")
+		w, err := writer.CreatePart(partHeader)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, wrapBase64(a.B64Data)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// TextToHTML converts a plain text email body into a minimal html body
+func TextToHTML(text string) string {
+	return "<html><body>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>\n") + "</body></html>"
+}
+
+// bodyPart returns the headers and content for the given text and html bodies,
+// as a multipart/alternative when both are set
+func bodyPart(text, html string) (textproto.MIMEHeader, []byte, error) {
+	header := textproto.MIMEHeader{}
+	if html == "" || text == "" {
+		contentType, content := "text/plain", text
+		if text == "" {
+			contentType, content = "text/html", html
+		}
+		header.Set("Content-Type", contentType+"; charset=\"utf-8\"")
+		header.Set("Content-Transfer-Encoding", "base64")
+		return header, []byte(wrapBase64(base64.StdEncoding.EncodeToString([]byte(content)))), nil
 	}
+	body := &bytes.Buffer{}
+	writer := newMultipartWriter(body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=\"utf-8\"", text},
+		{"text/html; charset=\"utf-8\"", html},
+	} {
+		partHeader := textproto.MIMEHeader{}
+		partHeader.Set("Content-Type", part.contentType)
+		partHeader.Set("Content-Transfer-Encoding", "base64")
+		w, err := writer.CreatePart(partHeader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := io.WriteString(w, wrapBase64(base64.StdEncoding.EncodeToString([]byte(part.content)))); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+	header.Set("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", writer.Boundary()))
+	return header, body.Bytes(), nil
+}
+
+// multipartBoundary returns the boundary of the next multipart writer, an empty string
+// keeps the random boundary. Tests replace it to get reproducible messages
+var multipartBoundary = func() string { return "" }
 
-	return nil
+func newMultipartWriter(w io.Writer) *multipart.Writer {
+	writer := multipart.NewWriter(w)
+	if boundary := multipartBoundary(); boundary != "" {
+		writer.SetBoundary(boundary)
+	}
+	return writer
+}
+
+// writeHeader writes the part headers in a stable order followed by the blank line separating the body
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func formatAddress(a Address) string {
+	if a.Name == "" {
+		return a.Email
+	}
+	return (&mail.Address{Name: a.Name, Address: a.Email}).String()
+}
+
+// wrapBase64 splits encoded content in lines of 76 characters as required by RFC 2045
+func wrapBase64(encoded string) string {
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	return b.String()
 }
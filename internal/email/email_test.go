@@ -0,0 +1,281 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var testDate = time.Date(2021, time.March, 4, 10, 30, 0, 0, time.UTC)
+
+var testMessages = map[string]EmailMessageWithAttachment{
+	"text": {
+		EmailMessage: EmailMessage{
+			Sender:      Address{Name: "Golang Cafe", Email: "team@golang.cafe"},
+			To:          []Address{{Email: "dev@example.com"}},
+			Subject:     "Your job alert",
+			TextContent: "Hi,\nhere are the newest jobs.",
+		},
+	},
+	"alternative": {
+		EmailMessage: EmailMessage{
+			Sender:      Address{Name: "Golang Cafe", Email: "team@golang.cafe"},
+			To:          []Address{{Name: "Jane Doe", Email: "jane@example.com"}, {Email: "dev@example.com"}},
+			ReplyTo:     Address{Name: "Support", Email: "support@golang.cafe"},
+			Subject:     "Ünïcode subject",
+			TextContent: "Hi Jane,\nwelcome.",
+			HtmlContent: "<p>Hi Jane,<br>welcome.</p>",
+		},
+	},
+	"attachment": {
+		EmailMessage: EmailMessage{
+			Sender:      Address{Name: "Golang Cafe", Email: "team@golang.cafe"},
+			To:          []Address{{Email: "hiring@example.com"}},
+			ReplyTo:     Address{Email: "applicant@example.com"},
+			Subject:     "New applicant",
+			TextContent: "A new applicant applied, their CV is attached.",
+			HtmlContent: "<p>A new applicant applied, their CV is attached.</p>",
+		},
+		Attachment: []Attachment{
+			{Name: "cv.pdf", ContentType: "application/pdf", B64Data: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("%PDF-1.4 "), 20))},
+			// without a content type, guessed from the extension with the builtin mime table
+			{Name: "notes.html", B64Data: base64.StdEncoding.EncodeToString([]byte("<p>notes</p>"))},
+		},
+	},
+}
+
+// withFixedBoundaries makes BuildMessage use numbered multipart boundaries for the duration of the test
+func withFixedBoundaries(t *testing.T) {
+	n := 0
+	multipartBoundary = func() string {
+		n++
+		return fmt.Sprintf("boundary-%d", n)
+	}
+	t.Cleanup(func() {
+		multipartBoundary = func() string { return "" }
+	})
+}
+
+func TestBuildMessage(t *testing.T) {
+	for name, msg := range testMessages {
+		t.Run(name, func(t *testing.T) {
+			withFixedBoundaries(t)
+			got, err := BuildMessage(msg, testDate)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("BuildMessage(%s) doesn't match %s, run go test -update to regenerate it\ngot:\n%s", name, golden, got)
+			}
+		})
+	}
+}
+
+// smtpStandIn is a minimal SMTP server accepting a single message
+type smtpStandIn struct {
+	listener   net.Listener
+	from       string
+	recipients []string
+	data       []byte
+	err        error
+	done       chan struct{}
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	s := &smtpStandIn{listener: l, done: make(chan struct{})}
+	t.Cleanup(func() { l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpStandIn) serve() {
+	defer close(s.done)
+	c, err := s.listener.Accept()
+	if err != nil {
+		s.err = err
+		return
+	}
+	defer c.Close()
+	conn := textproto.NewConn(c)
+	reply := func(lines ...string) {
+		for _, l := range lines {
+			conn.PrintfLine("%s", l)
+		}
+	}
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			s.err = err
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250-localhost", "250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			s.from = line
+			reply("250 2.1.0 Ok")
+		case "RCPT":
+			s.recipients = append(s.recipients, line)
+			reply("250 2.1.5 Ok")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			s.data, err = ioutil.ReadAll(conn.DotReader())
+			if err != nil {
+				s.err = err
+				return
+			}
+			reply("250 2.0.0 Ok: queued")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("250 Ok")
+		}
+	}
+}
+
+func TestSMTPSenderSend(t *testing.T) {
+	server := newSMTPStandIn(t)
+	_, port, err := net.SplitHostPort(server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := SMTPSender{user: "team@golang.cafe", password: "secret", host: "127.0.0.1", port: port}
+	msg := testMessages["attachment"]
+	if err := sender.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	select {
+	case <-server.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the SMTP stand-in didn't receive the message")
+	}
+	if server.err != nil {
+		t.Fatalf("SMTP stand-in: %v", server.err)
+	}
+	if server.from != "MAIL FROM:<team@golang.cafe>" && !strings.HasPrefix(server.from, "MAIL FROM:<team@golang.cafe> ") {
+		t.Errorf("envelope sender = %q", server.from)
+	}
+	if len(server.recipients) != 1 || server.recipients[0] != "RCPT TO:<hiring@example.com>" {
+		t.Errorf("envelope recipients = %q", server.recipients)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(server.data))
+	if err != nil {
+		t.Fatalf("unable to parse the message received: %v", err)
+	}
+	if got := m.Header.Get("From"); got != `"Golang Cafe" <team@golang.cafe>` {
+		t.Errorf("From = %q", got)
+	}
+	if got := m.Header.Get("Reply-To"); got != "applicant@example.com" {
+		t.Errorf("Reply-To = %q", got)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q, %v", m.Header.Get("Content-Type"), err)
+	}
+	parts := readParts(t, m.Body, params["boundary"])
+	if len(parts) != 3 {
+		t.Fatalf("got %d parts in the multipart/mixed body, want the bodies and 2 attachments", len(parts))
+	}
+
+	mediaType, params, err = mime.ParseMediaType(parts[0].header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("first part Content-Type = %q, %v", parts[0].header.Get("Content-Type"), err)
+	}
+	bodies := readParts(t, bytes.NewReader(parts[0].content), params["boundary"])
+	if len(bodies) != 2 {
+		t.Fatalf("got %d alternatives, want text and html", len(bodies))
+	}
+	for i, want := range []struct{ contentType, content string }{
+		{`text/plain; charset="utf-8"`, msg.TextContent},
+		{`text/html; charset="utf-8"`, msg.HtmlContent},
+	} {
+		if got := bodies[i].header.Get("Content-Type"); got != want.contentType {
+			t.Errorf("alternative %d Content-Type = %q, want %q", i, got, want.contentType)
+		}
+		if got := decodeBase64(t, bodies[i].content); got != want.content {
+			t.Errorf("alternative %d content = %q, want %q", i, got, want.content)
+		}
+	}
+
+	for i, a := range msg.Attachment {
+		p := parts[i+1]
+		_, params, err := mime.ParseMediaType(p.header.Get("Content-Disposition"))
+		if err != nil || params["filename"] != a.Name {
+			t.Errorf("attachment %d Content-Disposition = %q, %v", i, p.header.Get("Content-Disposition"), err)
+		}
+		if got := base64.StdEncoding.EncodeToString([]byte(decodeBase64(t, p.content))); got != a.B64Data {
+			t.Errorf("attachment %s content doesn't match", a.Name)
+		}
+	}
+	if got := parts[2].header.Get("Content-Type"); got != `text/html; charset=utf-8; name=notes.html` {
+		t.Errorf("attachment without content type got Content-Type %q", got)
+	}
+}
+
+type part struct {
+	header  textproto.MIMEHeader
+	content []byte
+}
+
+func readParts(t *testing.T, r io.Reader, boundary string) []part {
+	t.Helper()
+	parts := make([]part, 0)
+	mr := multipart.NewReader(r, boundary)
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("unable to read multipart body: %v", err)
+		}
+		content, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatalf("unable to read part: %v", err)
+		}
+		parts = append(parts, part{header: p.Header, content: content})
+	}
+}
+
+func decodeBase64(t *testing.T, content []byte) string {
+	t.Helper()
+	decoded, err := base64.StdEncoding.DecodeString(strings.NewReplacer("\r", "", "\n", "").Replace(string(content)))
+	if err != nil {
+		t.Fatalf("unable to decode base64 content: %v", err)
+	}
+	return string(decoded)
+}
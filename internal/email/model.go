@@ -0,0 +1,90 @@
+package email
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+
+	// OutboxMaxAttempts is the number of delivery attempts before a message is dead-lettered
+	OutboxMaxAttempts = 8
+
+	outboxBaseBackoff = 1 * time.Minute
+	outboxMaxBackoff  = 6 * time.Hour
+	// outboxClaimTimeout is how long a claimed message is hidden from other workers
+	outboxClaimTimeout = 5 * time.Minute
+)
+
+const (
+	FeedbackTypeBounce    = "bounce"
+	FeedbackTypeComplaint = "complaint"
+
+	BounceTypeHard = "hard"
+	BounceTypeSoft = "soft"
+
+	SuppressionReasonHardBounce = "bounce_hard"
+	SuppressionReasonSoftBounce = "bounce_soft"
+	SuppressionReasonComplaint  = "complaint"
+
+	// SoftBounceThreshold is the number of soft bounces after which an address is suppressed
+	SoftBounceThreshold = 3
+)
+
+type OutboxMessage struct {
+	ID            string
+	Message       EmailMessageWithAttachment
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	SentAt        pq.NullTime
+}
+
+type OutboxStats struct {
+	Pending int
+	Sent    int
+	Dead    int
+}
+
+// FeedbackEvent is a bounce or complaint notification received from the email provider
+type FeedbackEvent struct {
+	Type       string `json:"type"`
+	Email      string `json:"email"`
+	BounceType string `json:"bounce_type,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+type Suppression struct {
+	Email        string
+	Reason       string
+	Detail       string
+	BounceCount  int
+	SuppressedAt pq.NullTime
+	UpdatedAt    time.Time
+	CreatedAt    time.Time
+}
+
+type SuppressionStats struct {
+	HardBounces int
+	SoftBounces int
+	Complaints  int
+	Pending     int // soft bounced addresses below SoftBounceThreshold
+}
+
+// outboxBackoff returns the delay before the next delivery attempt, doubling on every failed attempt
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,281 @@
+package email
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/segmentio/ksuid"
+)
+
+// Repository stores the outbox, where messages are enqueued by request handlers and
+// delivered by the outbox worker, and the suppression list of bounced addresses
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db}
+}
+
+// Enqueue adds the message to the outbox and returns its ID
+func (r *Repository) Enqueue(msg EmailMessageWithAttachment) (string, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	_, err = r.db.Exec(
+		`INSERT INTO email_outbox (id, message, status, attempts, next_attempt_at, created_at) VALUES ($1, $2, $3, 0, NOW(), NOW())`,
+		id.String(),
+		payload,
+		OutboxStatusPending,
+	)
+	return id.String(), err
+}
+
+// ClaimDue returns up to limit pending messages due for delivery and pushes their next attempt
+// forward, so that concurrent workers don't pick up the same messages
+func (r *Repository) ClaimDue(limit int) ([]OutboxMessage, error) {
+	rows, err := r.db.Query(
+		`UPDATE email_outbox SET attempts = attempts + 1, next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = $2 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at ASC
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, message, status, attempts, COALESCE(last_error, ''), next_attempt_at, created_at, sent_at`,
+		time.Now().UTC().Add(outboxClaimTimeout),
+		OutboxStatusPending,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxMessages(rows)
+}
+
+func (r *Repository) MarkSent(id string) error {
+	_, err := r.db.Exec(`UPDATE email_outbox SET status = $1, sent_at = NOW(), last_error = NULL WHERE id = $2`, OutboxStatusSent, id)
+	return err
+}
+
+// MarkFailed schedules the next attempt with exponential backoff, or dead-letters the message
+// once it has reached OutboxMaxAttempts
+func (r *Repository) MarkFailed(m OutboxMessage, sendErr error) error {
+	status := OutboxStatusPending
+	if m.Attempts >= OutboxMaxAttempts {
+		status = OutboxStatusDead
+	}
+	_, err := r.db.Exec(
+		`UPDATE email_outbox SET status = $1, last_error = $2, next_attempt_at = $3 WHERE id = $4`,
+		status,
+		sendErr.Error(),
+		time.Now().UTC().Add(outboxBackoff(m.Attempts)),
+		m.ID,
+	)
+	return err
+}
+
+// MarkDead dead-letters the message without further attempts
+func (r *Repository) MarkDead(id string, reason error) error {
+	_, err := r.db.Exec(`UPDATE email_outbox SET status = $1, last_error = $2 WHERE id = $3`, OutboxStatusDead, reason.Error(), id)
+	return err
+}
+
+// Retry puts a dead-lettered message back in the queue with a fresh attempts counter
+func (r *Repository) Retry(id string) error {
+	res, err := r.db.Exec(`UPDATE email_outbox SET status = $1, attempts = 0, next_attempt_at = NOW() WHERE id = $2 AND status = $3`, OutboxStatusPending, id, OutboxStatusDead)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) ListByStatus(status string, limit int) ([]OutboxMessage, error) {
+	rows, err := r.db.Query(
+		`SELECT id, message, status, attempts, COALESCE(last_error, ''), next_attempt_at, created_at, sent_at
+		FROM email_outbox WHERE status = $1 ORDER BY created_at DESC LIMIT $2`,
+		status,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxMessages(rows)
+}
+
+func (r *Repository) Stats() (OutboxStats, error) {
+	var stats OutboxStats
+	err := r.db.QueryRow(
+		`SELECT
+			COUNT(*) FILTER (WHERE status = $1),
+			COUNT(*) FILTER (WHERE status = $2),
+			COUNT(*) FILTER (WHERE status = $3)
+		FROM email_outbox`,
+		OutboxStatusPending,
+		OutboxStatusSent,
+		OutboxStatusDead,
+	).Scan(&stats.Pending, &stats.Sent, &stats.Dead)
+	return stats, err
+}
+
+// DeleteFinishedBefore removes the messages delivered or dead-lettered before t, they may contain attachments
+func (r *Repository) DeleteFinishedBefore(t time.Time) error {
+	_, err := r.db.Exec(
+		`DELETE FROM email_outbox WHERE (status = $1 AND sent_at < $2) OR (status = $3 AND next_attempt_at < $2)`,
+		OutboxStatusSent,
+		t,
+		OutboxStatusDead,
+	)
+	return err
+}
+
+// RecordFeedback stores a bounce or complaint, hard bounces and complaints suppress the address
+// straight away while soft bounces only do once they reach SoftBounceThreshold.
+// It returns whether the address is now suppressed
+func (r *Repository) RecordFeedback(event FeedbackEvent) (bool, error) {
+	reason := SuppressionReasonComplaint
+	if event.Type == FeedbackTypeBounce {
+		reason = SuppressionReasonHardBounce
+		if event.BounceType == BounceTypeSoft {
+			reason = SuppressionReasonSoftBounce
+		}
+	}
+	soft := reason == SuppressionReasonSoftBounce
+	// postgres can't deduce a single type for a param bound both to the reason column and compared,
+	// so the soft bounce checks are decided here
+	suppressedAt := pq.NullTime{Time: time.Now().UTC(), Valid: !soft}
+	var suppressed bool
+	err := r.db.QueryRow(
+		`INSERT INTO email_suppression (email, reason, detail, bounce_count, suppressed_at, updated_at, created_at)
+		VALUES (LOWER($1), $2, $3, 1, $4, NOW(), NOW())
+		ON CONFLICT (email) DO UPDATE SET
+			reason = CASE WHEN email_suppression.suppressed_at IS NOT NULL AND $5 THEN email_suppression.reason ELSE EXCLUDED.reason END,
+			detail = EXCLUDED.detail,
+			bounce_count = email_suppression.bounce_count + 1,
+			suppressed_at = COALESCE(
+				email_suppression.suppressed_at,
+				EXCLUDED.suppressed_at,
+				CASE WHEN email_suppression.bounce_count + 1 >= $6 THEN NOW() END
+			),
+			updated_at = NOW()
+		RETURNING suppressed_at IS NOT NULL`,
+		event.Email,
+		reason,
+		event.Detail,
+		suppressedAt,
+		soft,
+		SoftBounceThreshold,
+	).Scan(&suppressed)
+	return suppressed, err
+}
+
+// SuppressedAddresses returns which of the given addresses must not receive any email
+func (r *Repository) SuppressedAddresses(addresses []string) (map[string]struct{}, error) {
+	lower := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		lower = append(lower, strings.ToLower(a))
+	}
+	rows, err := r.db.Query(`SELECT email FROM email_suppression WHERE email = ANY($1) AND suppressed_at IS NOT NULL`, pq.Array(lower))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	suppressed := make(map[string]struct{})
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		suppressed[email] = struct{}{}
+	}
+	return suppressed, rows.Err()
+}
+
+func (r *Repository) ListSuppressions(limit int) ([]Suppression, error) {
+	rows, err := r.db.Query(
+		`SELECT email, reason, COALESCE(detail, ''), bounce_count, suppressed_at, updated_at, created_at
+		FROM email_suppression ORDER BY updated_at DESC LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	suppressions := make([]Suppression, 0)
+	for rows.Next() {
+		var s Suppression
+		if err := rows.Scan(&s.Email, &s.Reason, &s.Detail, &s.BounceCount, &s.SuppressedAt, &s.UpdatedAt, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, s)
+	}
+	return suppressions, rows.Err()
+}
+
+func (r *Repository) SuppressionStats() (SuppressionStats, error) {
+	var stats SuppressionStats
+	err := r.db.QueryRow(
+		`SELECT
+			COUNT(*) FILTER (WHERE reason = $1 AND suppressed_at IS NOT NULL),
+			COUNT(*) FILTER (WHERE reason = $2 AND suppressed_at IS NOT NULL),
+			COUNT(*) FILTER (WHERE reason = $3),
+			COUNT(*) FILTER (WHERE suppressed_at IS NULL)
+		FROM email_suppression`,
+		SuppressionReasonHardBounce,
+		SuppressionReasonSoftBounce,
+		SuppressionReasonComplaint,
+	).Scan(&stats.HardBounces, &stats.SoftBounces, &stats.Complaints, &stats.Pending)
+	return stats, err
+}
+
+// RemoveSuppression allows sending to the address again
+func (r *Repository) RemoveSuppression(email string) error {
+	res, err := r.db.Exec(`DELETE FROM email_suppression WHERE email = LOWER($1)`, email)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanOutboxMessages(rows *sql.Rows) ([]OutboxMessage, error) {
+	defer rows.Close()
+	messages := make([]OutboxMessage, 0)
+	for rows.Next() {
+		var m OutboxMessage
+		var payload []byte
+		if err := rows.Scan(&m.ID, &payload, &m.Status, &m.Attempts, &m.LastError, &m.NextAttemptAt, &m.CreatedAt, &m.SentAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &m.Message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
@@ -0,0 +1,114 @@
+package email
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	TransportSMTP = "smtp"
+	TransportLog  = "log"
+	TransportFile = "file"
+)
+
+// Sender delivers a message through a transport
+type Sender interface {
+	Send(msg EmailMessageWithAttachment) error
+}
+
+type SMTPSender struct {
+	user     string
+	password string
+	host     string
+	port     string
+}
+
+func NewSMTPSender(user, password, host string) SMTPSender {
+	return SMTPSender{user: user, password: password, host: host, port: "25"}
+}
+
+func (s SMTPSender) Send(msg EmailMessageWithAttachment) error {
+	if msg.Sender.Email == "" {
+		msg.Sender.Email = s.user
+	}
+	message, err := BuildMessage(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	recipients := make([]string, 0, len(msg.To))
+	for _, to := range msg.To {
+		recipients = append(recipients, to.Email)
+	}
+	auth := smtp.PlainAuth("", s.user, s.password, s.host)
+	err = smtp.SendMail(s.host+":"+s.port, auth, s.user, recipients, message)
+	if err != nil {
+		log.Println("error send mail", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// LogSender only logs messages, used for local development
+type LogSender struct{}
+
+func (s LogSender) Send(msg EmailMessageWithAttachment) error {
+	attachmentNames := make([]string, 0, len(msg.Attachment))
+	for _, a := range msg.Attachment {
+		attachmentNames = append(attachmentNames, a.Name)
+	}
+	log.Printf(
+		"SendEmail: from: %v, to: %v, replyTo: %v, subject: %s, attachments: %v, text: %s",
+		msg.Sender,
+		msg.To,
+		msg.ReplyTo,
+		msg.Subject,
+		attachmentNames,
+		msg.TextContent,
+	)
+	return nil
+}
+
+// FileSender writes each message as an .eml file in dir, so it can be opened with a mail client
+type FileSender struct {
+	dir string
+}
+
+func NewFileSender(dir string) (FileSender, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return FileSender{}, err
+	}
+	return FileSender{dir: dir}, nil
+}
+
+func (s FileSender) Send(msg EmailMessageWithAttachment) error {
+	message, err := BuildMessage(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	k, err := ksuid.NewRandom()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.dir, fmt.Sprintf("%s.eml", k.String())), message, 0644)
+}
+
+// NewSender returns the Sender for the given transport name
+func NewSender(transport, smtpUser, smtpPassword, smtpHost, dropDir string) (Sender, error) {
+	switch transport {
+	case TransportSMTP:
+		return NewSMTPSender(smtpUser, smtpPassword, smtpHost), nil
+	case TransportLog:
+		return LogSender{}, nil
+	case TransportFile:
+		return NewFileSender(dropDir)
+	}
+	return nil, fmt.Errorf("unknown email transport %s", transport)
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+// planRenewalOffer is a plan the poster can renew their job ad on for a month in one click
+type planRenewalOffer struct {
+	PlanType string
+	Amount   int // monthly price in US cents
+	Current  bool
+}
+
+// TriggerAdsManager downgrades the job plans that expired within the last JobPlanExpiredOfferDays days and
+// offers the posters a renewal, posters of plans expiring in the next JobPlanRenewalNoticeDays days are
+// offered a renewal once before the plan expires
+func TriggerAdsManager(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return middleware.MachineAuthenticatedMiddleware(
+		svr.GetConfig().MachineToken,
+		func(w http.ResponseWriter, r *http.Request) {
+			go func() {
+				now := time.Now().UTC()
+				renewals, err := jobRepo.PlanRenewals(
+					now.AddDate(0, 0, -svr.GetConfig().JobPlanExpiredOfferDays),
+					now.AddDate(0, 0, svr.GetConfig().JobPlanRenewalNoticeDays),
+					svr.GetConfig().SupportEmail,
+				)
+				if err != nil {
+					svr.Log(err, "unable to retrieve job plans due for renewal")
+					return
+				}
+				for _, p := range renewals {
+					if p.PlanExpiredAt.After(now) {
+						if p.OfferedAt.Valid || p.JobExpired {
+							continue
+						}
+						if err := sendPlanRenewalEmail(svr, p, false); err != nil {
+							svr.Log(err, fmt.Sprintf("unable to send plan renewal offer for job id %d", p.JobID))
+							continue
+						}
+						if err := jobRepo.RecordPlanRenewalOffered(p); err != nil {
+							svr.Log(err, fmt.Sprintf("unable to record plan renewal offer for job id %d", p.JobID))
+						}
+						continue
+					}
+					expired, err := jobRepo.ExpirePlan(p.JobID)
+					if err != nil {
+						svr.Log(err, fmt.Sprintf("unable to downgrade expired plan for job id %d", p.JobID))
+						continue
+					}
+					if !expired || p.JobExpired {
+						continue
+					}
+					if err := sendPlanRenewalEmail(svr, p, true); err != nil {
+						svr.Log(err, fmt.Sprintf("unable to send plan renewal offer for job id %d", p.JobID))
+					}
+				}
+				if err := svr.CacheDelete(server.CacheKeyPinnedJobs); err != nil {
+					svr.Log(err, "unable to cleanup cache after expiring job plans")
+				}
+			}()
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+		},
+	)
+}
+
+func sendPlanRenewalEmail(svr server.Server, p job.PlanRenewal, expired bool) error {
+	prices, err := svr.JobAdPlanPrices(payment.BaseCurrency)
+	if err != nil {
+		return err
+	}
+	offers := make([]planRenewalOffer, 0, 3)
+	for _, planType := range []string{job.JobPlanTypeBasic, job.JobPlanTypePro, job.JobPlanTypePlatinum} {
+		offers = append(offers, planRenewalOffer{
+			PlanType: planType,
+			Amount:   int(prices.Monthly(planType)),
+			Current:  planType == p.PlanType,
+		})
+	}
+	subject := fmt.Sprintf("Your %s Plan for %s expires on %s", strings.Title(p.PlanType), p.JobTitle, p.PlanExpiredAt.Format("January 2, 2006"))
+	if expired {
+		subject = fmt.Sprintf("Your %s Plan for %s has expired", strings.Title(p.PlanType), p.JobTitle)
+	}
+	return svr.SendTemplatedEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+		email.Address{Email: p.CompanyEmail},
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		subject,
+		"job-plan-renewal-email",
+		map[string]interface{}{
+			"Renewal":  p,
+			"Expired":  expired,
+			"Offers":   offers,
+			"Currency": payment.BaseCurrency,
+		},
+	)
+}
+
+// JobPostUpsellLinkPageHandler is the one-click renewal link of the plan renewal emails, it starts the
+// checkout of ?plan_type= for ?plan_duration= months for the job of the edit ?token=
+func JobPostUpsellLinkPageHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		planType := r.URL.Query().Get("plan_type")
+		if planType != job.JobPlanTypeBasic && planType != job.JobPlanTypePro && planType != job.JobPlanTypePlatinum {
+			svr.JSON(w, http.StatusBadRequest, "invalid plan type")
+			return
+		}
+		planDuration, err := strconv.Atoi(r.URL.Query().Get("plan_duration"))
+		if err != nil || planDuration < 1 || planDuration > 6 {
+			svr.JSON(w, http.StatusBadRequest, "invalid plan duration")
+			return
+		}
+		jobID, err := jobRepo.JobPostIDByToken(token)
+		if err != nil {
+			svr.JSON(w, http.StatusNotFound, "job not found")
+			return
+		}
+		jobPost, err := jobRepo.JobPostByIDForEdit(jobID)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to retrieve job id %d for plan renewal", jobID))
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		svr.Render(r, w, http.StatusOK, "job-plan-renewal-checkout.html", map[string]interface{}{
+			"StripePublishableKey": svr.GetConfig().StripePublishableKey,
+			"Token":                token,
+			"Job":                  jobPost,
+			"PlanType":             planType,
+			"PlanDuration":         planDuration,
+		})
+	}
+}
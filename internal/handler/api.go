@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/apikey"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/promocode"
+	"github.com/golang-cafe/job-board/internal/server"
+	"github.com/golang-cafe/job-board/internal/user"
+	"github.com/gorilla/mux"
+)
+
+const apiMaxJobsPerPage = 100
+
+var apiQueryRe = regexp.MustCompile("[^a-zA-Z0-9\\s]+")
+
+// search terms keep quotes and dashes for phrase and exclusion queries
+var apiSearchRe = regexp.MustCompile("[^a-zA-Z0-9\\s\"-]+")
+
+// APIListJobsHandler returns approved jobs matching the given location, tag,
+// minimum salary and currency, paginated with an opaque cursor
+func APIListJobsHandler(svr server.Server, jobRepo *job.Repository, apiKeyRepo *apikey.Repository) http.HandlerFunc {
+	return middleware.APIKeyAuthenticatedMiddleware(apiKeyRepo, apikey.ScopeJobsRead, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		location := apiQueryRe.ReplaceAllString(strings.TrimSpace(q.Get("location")), "")
+		tag := apiSearchRe.ReplaceAllString(strings.TrimSpace(q.Get("tag")), "")
+		currency := strings.ToUpper(strings.TrimSpace(q.Get("currency")))
+		var salary int
+		if q.Get("min_salary") != "" {
+			var err error
+			salary, err = strconv.Atoi(q.Get("min_salary"))
+			if err != nil || salary < 0 {
+				svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "min_salary must be a positive integer"})
+				return
+			}
+		}
+		if salary > 0 && currency == "" {
+			currency = "USD"
+		}
+		if currency != "" {
+			var validCurrency bool
+			for _, availableCurrency := range svr.GetConfig().AvailableCurrencies {
+				if availableCurrency == currency {
+					validCurrency = true
+					break
+				}
+			}
+			if !validCurrency {
+				svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": fmt.Sprintf("unsupported currency %s", currency)})
+				return
+			}
+		}
+		pageID, perPage := 1, svr.GetConfig().JobsPerPage
+		if limit := q.Get("limit"); limit != "" {
+			l, err := strconv.Atoi(limit)
+			if err != nil || l < 1 || l > apiMaxJobsPerPage {
+				svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": fmt.Sprintf("limit must be between 1 and %d", apiMaxJobsPerPage)})
+				return
+			}
+			perPage = l
+		}
+		if cursor := q.Get("cursor"); cursor != "" {
+			var err error
+			pageID, perPage, err = decodeAPICursor(cursor)
+			if err != nil {
+				svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid cursor"})
+				return
+			}
+		}
+		filters := job.ParseJobFiltersFromQuery(q)
+		jobs, total, err := jobRepo.JobsByQuery(location, tag, pageID, salary, currency, perPage, true, filters)
+		if err != nil {
+			svr.Log(err, "unable to retrieve jobs for api")
+			svr.JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "unable to retrieve jobs"})
+			return
+		}
+		res := job.APIJobList{Data: make([]job.APIJob, 0, len(jobs)), Total: total}
+		for _, j := range jobs {
+			res.Data = append(res.Data, apiJobFromJobPost(svr, j))
+		}
+		if pageID*perPage < total {
+			res.NextCursor = encodeAPICursor(pageID+1, perPage)
+		}
+		svr.JSON(w, http.StatusOK, res)
+	})
+}
+
+// APIJobHandler returns a single approved job by slug or external ID
+func APIJobHandler(svr server.Server, jobRepo *job.Repository, apiKeyRepo *apikey.Repository) http.HandlerFunc {
+	return middleware.APIKeyAuthenticatedMiddleware(apiKeyRepo, apikey.ScopeJobsRead, func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		jobPost, err := jobRepo.JobPostBySlug(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			var byExternalID job.JobPost
+			byExternalID, err = jobRepo.GetJobByExternalID(id)
+			if err == nil {
+				jobPost, err = jobRepo.JobPostBySlug(byExternalID.Slug)
+			}
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			svr.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "job not found"})
+			return
+		}
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to retrieve job %s for api", id))
+			svr.JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "unable to retrieve job"})
+			return
+		}
+		svr.JSON(w, http.StatusOK, map[string]interface{}{"data": apiJobFromJobPost(svr, jobPost)})
+	})
+}
+
+// APICreateJobHandler creates a job draft from a job.JobRq on behalf of the
+// recruiter owning the api key and returns its edit token and payment link
+func APICreateJobHandler(svr server.Server, jobRepo *job.Repository, paymentRepo *payment.Repository, promoRepo *promocode.Repository, userRepo *user.Repository, apiKeyRepo *apikey.Repository) http.HandlerFunc {
+	return middleware.APIKeyAuthenticatedMiddleware(apiKeyRepo, apikey.ScopeJobsWrite, func(w http.ResponseWriter, r *http.Request) {
+		k, _ := middleware.GetAPIKeyFromRequest(r)
+		u, err := userRepo.GetUserByID(k.UserID)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to find user %s for api key %s", k.UserID, k.ID))
+			svr.JSON(w, http.StatusForbidden, map[string]interface{}{"error": "api key owner not found"})
+			return
+		}
+		if u.Type != user.UserTypeRecruiter && !u.IsAdmin {
+			svr.JSON(w, http.StatusForbidden, map[string]interface{}{"error": "only recruiters can post jobs"})
+			return
+		}
+		jobRq := &job.JobRq{}
+		if err := json.NewDecoder(r.Body).Decode(jobRq); err != nil {
+			svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid job request"})
+			return
+		}
+		if jobRq.Email == "" {
+			jobRq.Email = u.Email
+		}
+		if err := validateJobRqPlan(jobRq); err != nil {
+			svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		screeningQuestions, err := jobRq.ScreeningQuestions.Normalize()
+		if err != nil {
+			svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		jobRq.ScreeningQuestions = screeningQuestions
+		price, err := priceJobAd(svr, promoRepo, jobRq.PlanType, jobRq.PlanDuration, jobRq.CurrencyCode, jobRq.PromoCode)
+		if isJobAdPriceUserError(err) {
+			svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			svr.Log(err, "unable to price job ad")
+			svr.JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "unable to price job ad"})
+			return
+		}
+		jobID, token, sess, err := saveJobDraftAndCreatePaymentSession(svr, jobRepo, paymentRepo, jobRq, price)
+		if err != nil {
+			svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		jobPost, err := jobRepo.JobPostByIDForEdit(jobID)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to retrieve job by ID %d", jobID))
+			svr.JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "unable to retrieve created job"})
+			return
+		}
+		res := apiJobDraft(svr, jobPost.ExternalID, token)
+		if sess != nil {
+			res.PaymentURL = fmt.Sprintf("%s%s/x/s/checkout/%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, sess.ID)
+		}
+		svr.JSON(w, http.StatusCreated, map[string]interface{}{"data": res})
+	})
+}
+
+// APIUpdateJobHandler partially updates a job identified by its external ID,
+// authorised by the job edit token in the request body. Empty fields are left unchanged
+func APIUpdateJobHandler(svr server.Server, jobRepo *job.Repository, apiKeyRepo *apikey.Repository) http.HandlerFunc {
+	return middleware.APIKeyAuthenticatedMiddleware(apiKeyRepo, apikey.ScopeJobsWrite, func(w http.ResponseWriter, r *http.Request) {
+		externalID := mux.Vars(r)["id"]
+		jobRq := &job.JobRqUpdate{}
+		if err := json.NewDecoder(r.Body).Decode(jobRq); err != nil {
+			svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid job request"})
+			return
+		}
+		jobID, err := jobRepo.JobPostIDByToken(jobRq.Token)
+		if err != nil {
+			svr.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "job not found"})
+			return
+		}
+		existing, err := jobRepo.JobPostByIDForEdit(jobID)
+		if err != nil || existing.ExternalID != externalID {
+			svr.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "job not found"})
+			return
+		}
+		mergeJobRqUpdate(jobRq, existing)
+		jobRq.ScreeningQuestions, err = jobRq.ScreeningQuestions.Normalize()
+		if err != nil {
+			svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if err := jobRepo.UpdateJob(jobRq, jobID); err != nil {
+			svr.Log(err, fmt.Sprintf("unable to update job request: %#v", jobRq))
+			svr.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if err := svr.CacheDelete(server.CacheKeyPinnedJobs); err != nil {
+			svr.Log(err, "unable to cleanup cache after updating job")
+		}
+		svr.JSON(w, http.StatusOK, map[string]interface{}{"data": apiJobDraft(svr, existing.ExternalID, jobRq.Token)})
+	})
+}
+
+func mergeJobRqUpdate(jobRq *job.JobRqUpdate, existing *job.JobPostForEdit) {
+	fill := func(dst *string, val string) {
+		if strings.TrimSpace(*dst) == "" {
+			*dst = val
+		}
+	}
+	fill(&jobRq.JobTitle, existing.JobTitle)
+	fill(&jobRq.Location, existing.Location)
+	fill(&jobRq.Company, existing.Company)
+	fill(&jobRq.CompanyURL, existing.CompanyURL)
+	fill(&jobRq.SalaryMin, strconv.Itoa(existing.SalaryMin))
+	fill(&jobRq.SalaryMax, strconv.Itoa(existing.SalaryMax))
+	fill(&jobRq.SalaryCurrency, existing.SalaryCurrency)
+	fill(&jobRq.Description, existing.JobDescription)
+	fill(&jobRq.HowToApply, existing.HowToApply)
+	fill(&jobRq.Perks, existing.Perks)
+	fill(&jobRq.InterviewProcess, existing.InterviewProcess)
+	fill(&jobRq.Email, existing.CompanyEmail)
+	fill(&jobRq.CompanyIconID, existing.CompanyIconID)
+	fill(&jobRq.SalaryPeriod, existing.SalaryPeriod)
+}
+
+func apiJobDraft(svr server.Server, externalID, token string) job.APIJobDraft {
+	return job.APIJobDraft{
+		ID:      externalID,
+		Token:   token,
+		EditURL: fmt.Sprintf("%s%s/edit/%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, token),
+	}
+}
+
+func apiJobFromJobPost(svr server.Server, j *job.JobPost) job.APIJob {
+	siteURL := svr.GetConfig().URLProtocol + svr.GetConfig().SiteHost
+	res := job.APIJob{
+		ID:         j.ExternalID,
+		Slug:       j.Slug,
+		Title:      j.JobTitle,
+		Company:    j.Company,
+		CompanyURL: j.CompanyURL,
+		Location:   j.Location,
+		Salary: job.APIJobSalary{
+			Min:      j.SalaryMin,
+			Max:      j.SalaryMax,
+			Currency: j.SalaryCurrency,
+			Period:   j.SalaryPeriod,
+			Range:    j.SalaryRange,
+		},
+		Description:      j.JobDescription,
+		Perks:            j.Perks,
+		InterviewProcess: j.InterviewProcess,
+		URL:              fmt.Sprintf("%s/job/%s", siteURL, j.Slug),
+		ApplyURL:         fmt.Sprintf("%s/x/r?j=%s", siteURL, j.ExternalID),
+		Expired:          j.Expired,
+		CreatedAt:        time.Unix(j.CreatedAt, 0).UTC(),
+		Snippet:          j.SearchSnippet,
+	}
+	if j.CompanyIconID != "" {
+		res.CompanyIconURL = fmt.Sprintf("%s/x/s/m/%s", siteURL, j.CompanyIconID)
+	}
+	// quick apply jobs are applied to through the job page, never expose the company email
+	if svr.IsEmail(j.HowToApply) {
+		res.IsQuickApply = true
+		res.ApplyURL = res.URL
+	}
+	return res
+}
+
+func encodeAPICursor(pageID, perPage int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", pageID, perPage)))
+}
+
+func decodeAPICursor(cursor string) (int, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("malformed cursor")
+	}
+	pageID, err := strconv.Atoi(parts[0])
+	if err != nil || pageID < 1 {
+		return 0, 0, errors.New("malformed cursor page")
+	}
+	perPage, err := strconv.Atoi(parts[1])
+	if err != nil || perPage < 1 || perPage > apiMaxJobsPerPage {
+		return 0, 0, errors.New("malformed cursor page size")
+	}
+	return pageID, perPage, nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-cafe/job-board/internal/apikey"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/server"
+	"github.com/golang-cafe/job-board/internal/user"
+	"github.com/gorilla/mux"
+)
+
+func CreateAPIKeyHandler(svr server.Server, apiKeyRepo *apikey.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			keyRq := &apikey.CreateRq{}
+			if err := json.NewDecoder(r.Body).Decode(keyRq); err != nil {
+				svr.JSON(w, http.StatusBadRequest, "invalid request")
+				return
+			}
+			keyRq.Name = strings.TrimSpace(keyRq.Name)
+			if keyRq.Name == "" || len(keyRq.Name) > 255 {
+				svr.JSON(w, http.StatusBadRequest, "please give your api key a name")
+				return
+			}
+			if len(keyRq.Scopes) == 0 {
+				svr.JSON(w, http.StatusBadRequest, "please select at least one scope")
+				return
+			}
+			for _, scope := range keyRq.Scopes {
+				if _, ok := apikey.ValidScopes[scope]; !ok {
+					svr.JSON(w, http.StatusBadRequest, fmt.Sprintf("invalid scope %s", scope))
+					return
+				}
+				if scope == apikey.ScopeJobsWrite && profile.Type != user.UserTypeRecruiter && !profile.IsAdmin {
+					svr.JSON(w, http.StatusBadRequest, "only recruiters can create api keys for posting jobs")
+					return
+				}
+			}
+			k, plain, err := apiKeyRepo.Create(profile.UserID, keyRq.Name, keyRq.Scopes)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to create api key for user %s", profile.UserID))
+				svr.JSON(w, http.StatusInternalServerError, "unable to create api key, please try again later")
+				return
+			}
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"id": k.ID, "key": plain})
+		},
+	)
+}
+
+func RevokeAPIKeyHandler(svr server.Server, apiKeyRepo *apikey.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			id := mux.Vars(r)["id"]
+			err = apiKeyRepo.Revoke(id, profile.UserID)
+			if errors.Is(err, sql.ErrNoRows) {
+				svr.JSON(w, http.StatusNotFound, nil)
+				return
+			}
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to revoke api key %s", id))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+const (
+	maxApplicantNoteLength = 2000
+	maxApplicantsPerUpdate = 200
+)
+
+// authorizeApplicants returns the job the applicants belong to when the request can manage it,
+// either through the job edit token or as the signed on recruiter that posted the job (or an admin)
+func authorizeApplicants(svr server.Server, r *http.Request, jobRepo *job.Repository, editToken string, applyTokens []string) (*job.JobPostForEdit, bool) {
+	if len(applyTokens) == 0 {
+		return nil, false
+	}
+	jobID, err := jobRepo.JobIDForApplicants(applyTokens)
+	if err != nil {
+		return nil, false
+	}
+	jobPost, err := jobRepo.JobPostByIDForEdit(jobID)
+	if err != nil || jobPost == nil {
+		return nil, false
+	}
+	jobPost.ID = jobID
+	if editToken != "" {
+		tokenJobID, err := jobRepo.JobPostIDByToken(editToken)
+		return jobPost, err == nil && tokenJobID == jobID
+	}
+	return jobPost, isJobRecruiter(svr, r, jobPost)
+}
+
+// authorizeJob returns the job identified either by its edit token or, for the signed on recruiter
+// that posted it (or an admin), by its external ID
+func authorizeJob(svr server.Server, r *http.Request, jobRepo *job.Repository, editToken, externalID string) (*job.JobPostForEdit, bool) {
+	if editToken != "" {
+		jobID, err := jobRepo.JobPostIDByToken(editToken)
+		if err != nil {
+			return nil, false
+		}
+		jobPost, err := jobRepo.JobPostByIDForEdit(jobID)
+		if err != nil || jobPost == nil {
+			return nil, false
+		}
+		jobPost.ID = jobID
+		return jobPost, true
+	}
+	if externalID == "" {
+		return nil, false
+	}
+	jobPost, err := jobRepo.JobPostByExternalIDForEdit(externalID)
+	if err != nil || jobPost == nil {
+		return nil, false
+	}
+	return jobPost, isJobRecruiter(svr, r, jobPost)
+}
+
+func isJobRecruiter(svr server.Server, r *http.Request, jobPost *job.JobPostForEdit) bool {
+	profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+	if err != nil || profile == nil {
+		return false
+	}
+	return profile.IsAdmin || strings.EqualFold(profile.Email, jobPost.CompanyEmail)
+}
+
+func UpdateApplicantsStageHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &job.ApplicantStageRq{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			svr.JSON(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+		if !job.IsValidApplicantStage(req.Stage) {
+			svr.JSON(w, http.StatusBadRequest, "invalid applicant stage")
+			return
+		}
+		if len(req.ApplyTokens) > maxApplicantsPerUpdate {
+			svr.JSON(w, http.StatusBadRequest, fmt.Sprintf("you can update up to %d applicants at once", maxApplicantsPerUpdate))
+			return
+		}
+		jobPost, ok := authorizeApplicants(svr, r, jobRepo, req.Token, req.ApplyTokens)
+		if !ok {
+			svr.JSON(w, http.StatusForbidden, nil)
+			return
+		}
+		updated, err := jobRepo.UpdateApplicantsStage(jobPost.ID, req.ApplyTokens, req.Stage)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to update applicants stage for job id %d", jobPost.ID))
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		if req.NotifyEmail && req.Stage != job.ApplicantStageNew {
+			for _, a := range updated {
+				// applicants that never confirmed their application don't hear back
+				if !a.ConfirmedAt.Valid {
+					continue
+				}
+				if err := sendApplicantStageEmail(svr, jobPost, a); err != nil {
+					svr.Log(err, fmt.Sprintf("unable to send applicant stage email for apply token %s", a.Token))
+				}
+			}
+		}
+		svr.JSON(w, http.StatusOK, map[string]interface{}{"updated": len(updated)})
+	}
+}
+
+func UpdateApplicantRatingHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &job.ApplicantRatingRq{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			svr.JSON(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+		if req.Rating < 0 || req.Rating > job.ApplicantMaxRating {
+			svr.JSON(w, http.StatusBadRequest, fmt.Sprintf("rating must be between 0 and %d", job.ApplicantMaxRating))
+			return
+		}
+		jobPost, ok := authorizeApplicants(svr, r, jobRepo, req.Token, []string{req.ApplyToken})
+		if !ok {
+			svr.JSON(w, http.StatusForbidden, nil)
+			return
+		}
+		if err := jobRepo.UpdateApplicantRating(jobPost.ID, req.ApplyToken, req.Rating); err != nil {
+			svr.Log(err, fmt.Sprintf("unable to update applicant rating for apply token %s", req.ApplyToken))
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		svr.JSON(w, http.StatusOK, nil)
+	}
+}
+
+func AddApplicantNoteHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &job.ApplicantNoteRq{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			svr.JSON(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+		req.Text = strings.TrimSpace(req.Text)
+		if req.Text == "" || len(req.Text) > maxApplicantNoteLength {
+			svr.JSON(w, http.StatusBadRequest, fmt.Sprintf("note must be between 1 and %d characters", maxApplicantNoteLength))
+			return
+		}
+		if _, ok := authorizeApplicants(svr, r, jobRepo, req.Token, []string{req.ApplyToken}); !ok {
+			svr.JSON(w, http.StatusForbidden, nil)
+			return
+		}
+		note, err := jobRepo.AddApplicantNote(req.ApplyToken, req.Text)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to add applicant note for apply token %s", req.ApplyToken))
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		svr.JSON(w, http.StatusOK, map[string]interface{}{
+			"id":         note.ID,
+			"text":       note.Text,
+			"created_at": note.CreatedAt.Format("Jan 02, 2006 15:04"),
+		})
+	}
+}
+
+func sendApplicantStageEmail(svr server.Server, jobPost *job.JobPostForEdit, applicant job.Applicant) error {
+	replyTo := email.Address{Name: jobPost.Company, Email: jobPost.CompanyEmail}
+	if svr.IsEmail(jobPost.HowToApply) {
+		replyTo.Email = jobPost.HowToApply
+	}
+	return svr.SendTemplatedEmail(
+		email.Address{Name: fmt.Sprintf("%s via %s", jobPost.Company, svr.GetEmail().DefaultSenderName()), Email: svr.GetEmail().NoReplySenderAddress()},
+		email.Address{Email: applicant.Email},
+		replyTo,
+		fmt.Sprintf("Your application for %s with %s", jobPost.JobTitle, jobPost.Company),
+		"applicant-stage-email",
+		map[string]interface{}{
+			"Job":   jobPost,
+			"Stage": applicant.Stage,
+		},
+	)
+}
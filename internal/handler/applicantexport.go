@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/cv"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+const (
+	applicantExportCSV  = "csv"
+	applicantExportJSON = "json"
+	applicantExportZIP  = "zip"
+)
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+type applicantExportNote struct {
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type applicantExportAnswer struct {
+	Question string   `json:"question"`
+	Values   []string `json:"values"`
+}
+
+type applicantExport struct {
+	Email       string                  `json:"email"`
+	AppliedAt   time.Time               `json:"applied_at"`
+	ConfirmedAt time.Time               `json:"confirmed_at"`
+	Stage       string                  `json:"stage"`
+	Rating      int                     `json:"rating"`
+	CVFile      string                  `json:"cv_file"`
+	Notes       []applicantExportNote   `json:"notes"`
+	Answers     []applicantExportAnswer `json:"answers"`
+}
+
+// ExportApplicantsHandler exports the confirmed applicants of a job as csv, json or a zip of their CVs.
+// The job is identified by its edit token (?token=) or, for the signed on recruiter, by its external ID (?job-id=)
+func ExportApplicantsHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format != applicantExportCSV && format != applicantExportJSON && format != applicantExportZIP {
+			svr.JSON(w, http.StatusBadRequest, "format must be one of csv, json or zip")
+			return
+		}
+		jobPost, ok := authorizeJob(svr, r, jobRepo, r.URL.Query().Get("token"), r.URL.Query().Get("job-id"))
+		if !ok {
+			svr.JSON(w, http.StatusForbidden, nil)
+			return
+		}
+		applicants, err := jobRepo.GetApplicantsForJob(jobPost.ID)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to retrieve job applicants for job id %d", jobPost.ID))
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		confirmed := make([]*job.Applicant, 0, len(applicants))
+		for _, a := range applicants {
+			if a.ConfirmedAt.Valid {
+				confirmed = append(confirmed, a)
+			}
+		}
+		cvFiles := applicantCVFileNames(confirmed)
+		fileName := fmt.Sprintf("%s-applicants.%s", jobPost.Slug, format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+		switch format {
+		case applicantExportCSV:
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			err = writeApplicantsCSV(w, jobPost.ScreeningQuestions, confirmed, cvFiles)
+		case applicantExportJSON:
+			exports := make([]applicantExport, 0, len(confirmed))
+			for i, a := range confirmed {
+				exports = append(exports, newApplicantExport(a, cvFiles[i]))
+			}
+			svr.JSON(w, http.StatusOK, exports)
+			return
+		case applicantExportZIP:
+			w.Header().Set("Content-Type", "application/zip")
+			err = writeApplicantsZIP(w, confirmed, cvFiles)
+		}
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to export applicants for job id %d", jobPost.ID))
+		}
+	}
+}
+
+func newApplicantExport(a *job.Applicant, cvFile string) applicantExport {
+	export := applicantExport{
+		Email:       a.Email,
+		AppliedAt:   a.CreatedAt,
+		ConfirmedAt: a.ConfirmedAt.Time,
+		Stage:       a.Stage,
+		Rating:      a.Rating,
+		CVFile:      cvFile,
+		Notes:       make([]applicantExportNote, 0, len(a.Notes)),
+		Answers:     make([]applicantExportAnswer, 0, len(a.Answers)),
+	}
+	for _, n := range a.Notes {
+		export.Notes = append(export.Notes, applicantExportNote{Text: n.Text, CreatedAt: n.CreatedAt})
+	}
+	for _, ans := range a.Answers {
+		export.Answers = append(export.Answers, applicantExportAnswer{Question: ans.Question, Values: ans.Values})
+	}
+	return export
+}
+
+func writeApplicantsCSV(w http.ResponseWriter, questions job.ScreeningQuestions, applicants []*job.Applicant, cvFiles []string) error {
+	cw := csv.NewWriter(w)
+	header := []string{"email", "applied_at", "confirmed_at", "stage", "rating", "cv_file", "notes"}
+	for _, q := range questions {
+		header = append(header, q.Text)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for i, a := range applicants {
+		notes := make([]string, 0, len(a.Notes))
+		for _, n := range a.Notes {
+			notes = append(notes, n.Text)
+		}
+		record := []string{
+			a.Email,
+			a.CreatedAt.UTC().Format(time.RFC3339),
+			a.ConfirmedAt.Time.UTC().Format(time.RFC3339),
+			a.Stage,
+			strconv.Itoa(a.Rating),
+			cvFiles[i],
+			strings.Join(notes, " | "),
+		}
+		answers := a.Answers.ByQuestion()
+		for _, q := range questions {
+			record = append(record, strings.Join(answers[q.ID], "; "))
+		}
+		for j := range record {
+			record[j] = csvSafe(record[j])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeApplicantsZIP(w http.ResponseWriter, applicants []*job.Applicant, cvFiles []string) error {
+	zw := zip.NewWriter(w)
+	for i, a := range applicants {
+		if cvFiles[i] == "" {
+			continue
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     cvFiles[i],
+			Method:   zip.Deflate,
+			Modified: a.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(a.Cv); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// applicantCVFileNames names each CV after the applicant's email address, numbering repeated applications.
+// CVs deleted at the end of the retention period have no file
+func applicantCVFileNames(applicants []*job.Applicant) []string {
+	names := make([]string, 0, len(applicants))
+	seen := make(map[string]int, len(applicants))
+	for _, a := range applicants {
+		if a.CvPurgedAt.Valid {
+			names = append(names, "")
+			continue
+		}
+		base := strings.Trim(unsafeFileNameChars.ReplaceAllString(strings.Replace(a.Email, "@", "_at_", 1), "_"), "._")
+		if base == "" {
+			base = "applicant"
+		}
+		seen[base]++
+		if seen[base] > 1 {
+			base = fmt.Sprintf("%s-%d", base, seen[base])
+		}
+		names = append(names, base+cv.Extension(a.CvContentType))
+	}
+	return names
+}
+
+// csvSafe stops spreadsheet applications from evaluating applicant supplied values as formulas
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsAny(s[:1], "=+-@\t\r") {
+		return "'" + s
+	}
+	return s
+}
@@ -1,7 +1,10 @@
 package handler
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/golang-cafe/job-board/internal/bookmark"
 	"github.com/golang-cafe/job-board/internal/job"
@@ -70,3 +73,82 @@ func BookmarkJobHandler(svr server.Server, bookmarkRepo *bookmark.Repository, jo
 		}
 	}
 }
+
+func ApplicationListHandler(svr server.Server, bookmarkRepo *bookmark.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			applications, err := bookmarkRepo.GetApplicationsForUser(profile.UserID)
+			if err != nil {
+				svr.Log(err, "GetApplicationsForUser")
+			}
+			err = svr.Render(r, w, http.StatusOK, "applications.html", map[string]interface{}{
+				"Applications": applications,
+				"MonthAndYear": time.Now().UTC().Format("January 2006"),
+			})
+			if err != nil {
+				svr.Log(err, "unable to render applications page")
+			}
+		},
+	)
+}
+
+// WithdrawApplicationHandler deletes the user's application to the job, CV included,
+// the job stays listed as withdrawn on the applications page
+func WithdrawApplicationHandler(svr server.Server, bookmarkRepo *bookmark.Repository, jobRepo *job.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			jobPost, err := jobRepo.GetJobByExternalID(r.FormValue("job-id"))
+			if err != nil {
+				svr.JSON(w, http.StatusBadRequest, nil)
+				return
+			}
+			applications, err := bookmarkRepo.GetApplicationsForUser(profile.UserID)
+			if err != nil {
+				svr.Log(err, "GetApplicationsForUser")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			var application *bookmark.Application
+			for _, a := range applications {
+				if a.JobPostID == jobPost.ID && a.ApplyToken != "" {
+					application = a
+					break
+				}
+			}
+			if application == nil {
+				svr.JSON(w, http.StatusNotFound, "application not found")
+				return
+			}
+			err = jobRepo.WithdrawApplication(jobPost.ID, profile.Email)
+			if err == sql.ErrNoRows {
+				svr.JSON(w, http.StatusNotFound, "application not found")
+				return
+			}
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to withdraw application to job id %d", jobPost.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if err := bookmarkRepo.MarkApplicationWithdrawn(profile.UserID, jobPost.ID, application.AppliedAt); err != nil {
+				svr.Log(err, fmt.Sprintf("unable to mark application to job id %d as withdrawn", jobPost.ID))
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
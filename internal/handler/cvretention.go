@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+const cvPurgeReasonRetention = "retention"
+
+// TriggerCvRetention emails employers whose applicant CVs are about to reach the end of the retention
+// period and deletes the CVs once the notice period is over
+func TriggerCvRetention(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return middleware.MachineAuthenticatedMiddleware(
+		svr.GetConfig().MachineToken,
+		func(w http.ResponseWriter, r *http.Request) {
+			go func() {
+				now := time.Now().UTC()
+				notice := time.Duration(svr.GetConfig().CvRetentionNoticeDays) * 24 * time.Hour
+				retentions, err := jobRepo.CvRetentions(svr.GetConfig().CvRetentionDays, now.Add(notice))
+				if err != nil {
+					svr.Log(err, "unable to retrieve applicant CVs due for deletion")
+					return
+				}
+				for _, c := range retentions {
+					switch {
+					case c.PurgeDue(now, notice):
+						purged, err := jobRepo.PurgeCvsForJob(c.JobID, cvPurgeReasonRetention)
+						if err != nil {
+							svr.Log(err, fmt.Sprintf("unable to delete applicant CVs for job id %d", c.JobID))
+							continue
+						}
+						log.Printf("deleted %d applicant CVs for job id %d\n", purged, c.JobID)
+					case c.NoticeDue(now, notice):
+						if err := sendCvRetentionNoticeEmail(svr, c, now, notice); err != nil {
+							svr.Log(err, fmt.Sprintf("unable to send CV retention notice for job id %d", c.JobID))
+							continue
+						}
+						if err := jobRepo.MarkCvRetentionNotified(c.JobID); err != nil {
+							svr.Log(err, fmt.Sprintf("unable to mark CV retention notice as sent for job id %d", c.JobID))
+						}
+					}
+				}
+			}()
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+		},
+	)
+}
+
+func sendCvRetentionNoticeEmail(svr server.Server, c job.CvRetention, now time.Time, notice time.Duration) error {
+	// CVs are never deleted less than the notice period after the email goes out
+	deleteAt := c.PurgeAt
+	if earliest := now.Add(notice); deleteAt.Before(earliest) {
+		deleteAt = earliest
+	}
+	return svr.SendTemplatedEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+		email.Address{Email: c.CompanyEmail},
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		fmt.Sprintf("Applicant CVs for %s will be deleted on %s", c.JobTitle, deleteAt.Format("January 2, 2006")),
+		"cv-retention-notice-email",
+		map[string]interface{}{
+			"Retention":     c,
+			"DeleteAt":      deleteAt,
+			"RetentionDays": svr.GetConfig().CvRetentionDays,
+		},
+	)
+}
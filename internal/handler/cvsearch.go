@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/golang-cafe/job-board/internal/cv"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+const cvTextBackfillBatchSize = 100
+
+// TriggerCvTextBackfill extracts the text of the CVs uploaded before CV keyword search was added,
+// so that those applicants show up in search results too
+func TriggerCvTextBackfill(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+	return middleware.MachineAuthenticatedMiddleware(
+		svr.GetConfig().MachineToken,
+		func(w http.ResponseWriter, r *http.Request) {
+			go func() {
+				var indexed int
+				for {
+					applicants, err := jobRepo.CvsWithoutText(cvTextBackfillBatchSize)
+					if err != nil {
+						svr.Log(err, "unable to retrieve CVs without text")
+						return
+					}
+					for _, a := range applicants {
+						// CVs the text can't be extracted from are saved with no text, so they aren't picked up again
+						cvText, err := cv.ExtractText(a.Cv, a.CvContentType)
+						if err != nil {
+							svr.Log(err, fmt.Sprintf("unable to extract text from CV of applicant %s", a.Token))
+						}
+						if err := jobRepo.SaveCvText(a.Token, cvText); err != nil {
+							svr.Log(err, fmt.Sprintf("unable to save CV text of applicant %s", a.Token))
+							return
+						}
+						indexed++
+					}
+					if len(applicants) < cvTextBackfillBatchSize {
+						break
+					}
+				}
+				log.Printf("indexed %d applicant CVs for keyword search\n", indexed)
+			}()
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+		},
+	)
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/server"
+	"github.com/gorilla/mux"
+)
+
+const emailOutboxPageSize = 100
+
+func EmailOutboxAdminPageHandler(svr server.Server, emailRepo *email.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			status := r.URL.Query().Get("status")
+			if status != email.OutboxStatusPending && status != email.OutboxStatusSent {
+				status = email.OutboxStatusDead
+			}
+			stats, err := emailRepo.Stats()
+			if err != nil {
+				svr.Log(err, "unable to retrieve email outbox stats")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			messages, err := emailRepo.ListByStatus(status, emailOutboxPageSize)
+			if err != nil {
+				svr.Log(err, "unable to retrieve email outbox messages")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.Render(r, w, http.StatusOK, "email-outbox-admin.html", map[string]interface{}{
+				"Stats":        stats,
+				"Messages":     messages,
+				"Status":       status,
+				"MaxAttempts":  email.OutboxMaxAttempts,
+				"MonthAndYear": time.Now().UTC().Format("January 2006"),
+			})
+		},
+	)
+}
+
+func RetryEmailOutboxMessageHandler(svr server.Server, emailRepo *email.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			id := mux.Vars(r)["id"]
+			err := emailRepo.Retry(id)
+			if err == sql.ErrNoRows {
+				svr.JSON(w, http.StatusNotFound, "dead-lettered email not found")
+				return
+			}
+			if err != nil {
+				svr.Log(err, "unable to retry email outbox message")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
+
+func TriggerEmailOutboxCleanup(svr server.Server, emailRepo *email.Repository) http.HandlerFunc {
+	return middleware.MachineAuthenticatedMiddleware(
+		svr.GetConfig().MachineToken,
+		func(w http.ResponseWriter, r *http.Request) {
+			go func() {
+				// delivered and dead emails may carry applicants' CVs as attachments, don't keep them around
+				if err := emailRepo.DeleteFinishedBefore(time.Now().UTC().AddDate(0, 0, -30)); err != nil {
+					svr.Log(err, "unable to delete sent and dead emails from outbox")
+				}
+			}()
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+		},
+	)
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/database"
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+const (
+	emailSuppressionsPageSize = 200
+	maxEmailFeedbackBodySize  = 1 << 20
+)
+
+// EmailFeedbackWebhookHandler receives bounce and complaint notifications, either a single
+// event or a list of events, e.g. {"type":"bounce","bounce_type":"hard","email":"...","detail":"..."}
+func EmailFeedbackWebhookHandler(svr server.Server, emailRepo *email.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("x-webhook-token")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		// an empty secret would match requests without a token
+		if svr.GetConfig().EmailWebhookToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(svr.GetConfig().EmailWebhookToken)) != 1 {
+			svr.JSON(w, http.StatusUnauthorized, nil)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxEmailFeedbackBodySize))
+		if err != nil {
+			svr.Log(err, "unable to read email feedback webhook body")
+			svr.JSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		events := make([]email.FeedbackEvent, 0)
+		body = bytes.TrimSpace(body)
+		if len(body) > 0 && body[0] == '[' {
+			err = json.Unmarshal(body, &events)
+		} else {
+			var event email.FeedbackEvent
+			err = json.Unmarshal(body, &event)
+			events = append(events, event)
+		}
+		if err != nil {
+			svr.Log(err, "unable to decode email feedback webhook body")
+			svr.JSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		var processed int
+		for _, event := range events {
+			event.Type = strings.ToLower(event.Type)
+			event.BounceType = strings.ToLower(event.BounceType)
+			event.Email = strings.TrimSpace(event.Email)
+			if event.Type != email.FeedbackTypeBounce && event.Type != email.FeedbackTypeComplaint {
+				svr.Log(fmt.Errorf("unknown email feedback type %s", event.Type), "skipping email feedback event")
+				continue
+			}
+			if !svr.IsEmail(event.Email) {
+				svr.Log(fmt.Errorf("invalid email %s", event.Email), "skipping email feedback event")
+				continue
+			}
+			suppressed, err := emailRepo.RecordFeedback(event)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to record email feedback for %s", event.Email))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if suppressed {
+				if err := database.RemoveEmailSubscriberByEmail(svr.Conn, event.Email); err != nil {
+					svr.Log(err, fmt.Sprintf("unable to remove suppressed email subscriber %s", event.Email))
+				}
+			}
+			processed++
+		}
+		svr.JSON(w, http.StatusOK, map[string]interface{}{"processed": processed})
+	}
+}
+
+func EmailSuppressionsAdminPageHandler(svr server.Server, emailRepo *email.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			stats, err := emailRepo.SuppressionStats()
+			if err != nil {
+				svr.Log(err, "unable to retrieve email suppression stats")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			suppressions, err := emailRepo.ListSuppressions(emailSuppressionsPageSize)
+			if err != nil {
+				svr.Log(err, "unable to retrieve email suppressions")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.Render(r, w, http.StatusOK, "email-suppressions-admin.html", map[string]interface{}{
+				"Stats":               stats,
+				"Suppressions":        suppressions,
+				"SoftBounceThreshold": email.SoftBounceThreshold,
+				"MonthAndYear":        time.Now().UTC().Format("January 2006"),
+			})
+		},
+	)
+}
+
+func RemoveEmailSuppressionHandler(svr server.Server, emailRepo *email.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			req := &struct {
+				Email string `json:"email"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				svr.JSON(w, http.StatusBadRequest, "invalid request")
+				return
+			}
+			err := emailRepo.RemoveSuppression(req.Email)
+			if err == sql.ErrNoRows {
+				svr.JSON(w, http.StatusNotFound, "email is not suppressed")
+				return
+			}
+			if err != nil {
+				svr.Log(err, "unable to remove email suppression")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -31,18 +32,25 @@ import (
 	"github.com/nfnt/resize"
 	"github.com/segmentio/ksuid"
 	"github.com/snabb/sitemap"
+	stripe "github.com/stripe/stripe-go"
 
+	"github.com/golang-cafe/job-board/internal/apikey"
 	"github.com/golang-cafe/job-board/internal/blog"
 	"github.com/golang-cafe/job-board/internal/bookmark"
 	"github.com/golang-cafe/job-board/internal/company"
+	"github.com/golang-cafe/job-board/internal/cv"
 	"github.com/golang-cafe/job-board/internal/database"
 	"github.com/golang-cafe/job-board/internal/developer"
 	"github.com/golang-cafe/job-board/internal/email"
 	"github.com/golang-cafe/job-board/internal/imagemeta"
+	"github.com/golang-cafe/job-board/internal/invoice"
 	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/jobcredit"
 	"github.com/golang-cafe/job-board/internal/middleware"
 	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/promocode"
 	"github.com/golang-cafe/job-board/internal/recruiter"
+	"github.com/golang-cafe/job-board/internal/savedsearch"
 	"github.com/golang-cafe/job-board/internal/seo"
 	"github.com/golang-cafe/job-board/internal/server"
 	"github.com/golang-cafe/job-board/internal/user"
@@ -134,16 +142,20 @@ func SubmitRecruiterProfileHandler(svr server.Server, devRepo *developer.Reposit
 func SaveRecruiterProfileHandler(svr server.Server, recRepo *recruiter.Repository, userRepo tokenSaver, paymentRepo *payment.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &struct {
-			Fullname     string `json:"fullname"`
-			CompanyURL   string `json:"company_url"`
-			Email        string `json:"email"`
-			PlanDuration int    `json:"plan_duration"`
-			ItemPrice    int    `json:"item_price"`
+			Fullname   string `json:"fullname"`
+			CompanyURL string `json:"company_url"`
+			Email      string `json:"email"`
+			Interval   string `json:"interval"`
 		}{}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			svr.JSON(w, http.StatusBadRequest, "request is invalid")
 			return
 		}
+		amount := payment.DevDirectorySubscriptionAmount(req.Interval, int64(svr.GetConfig().DevDirectoryPlanID1Price), int64(svr.GetConfig().DevDirectoryPlanID3Price))
+		if amount == 0 {
+			svr.JSON(w, http.StatusBadRequest, "subscription interval must be month or year")
+			return
+		}
 		if !svr.IsEmail(req.Email) {
 			svr.JSON(w, http.StatusBadRequest, "email is invalid")
 			return
@@ -210,7 +222,7 @@ func SaveRecruiterProfileHandler(svr server.Server, recRepo *recruiter.Repositor
 			svr.JSON(w, http.StatusInternalServerError, nil)
 			return
 		}
-		sess, err := paymentRepo.CreateDevDirectorySession(rec.Email, rec.ID, int64(req.ItemPrice*100), int64(req.PlanDuration), false)
+		sess, err := paymentRepo.CreateDevDirectorySubscriptionSession(rec.Email, rec.ID, "", req.Interval, amount, false)
 		if err != nil {
 			svr.Log(err, "unable to create payment session")
 		}
@@ -220,10 +232,10 @@ func SaveRecruiterProfileHandler(svr server.Server, recRepo *recruiter.Repositor
 			email.Address{Email: req.Email},
 			fmt.Sprintf("New Dev Directory Subscriber on %s", svr.GetConfig().SiteName),
 			fmt.Sprintf(
-				"Hey! There is a new Developer Directory Subscription on %s. Developer Directory Subscription %d Months Plan @ US$%d/month, Email: %s, Company: %s",
+				"Hey! There is a new Developer Directory Subscription on %s. Developer Directory Subscription billed every %s @ US$%d, Email: %s, Company: %s",
 				svr.GetConfig().SiteName,
-				req.PlanDuration,
-				req.ItemPrice,
+				req.Interval,
+				amount/100,
 				rec.Email,
 				rec.CompanyURL,
 			),
@@ -232,18 +244,6 @@ func SaveRecruiterProfileHandler(svr server.Server, recRepo *recruiter.Repositor
 			svr.Log(err, "unable to send email to admin while creating subscription")
 		}
 		if sess != nil {
-			err = database.InitiatePaymentEventForDeveloperDirectoryAccess(
-				svr.Conn,
-				sess.ID,
-				int64(req.ItemPrice*req.PlanDuration*100),
-				fmt.Sprintf("Developer Directory Subscription %d Months Plan @ US$%d/month", req.PlanDuration, req.ItemPrice),
-				rec.ID,
-				rec.Email,
-				int64(req.PlanDuration),
-			)
-			if err != nil {
-				svr.Log(err, "unable to save payment initiated event")
-			}
 			svr.JSON(w, http.StatusOK, map[string]string{"s_id": sess.ID})
 			return
 		}
@@ -1014,72 +1014,6 @@ func TriggerCloudflareStatsExport(svr server.Server) http.HandlerFunc {
 	)
 }
 
-func TriggerWeeklyNewsletter(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
-	return middleware.MachineAuthenticatedMiddleware(
-		svr.GetConfig().MachineToken,
-		func(w http.ResponseWriter, r *http.Request) {
-			go func() {
-// 				lastJobIDStr, err := jobRepo.GetValue("last_sent_job_id_weekly")
-// 				if err != nil {
-// 					svr.Log(err, "unable to retrieve last newsletter weekly job id")
-// 					return
-// 				}
-// 				lastJobID, err := strconv.Atoi(lastJobIDStr)
-// 				if err != nil {
-// 					svr.Log(err, fmt.Sprintf("unable to convert job str %s to id", lastJobIDStr))
-// 					return
-// 				}
-// 				jobPosts, err := jobRepo.GetLastNJobsFromID(svr.GetConfig().NewsletterJobsToSend, lastJobID)
-// 				if len(jobPosts) < 1 {
-// 					log.Printf("found 0 new jobs for weekly newsletter. quitting")
-// 					return
-// 				}
-// 				fmt.Printf("found %d/%d jobs for weekly newsletter\n", len(jobPosts), svr.GetConfig().NewsletterJobsToSend)
-// 				subscribers, err := database.GetEmailSubscribers(svr.Conn)
-// 				if err != nil {
-// 					svr.Log(err, fmt.Sprintf("unable to retrieve subscribers"))
-// 					return
-// 				}
-// 				var jobsHTMLArr []string
-// 				for _, j := range jobPosts {
-// 					jobsHTMLArr = append(jobsHTMLArr, `Job Title: `+j.JobTitle+`\r\nCompany: `+j.Company+`\r\nLocation: `+j.Location+`\r\nSalary: `+j.SalaryRange+`\r\nDetail: `+svr.GetConfig().URLProtocol+svr.GetConfig().SiteHost+`/job/`+j.Slug)
-// 					lastJobID = j.ID
-// 				}
-// 				jobsHTML := strings.Join(jobsHTMLArr, " ")
-// 				campaignContentHTML := `Here's a list of the newest ` + fmt.Sprintf("%d", len(jobPosts)) + ` ` + svr.GetConfig().SiteJobCategory + ` jobs this week on ` + svr.GetConfig().SiteName + `\r\n
-// ` + jobsHTML + `
-//     Check out more jobs at ` + svr.GetConfig().SiteName + `` + svr.GetConfig().URLProtocol + svr.GetConfig().SiteHost + `
-//     Get companies apply to you, join the ` + strings.ToUpper(svr.GetConfig().SiteJobCategory) + ` Developer Community ` + svr.GetConfig().SiteName + `` + svr.GetConfig().URLProtocol + svr.GetConfig().SiteHost + `/Join-` + strings.Title(svr.GetConfig().SiteJobCategory) + `-Community
-//     ` + svr.GetConfig().SiteName + `
-//     `
-// 				unsubscribeLink := `
-//     ` + svr.GetConfig().SiteName + ` | London, United Kingdom\r\nThis email was sent to %s | ` + svr.GetConfig().URLProtocol + svr.GetConfig().SiteHost + `/x/email/unsubscribe?token=%s"`
-
-// 				for _, s := range subscribers {
-// 					err = svr.GetEmail().SendHTMLEmail(
-// 						email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
-// 						email.Address{Email: s.Email},
-// 						email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
-// 						fmt.Sprintf("Go Jobs This Week (%d New)", len(jobPosts)),
-// 						campaignContentHTML+fmt.Sprintf(unsubscribeLink, s.Email, s.Token),
-// 					)
-// 					if err != nil {
-// 						svr.Log(err, fmt.Sprintf("unable to send email for newsletter email %s", s.Email))
-// 						continue
-// 					}
-// 				}
-// 				lastJobIDStr = strconv.Itoa(lastJobID)
-// 				err = jobRepo.SetValue("last_sent_job_id_weekly", lastJobIDStr)
-// 				if err != nil {
-// 					svr.Log(err, "unable to save last weekly newsletter job id to db")
-// 					return
-// 				}
-			}()
-			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
-		},
-	)
-}
-
 func TriggerTelegramScheduler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
 	return middleware.MachineAuthenticatedMiddleware(
 		svr.GetConfig().MachineToken,
@@ -1341,16 +1275,6 @@ func TriggerCompanyUpdate(svr server.Server, companyRepo *company.Repository) ht
 	)
 }
 
-func TriggerAdsManager(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
-	return middleware.MachineAuthenticatedMiddleware(
-		svr.GetConfig().MachineToken,
-		func(w http.ResponseWriter, r *http.Request) {
-			// select * from job where plan_expired_at >= current_date - interval '30' day and plan_expired_at < NOW() and approved_at is not null and company_email != '<support email>'
-			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
-		},
-	)
-}
-
 func UpdateDeveloperProfileHandler(svr server.Server, devRepo *developer.Repository) http.HandlerFunc {
 	return middleware.UserAuthenticatedMiddleware(
 		svr.SessionStore,
@@ -1930,6 +1854,23 @@ func ShowPaymentPage(svr server.Server) http.HandlerFunc {
 	}
 }
 
+// CheckoutRedirectPageHandler sends the buyer to the stripe checkout for an
+// existing job ad payment session, used as payment link for api created jobs
+func CheckoutRedirectPageHandler(svr server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID := mux.Vars(r)["id"]
+		found, err := database.IsJobAdPaymentEvent(svr.Conn, sessionID)
+		if err != nil || !found {
+			svr.JSON(w, http.StatusNotFound, "payment session not found")
+			return
+		}
+		svr.Render(r, w, http.StatusOK, "checkout.html", map[string]interface{}{
+			"StripePublishableKey": svr.GetConfig().StripePublishableKey,
+			"SessionID":            sessionID,
+		})
+	}
+}
+
 func PostAJobWithoutPaymentPageHandler(svr server.Server) http.HandlerFunc {
 	return middleware.AdminAuthenticatedMiddleware(
 		svr.SessionStore,
@@ -2352,149 +2293,6 @@ func ServeRSSFeed(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
 	}
 }
 
-func StripePaymentConfirmationWebhookHandler(svr server.Server, jobRepo *job.Repository, recruiterRepo *recruiter.Repository) http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
-		const MaxBodyBytes = int64(65536)
-		req.Body = http.MaxBytesReader(w, req.Body, MaxBodyBytes)
-		body, err := ioutil.ReadAll(req.Body)
-		if err != nil {
-			svr.Log(err, "error reading request body from stripe")
-			svr.JSON(w, http.StatusServiceUnavailable, nil)
-			return
-		}
-
-		stripeSig := req.Header.Get("Stripe-Signature")
-		svr.Log(nil, fmt.Sprintf("got session %v", string(body)))
-		sess, err := payment.HandleCheckoutSessionComplete(body, svr.GetConfig().StripeEndpointSecret, stripeSig)
-		if err != nil {
-			svr.Log(err, "error while handling checkout session complete")
-			svr.JSON(w, http.StatusBadRequest, nil)
-			return
-		}
-		if sess == nil {
-			svr.JSON(w, http.StatusNotFound, nil)
-			return
-		}
-		isJobAd, err := database.IsJobAdPaymentEvent(svr.Conn, sess.ID)
-		if err != nil {
-			svr.Log(err, "IsJobAdPaymentEvent: error")
-			svr.JSON(w, http.StatusInternalServerError, map[string]interface{}{"erorr": err.Error()})
-			return
-		}
-		if isJobAd {
-			affectedRows, err := database.SaveSuccessfulPaymentForJobAd(svr.Conn, sess.ID)
-			if err != nil {
-				svr.Log(err, "error while saving successful payment")
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			if affectedRows != 1 {
-				svr.Log(errors.New("invalid number of rows affected when saving payment"), fmt.Sprintf("got %d expected 1", affectedRows))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			jobPost, err := jobRepo.GetJobByStripeSessionID(sess.ID)
-			if err != nil {
-				svr.Log(errors.New("unable to find job by stripe session id"), fmt.Sprintf("session id %s", sess.ID))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			purchaseEvent, err := database.GetJobAdPurchaseEventBySessionID(svr.Conn, sess.ID)
-			if err != nil {
-				svr.Log(errors.New("unable to find purchase event by stripe session id"), fmt.Sprintf("session id %s", sess.ID))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			jobToken, err := jobRepo.TokenByJobID(jobPost.ID)
-			if err != nil {
-				svr.Log(errors.New("unable to find token for job id"), fmt.Sprintf("session id %s job id %d", sess.ID, jobPost.ID))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-
-			expiration, err := jobRepo.PlanTypeAndDurationToExpirations(
-				purchaseEvent.PlanType,
-				purchaseEvent.PlanDuration,
-			)
-			if err != nil {
-				svr.Log(errors.New("unable to get expiration for plan type and duration"), fmt.Sprintf("unable to get expiration for plan type %s and duration %d for session id %s", purchaseEvent.PlanType, purchaseEvent.PlanDuration, sess.ID))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			if err := jobRepo.UpdateJobPlan(jobPost.ID, purchaseEvent.PlanType, purchaseEvent.PlanDuration, expiration); err != nil {
-				svr.Log(errors.New("unable to update job to new ad type"), fmt.Sprintf("unable to update job id %d to new ad type %s and duration %d for session id %s", jobPost.ID, purchaseEvent.PlanType, purchaseEvent.PlanDuration, sess.ID))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			err = svr.GetEmail().SendHTMLEmail(
-				email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
-				email.Address{Email: purchaseEvent.Email},
-				email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
-				fmt.Sprintf("Your Job Ad is live on %s", svr.GetConfig().SiteName),
-				fmt.Sprintf("Your Job Ad has been approved and it's now live. You can edit the Job Ad at any time and check page views and clickouts by following this link %s%s/edit/%s. You can also create an account by following this link: %s%s/auth?email=%s",
-					svr.GetConfig().URLProtocol,
-					svr.GetConfig().SiteHost,
-					jobToken,
-					svr.GetConfig().URLProtocol,
-					svr.GetConfig().SiteHost,
-					purchaseEvent.Email,
-				),
-			)
-			if err != nil {
-				svr.Log(err, "unable to send email while upgrading job ad")
-			}
-			if err := svr.CacheDelete(server.CacheKeyPinnedJobs); err != nil {
-				svr.Log(err, "unable to cleanup cache after approving job")
-			}
-			svr.JSON(w, http.StatusOK, nil)
-			return
-		}
-		isDevDirectory, err := database.IsDevDirectoryPaymentEvent(svr.Conn, sess.ID)
-		if err != nil {
-			svr.Log(err, "IsDevDirectoryPaymentEvent: error")
-			svr.JSON(w, http.StatusInternalServerError, map[string]interface{}{"erorr": err.Error()})
-			return
-		}
-		if isDevDirectory {
-			affectedRows, err := database.SaveSuccessfulPaymentForDevDirectory(svr.Conn, sess.ID)
-			if err != nil {
-				svr.Log(err, "error while saving successful payment for dev directory")
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			if affectedRows != 1 {
-				svr.Log(errors.New("invalid number of rows affected when saving payment"), fmt.Sprintf("got %d expected 1", affectedRows))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			purchaseEvent, err := database.GetDevDirectoryPurchaseEventBySessionID(svr.Conn, sess.ID)
-			if err != nil {
-				svr.Log(errors.New("unable to find purchase event by stripe session id"), fmt.Sprintf("session id %s", sess.ID))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			if err := recruiterRepo.UpdateRecruiterPlanExpiration(purchaseEvent.Email, purchaseEvent.ExpiredAt); err != nil {
-				svr.Log(errors.New("unable to update job to new ad type"), fmt.Sprintf("unable to update recruiter developer directory access for session id %s", sess.ID))
-				svr.JSON(w, http.StatusBadRequest, nil)
-				return
-			}
-			err = svr.GetEmail().SendHTMLEmail(
-				email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
-				email.Address{Email: purchaseEvent.Email},
-				email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
-				fmt.Sprintf("Your Developer Directory Access is active on %s", svr.GetConfig().SiteName),
-				fmt.Sprintf("Your payment has been received successfully and you can now access the Developer Directory. Please follow this link to login %s%s/auth?email=%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, purchaseEvent.Email))
-			if err != nil {
-				svr.Log(err, "unable to send email while activating recruiter developer directory plan")
-			}
-			svr.JSON(w, http.StatusOK, nil)
-			return
-		}
-		svr.JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "sessionID is not dev or job ad type"})
-		return
-	}
-}
-
 func SitemapIndexHandler(svr server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		index := sitemap.NewSitemapIndex()
@@ -2653,38 +2451,52 @@ func ApplyForJobPageHandler(svr server.Server, jobRepo *job.Repository, bookmark
 		// limits upload form size to 5mb
 		maxPdfSize := 5 * 1024 * 1024
 		r.Body = http.MaxBytesReader(w, r.Body, int64(maxPdfSize))
-		cv, header, err := r.FormFile("cv")
+		cvFile, header, err := r.FormFile("cv")
 		if err != nil {
 			svr.Log(err, "unable to read cv file")
 			svr.JSON(w, http.StatusRequestEntityTooLarge, nil)
 			return
 		}
-		defer cv.Close()
-		fileBytes, err := ioutil.ReadAll(cv)
+		defer cvFile.Close()
+		fileBytes, err := ioutil.ReadAll(cvFile)
 		if err != nil {
 			svr.Log(err, "unable to read cv file content")
 			svr.JSON(w, http.StatusRequestEntityTooLarge, nil)
 			return
 		}
-		contentType := http.DetectContentType(fileBytes)
-		if contentType != "application/pdf" {
-			svr.Log(errors.New("PDF file is not application/pdf"), fmt.Sprintf("PDF file is not application/pdf got %s", contentType))
+		cvContentType, err := cv.DetectContentType(fileBytes)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("cv file is not a pdf, docx, odt or text document, got %s", http.DetectContentType(fileBytes)))
 			svr.JSON(w, http.StatusUnsupportedMediaType, nil)
 			return
 		}
 		if header.Size > int64(maxPdfSize) {
-			svr.Log(errors.New("PDF file is too large"), fmt.Sprintf("PDF file too large: %d > %d", header.Size, maxPdfSize))
+			svr.Log(errors.New("cv file is too large"), fmt.Sprintf("cv file too large: %d > %d", header.Size, maxPdfSize))
 			svr.JSON(w, http.StatusRequestEntityTooLarge, nil)
 			return
 		}
+		// the application goes through without the CV text, it just won't show up in keyword searches
+		cvText, err := cv.ExtractText(fileBytes, cvContentType)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to extract text from %s cv", cvContentType))
+		}
 		externalID := r.FormValue("job-id")
 		emailAddr := r.FormValue("email")
 		jobPost, err := jobRepo.JobPostByExternalIDForEdit(externalID)
 		if err != nil {
-			svr.Log(err, fmt.Sprintf("unable to retrieve job by externalId %d, %v", externalID, err))
+			svr.Log(err, fmt.Sprintf("unable to retrieve job by externalId %s, %v", externalID, err))
 			svr.JSON(w, http.StatusBadRequest, nil)
 			return
 		}
+		answers, err := jobPost.ScreeningQuestions.Answers(r.MultipartForm.Value)
+		if err != nil {
+			// the applicant may have used the apply box of a job listing, which has no screening questions
+			svr.JSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"message": err.Error(),
+				"url":     fmt.Sprintf("/job/%s", jobPost.Slug),
+			})
+			return
+		}
 		k, err := ksuid.NewRandom()
 		if err != nil {
 			svr.Log(err, "unable to generate token")
@@ -2707,7 +2519,7 @@ func ApplyForJobPageHandler(svr server.Server, jobRepo *job.Repository, bookmark
 		// user is not logged in
 		// standard flow to confirm application
 		if profile == nil {
-			err = jobRepo.ApplyToJob(jobPost.ID, fileBytes, emailAddr, randomTokenStr)
+			err = jobRepo.ApplyToJob(jobPost.ID, fileBytes, cvContentType, cvText, emailAddr, randomTokenStr, answers)
 			if err != nil {
 				svr.Log(err, "unable to apply for job while saving to db")
 				svr.JSON(w, http.StatusBadRequest, nil)
@@ -2770,7 +2582,7 @@ func ApplyForJobPageHandler(svr server.Server, jobRepo *job.Repository, bookmark
 			svr.JSON(w, http.StatusBadRequest, "Please use the same email address you have registered on your profile.")
 			return
 		}
-		err = jobRepo.ApplyToJob(jobPost.ID, fileBytes, emailAddr, randomTokenStr)
+		err = jobRepo.ApplyToJob(jobPost.ID, fileBytes, cvContentType, cvText, emailAddr, randomTokenStr, answers)
 		if err != nil {
 			svr.Log(err, "unable to apply for job while saving to db")
 			svr.JSON(w, http.StatusBadRequest, nil)
@@ -2791,23 +2603,7 @@ func ApplyForJobPageHandler(svr server.Server, jobRepo *job.Repository, bookmark
 			})
 			return
 		}
-		err = svr.GetEmail().SendHTMLEmail(
-			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
-			email.Address{Email: retrievedJobPost.HowToApply},
-			email.Address{Email: applicant.Email},
-			fmt.Sprintf("New Applicant from %s", svr.GetConfig().SiteName),
-			fmt.Sprintf(
-				"Hi, there is a new applicant for your position on %s: %s with %s - %s (%s%s/job/%s). Applicant's Email: %s. Please find applicant's CV on your job dashboard",
-				svr.GetConfig().SiteName,
-				retrievedJobPost.JobTitle,
-				retrievedJobPost.Company,
-				retrievedJobPost.Location,
-				svr.GetConfig().SiteHost,
-				svr.GetConfig().URLProtocol,
-				retrievedJobPost.Slug,
-				applicant.Email,
-			),
-		)
+		err = sendNewApplicantEmail(svr, retrievedJobPost, applicant)
 		if err != nil {
 			svr.Log(err, "unable to send email while applying to job")
 			svr.Render(r, w, http.StatusBadRequest, "apply-message.html", map[string]interface{}{
@@ -2845,6 +2641,26 @@ func ApplyForJobPageHandler(svr server.Server, jobRepo *job.Repository, bookmark
 	}
 }
 
+// sendNewApplicantEmail notifies the employer of a new applicant with the applicant's CV attached
+func sendNewApplicantEmail(svr server.Server, jobPost job.JobPost, applicant job.Applicant) error {
+	return svr.SendTemplatedEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+		email.Address{Email: jobPost.HowToApply},
+		email.Address{Email: applicant.Email},
+		fmt.Sprintf("New Applicant from %s", svr.GetConfig().SiteName),
+		"new-applicant-email",
+		map[string]interface{}{
+			"Job":       jobPost,
+			"Applicant": applicant,
+		},
+		email.Attachment{
+			Name:        "cv" + cv.Extension(applicant.CvContentType),
+			ContentType: applicant.CvContentType,
+			B64Data:     base64.StdEncoding.EncodeToString(applicant.Cv),
+		},
+	)
+}
+
 func ApplyToJobConfirmation(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -2857,23 +2673,7 @@ func ApplyToJobConfirmation(svr server.Server, jobRepo *job.Repository) http.Han
 			})
 			return
 		}
-		err = svr.GetEmail().SendHTMLEmail(
-			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
-			email.Address{Email: jobPost.HowToApply},
-			email.Address{Email: applicant.Email},
-			fmt.Sprintf("New Applicant from %s", svr.GetConfig().SiteName),
-			fmt.Sprintf(
-				"Hi, there is a new applicant for your position on %s: %s with %s - %s (%s%s/job/%s). Applicant's Email: %s. Please find applicant's CV on your job dashboard",
-				svr.GetConfig().SiteName,
-				jobPost.JobTitle,
-				jobPost.Company,
-				jobPost.Location,
-				svr.GetConfig().URLProtocol,
-				svr.GetConfig().SiteHost,
-				jobPost.Slug,
-				applicant.Email,
-			),
-		)
+		err = sendNewApplicantEmail(svr, jobPost, applicant)
 		if err != nil {
 			svr.Log(err, "unable to send email while applying to job")
 			svr.Render(r, w, http.StatusBadRequest, "apply-message.html", map[string]interface{}{
@@ -2922,6 +2722,12 @@ func SubmitJobPostWithoutPaymentHandler(svr server.Server, jobRepo *job.Reposito
 				svr.JSON(w, http.StatusBadRequest, nil)
 				return
 			}
+			screeningQuestions, err := jobRq.ScreeningQuestions.Normalize()
+			if err != nil {
+				svr.JSON(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			jobRq.ScreeningQuestions = screeningQuestions
 			jobRq.PlanType = job.JobPlanTypeBasic
 			jobRq.PlanDuration = 1
 			jobID, err := jobRepo.SaveDraft(jobRq)
@@ -2959,16 +2765,14 @@ func SubmitJobPostWithoutPaymentHandler(svr server.Server, jobRepo *job.Reposito
 	)
 }
 
-func DeveloperDirectoryUpsellPageHandler(svr server.Server, jobRepo *job.Repository, paymentRepo *payment.Repository) http.HandlerFunc {
+func DeveloperDirectoryUpsellPageHandler(svr server.Server, recRepo *recruiter.Repository, paymentRepo *payment.Repository) http.HandlerFunc {
 	return middleware.UserAuthenticatedMiddleware(
 		svr.SessionStore,
 		svr.GetJWTSigningKey(),
 		func(w http.ResponseWriter, r *http.Request) {
 			decoder := json.NewDecoder(r.Body)
 			upsellRq := &struct {
-				RecruiterID  string `json:"recruiter_id"`
-				PlanDuration int64  `json:"plan_duration"`
-				ItemPrice    int64  `json:"item_price"`
+				Interval string `json:"interval"`
 			}{}
 			if err := decoder.Decode(&upsellRq); err != nil {
 				svr.Log(err, "unable to decode request")
@@ -2981,7 +2785,22 @@ func DeveloperDirectoryUpsellPageHandler(svr server.Server, jobRepo *job.Reposit
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
-			sess, err := paymentRepo.CreateDevDirectorySession(profile.Email, upsellRq.RecruiterID, int64(upsellRq.ItemPrice*100), int64(upsellRq.PlanDuration), true)
+			rec, err := recRepo.RecruiterProfileByEmail(profile.Email)
+			if err != nil || rec.ID == "" {
+				svr.Log(err, fmt.Sprintf("unable to retrieve recruiter profile for %s", profile.Email))
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			if rec.HasSubscription() {
+				svr.JSON(w, http.StatusBadRequest, "you already have an active subscription, you can manage it from the billing portal")
+				return
+			}
+			amount := payment.DevDirectorySubscriptionAmount(upsellRq.Interval, int64(svr.GetConfig().DevDirectoryPlanID1Price), int64(svr.GetConfig().DevDirectoryPlanID3Price))
+			if amount == 0 {
+				svr.JSON(w, http.StatusBadRequest, "subscription interval must be month or year")
+				return
+			}
+			sess, err := paymentRepo.CreateDevDirectorySubscriptionSession(rec.Email, rec.ID, rec.StripeCustomerID, upsellRq.Interval, amount, true)
 			if err != nil {
 				svr.Log(err, "unable to create payment session")
 			}
@@ -2991,10 +2810,10 @@ func DeveloperDirectoryUpsellPageHandler(svr server.Server, jobRepo *job.Reposit
 				email.Address{Email: profile.Email},
 				fmt.Sprintf("New Dev Directory Subscriber Renew on %s", svr.GetConfig().SiteName),
 				fmt.Sprintf(
-					"Hey! There is a new Developer Directory Subscription Renew on %s. Developer Directory Subscription %d Months Plan @ US$%d/month, Email: %s",
+					"Hey! There is a new Developer Directory Subscription Renew on %s. Developer Directory Subscription billed every %s @ US$%d, Email: %s",
 					svr.GetConfig().SiteName,
-					upsellRq.PlanDuration,
-					upsellRq.ItemPrice,
+					upsellRq.Interval,
+					amount/100,
 					profile.Email,
 				),
 			)
@@ -3002,18 +2821,6 @@ func DeveloperDirectoryUpsellPageHandler(svr server.Server, jobRepo *job.Reposit
 				svr.Log(err, "unable to send email to admin while creating subscription")
 			}
 			if sess != nil {
-				err = database.InitiatePaymentEventForDeveloperDirectoryAccess(
-					svr.Conn,
-					sess.ID,
-					int64(upsellRq.ItemPrice*upsellRq.PlanDuration*100),
-					fmt.Sprintf("Developer Directory Subscription %d Months Plan @ US$%d/month", upsellRq.PlanDuration, upsellRq.ItemPrice),
-					upsellRq.RecruiterID,
-					profile.Email,
-					int64(upsellRq.PlanDuration),
-				)
-				if err != nil {
-					svr.Log(err, "unable to save payment initiated event")
-				}
 				svr.JSON(w, http.StatusOK, map[string]string{"s_id": sess.ID})
 				return
 			}
@@ -3022,7 +2829,7 @@ func DeveloperDirectoryUpsellPageHandler(svr server.Server, jobRepo *job.Reposit
 	)
 }
 
-func SubmitJobPostPaymentUpsellPageHandler(svr server.Server, jobRepo *job.Repository, paymentRepo *payment.Repository) http.HandlerFunc {
+func SubmitJobPostPaymentUpsellPageHandler(svr server.Server, jobRepo *job.Repository, paymentRepo *payment.Repository, promoRepo *promocode.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		jobRq := &job.JobRqUpsell{}
@@ -3044,25 +2851,26 @@ func SubmitJobPostPaymentUpsellPageHandler(svr server.Server, jobRepo *job.Repos
 			svr.JSON(w, http.StatusBadRequest, nil)
 			return
 		}
-		monthlyAmount := 59
-		switch jobRq.PlanType {
-		case job.JobPlanTypeBasic:
-			monthlyAmount = svr.GetConfig().PlanID1Price
-		case job.JobPlanTypePro:
-			monthlyAmount = svr.GetConfig().PlanID2Price
-		case job.JobPlanTypePlatinum:
-			monthlyAmount = svr.GetConfig().PlanID3Price
+		if jobRq.CurrencyCode == "" {
+			jobRq.CurrencyCode = payment.BaseCurrency
+		}
+		price, err := priceJobAd(svr, promoRepo, jobRq.PlanType, jobRq.PlanDuration, jobRq.CurrencyCode, jobRq.PromoCode)
+		if err != nil {
+			jobAdPriceError(svr, w, err)
+			return
 		}
 		sess, err := paymentRepo.CreateJobAdSession(
 			&job.JobRq{
 				PlanType:     jobRq.PlanType,
 				PlanDuration: jobRq.PlanDuration,
-				CurrencyCode: "USD",
+				CurrencyCode: price.Currency,
 				Email:        jobRq.Email,
+				PromoCode:    price.PromoCode.Code,
 			},
 			jobRq.Token,
-			int64(monthlyAmount),
+			price.MonthlyAmount,
 			int64(jobRq.PlanDuration),
+			price.Discount,
 		)
 		if err != nil {
 			svr.Log(err, "unable to create payment session")
@@ -3091,22 +2899,21 @@ func SubmitJobPostPaymentUpsellPageHandler(svr server.Server, jobRepo *job.Repos
 		err = database.InitiatePaymentEventForJobAd(
 			svr.Conn,
 			sess.ID,
-			payment.PlanTypeAndDurationToAmount(
-				jobRq.PlanType,
-				int64(jobRq.PlanDuration),
-				int64(svr.GetConfig().PlanID1Price),
-				int64(svr.GetConfig().PlanID1Price),
-				int64(svr.GetConfig().PlanID1Price),
-			),
-			payment.PlanTypeAndDurationToDescription(
-				jobRq.PlanType,
-				int64(jobRq.PlanDuration),
-			),
+			price.Amount,
+			price.Currency,
+			price.BaseAmount,
+			jobAdPurchaseDescription(jobRq.PlanType, jobRq.PlanDuration, price),
 			jobRq.Email,
 			jobID,
 			jobRq.PlanType,
 			int64(jobRq.PlanDuration),
+			price.PromoCode.ID,
+			price.Discount,
 		)
+		if err == promocode.ErrFullyRedeemed {
+			svr.JSON(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
 			svr.Log(err, "unable to save payment initiated event")
 		}
@@ -3146,7 +2953,7 @@ func GeneratePaymentIntent(svr server.Server, paymentRepo *payment.Repository) h
 	}
 }
 
-func SubmitJobPostPageHandler(svr server.Server, jobRepo *job.Repository, paymentRepo *payment.Repository) http.HandlerFunc {
+func SubmitJobPostPageHandler(svr server.Server, jobRepo *job.Repository, paymentRepo *payment.Repository, promoRepo *promocode.Repository, recRepo *recruiter.Repository, jobCreditRepo *jobcredit.Repository, savedSearchRepo *savedsearch.Repository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		jobRq := &job.JobRq{}
@@ -3154,111 +2961,59 @@ func SubmitJobPostPageHandler(svr server.Server, jobRepo *job.Repository, paymen
 			svr.JSON(w, http.StatusBadRequest, nil)
 			return
 		}
-		planDurationInt, err := strconv.Atoi(jobRq.PlanDurationStr)
-		if err != nil {
-			svr.Log(fmt.Errorf("invalid plan duration: unable to save job request: %#v", jobRq), "unable to save job request")
-			svr.JSON(w, http.StatusBadRequest, "invalid plan duration")
-			return
-		}
-		jobRq.PlanDuration = planDurationInt
-		jobRq.CurrencyCode = "USD"
-		if jobRq.PlanType != job.JobPlanTypeBasic && jobRq.PlanType != job.JobPlanTypePro && jobRq.PlanType != job.JobPlanTypePlatinum {
-			svr.Log(fmt.Errorf("invalid plan type: unable to save job request: %#v", jobRq), "unable to save job request")
-			svr.JSON(w, http.StatusBadRequest, "invalid plan type")
-			return
-		}
-		if jobRq.PlanDuration > 6 || jobRq.PlanDuration < 1 {
-			svr.Log(fmt.Errorf("invalid plan duration: unable to save job request: %#v", jobRq), "unable to save job request")
-			svr.JSON(w, http.StatusBadRequest, "invalid plan duration")
-			return
-		}
-		jobID, err := jobRepo.SaveDraft(jobRq)
-		if err != nil {
-			svr.Log(err, fmt.Sprintf("unable to save job request: %#v", jobRq))
+		if err := validateJobRqPlan(jobRq); err != nil {
+			svr.Log(fmt.Errorf("%v: unable to save job request: %#v", err, jobRq), "unable to save job request")
 			svr.JSON(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if jobID == 0 {
-			svr.Log(err, fmt.Sprintf("unable to save job request: %#v", jobRq))
-			svr.JSON(w, http.StatusBadRequest, "unable to save job request invalid job returned")
-			return
-		}
-		k, err := ksuid.NewRandom()
+		screeningQuestions, err := jobRq.ScreeningQuestions.Normalize()
 		if err != nil {
-			svr.Log(err, "unable to generate unique token")
 			svr.JSON(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		randomToken, err := k.Value()
-		if err != nil {
-			svr.Log(err, "unable to get token value")
-			svr.JSON(w, http.StatusBadRequest, err.Error())
+		jobRq.ScreeningQuestions = screeningQuestions
+		if jobRq.UseCredits {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.JSON(w, http.StatusForbidden, "please sign in to post with job credits")
+				return
+			}
+			rec, err := recRepo.RecruiterProfileByEmail(profile.Email)
+			if err != nil {
+				svr.Log(err, "unable to find recruiter profile")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if rec.ID == "" {
+				svr.JSON(w, http.StatusForbidden, "job credits can only be spent with a recruiter account")
+				return
+			}
+			jobRq.PromoCode = ""
+			token, err := publishJobPostWithCredits(svr, jobRepo, jobCreditRepo, rec, jobRq)
+			if err == jobcredit.ErrInsufficientCredits {
+				svr.JSON(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err != nil {
+				svr.Log(err, "unable to publish job ad with job credits")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			sendInstantSavedSearchAlerts(svr, jobRepo, savedSearchRepo)
+			svr.JSON(w, http.StatusOK, map[string]string{"token": token})
 			return
 		}
-		randomTokenStr, ok := randomToken.(string)
-		if !ok {
-			svr.Log(err, "unbale to assert token value as string")
-			svr.JSON(w, http.StatusBadRequest, "unbale to assert token value as string")
+		price, err := priceJobAd(svr, promoRepo, jobRq.PlanType, jobRq.PlanDuration, jobRq.CurrencyCode, jobRq.PromoCode)
+		if err != nil {
+			jobAdPriceError(svr, w, err)
 			return
 		}
-		err = jobRepo.SaveTokenForJob(randomTokenStr, jobID)
+		_, _, sess, err := saveJobDraftAndCreatePaymentSession(svr, jobRepo, paymentRepo, jobRq, price)
 		if err != nil {
-			svr.Log(err, "unbale to generate token")
 			svr.JSON(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		monthlyAmount := 59
-		switch jobRq.PlanType {
-		case job.JobPlanTypeBasic:
-			monthlyAmount = svr.GetConfig().PlanID1Price
-		case job.JobPlanTypePro:
-			monthlyAmount = svr.GetConfig().PlanID2Price
-		case job.JobPlanTypePlatinum:
-			monthlyAmount = svr.GetConfig().PlanID3Price
-		}
-		sess, err := paymentRepo.CreateJobAdSession(jobRq, randomTokenStr, int64(monthlyAmount), int64(jobRq.PlanDuration))
-		if err != nil {
-			svr.Log(err, "unable to create payment session")
-		}
-		err = svr.GetEmail().SendHTMLEmail(
-			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
-			email.Address{Email: svr.GetEmail().DefaultAdminAddress()},
-			email.Address{Email: jobRq.Email},
-			fmt.Sprintf("New Job Ad on %s", svr.GetConfig().SiteName),
-			fmt.Sprintf(
-				"Hey! There is a new Ad on %s. Please approve %s%s/manage/%s",
-				svr.GetConfig().SiteName,
-				svr.GetConfig().URLProtocol,
-				svr.GetConfig().SiteHost,
-				randomTokenStr,
-			),
-		)
-		if err != nil {
-			svr.Log(err, "unable to send email to admin while posting job ad")
-		}
 		if sess != nil {
-			err = database.InitiatePaymentEventForJobAd(
-				svr.Conn,
-				sess.ID,
-				payment.PlanTypeAndDurationToAmount(
-					jobRq.PlanType,
-					int64(jobRq.PlanDuration),
-					int64(svr.GetConfig().PlanID1Price),
-					int64(svr.GetConfig().PlanID2Price),
-					int64(svr.GetConfig().PlanID3Price),
-				),
-				payment.PlanTypeAndDurationToDescription(
-					jobRq.PlanType,
-					int64(jobRq.PlanDuration),
-				),
-				jobRq.Email,
-				jobID,
-				jobRq.PlanType,
-				int64(jobRq.PlanDuration),
-			)
-			if err != nil {
-				svr.Log(err, "unable to save payment initiated event")
-			}
 			svr.JSON(w, http.StatusOK, map[string]string{"s_id": sess.ID})
 			return
 		}
@@ -3267,6 +3022,116 @@ func SubmitJobPostPageHandler(svr server.Server, jobRepo *job.Repository, paymen
 	}
 }
 
+// validateJobRqPlan parses and validates the plan type and duration of a new job request
+func validateJobRqPlan(jobRq *job.JobRq) error {
+	planDurationInt, err := strconv.Atoi(jobRq.PlanDurationStr)
+	if err != nil {
+		return errors.New("invalid plan duration")
+	}
+	jobRq.PlanDuration = planDurationInt
+	jobRq.CurrencyCode = strings.ToUpper(strings.TrimSpace(jobRq.CurrencyCode))
+	if jobRq.CurrencyCode == "" {
+		jobRq.CurrencyCode = payment.BaseCurrency
+	}
+	if jobRq.PlanType != job.JobPlanTypeBasic && jobRq.PlanType != job.JobPlanTypePro && jobRq.PlanType != job.JobPlanTypePlatinum {
+		return errors.New("invalid plan type")
+	}
+	if jobRq.PlanDuration > 6 || jobRq.PlanDuration < 1 {
+		return errors.New("invalid plan duration")
+	}
+	return nil
+}
+
+// saveJobDraft saves the job draft with its edit token. It returns the job ID and the edit token
+func saveJobDraft(svr server.Server, jobRepo *job.Repository, jobRq *job.JobRq) (int, string, error) {
+	jobID, err := jobRepo.SaveDraft(jobRq)
+	if err != nil {
+		svr.Log(err, fmt.Sprintf("unable to save job request: %#v", jobRq))
+		return 0, "", err
+	}
+	if jobID == 0 {
+		svr.Log(err, fmt.Sprintf("unable to save job request: %#v", jobRq))
+		return 0, "", errors.New("unable to save job request invalid job returned")
+	}
+	k, err := ksuid.NewRandom()
+	if err != nil {
+		svr.Log(err, "unable to generate unique token")
+		return 0, "", err
+	}
+	randomToken, err := k.Value()
+	if err != nil {
+		svr.Log(err, "unable to get token value")
+		return 0, "", err
+	}
+	randomTokenStr, ok := randomToken.(string)
+	if !ok {
+		svr.Log(err, "unbale to assert token value as string")
+		return 0, "", errors.New("unbale to assert token value as string")
+	}
+	err = jobRepo.SaveTokenForJob(randomTokenStr, jobID)
+	if err != nil {
+		svr.Log(err, "unbale to generate token")
+		return 0, "", err
+	}
+	return jobID, randomTokenStr, nil
+}
+
+// saveJobDraftAndCreatePaymentSession saves the job draft with its edit token,
+// notifies the admin and starts the stripe checkout session for the chosen plan at price.
+// It returns the job ID, the edit token and the checkout session (nil if stripe failed), or
+// promocode.ErrFullyRedeemed if the promo code ran out of redemptions in the meantime
+func saveJobDraftAndCreatePaymentSession(svr server.Server, jobRepo *job.Repository, paymentRepo *payment.Repository, jobRq *job.JobRq, price jobAdPrice) (int, string, *stripe.CheckoutSession, error) {
+	jobID, randomTokenStr, err := saveJobDraft(svr, jobRepo, jobRq)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	jobRq.PromoCode = price.PromoCode.Code
+	jobRq.CurrencyCode = price.Currency
+	sess, err := paymentRepo.CreateJobAdSession(jobRq, randomTokenStr, price.MonthlyAmount, int64(jobRq.PlanDuration), price.Discount)
+	if err != nil {
+		svr.Log(err, "unable to create payment session")
+	}
+	err = svr.GetEmail().SendHTMLEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+		email.Address{Email: svr.GetEmail().DefaultAdminAddress()},
+		email.Address{Email: jobRq.Email},
+		fmt.Sprintf("New Job Ad on %s", svr.GetConfig().SiteName),
+		fmt.Sprintf(
+			"Hey! There is a new Ad on %s. Please approve %s%s/manage/%s",
+			svr.GetConfig().SiteName,
+			svr.GetConfig().URLProtocol,
+			svr.GetConfig().SiteHost,
+			randomTokenStr,
+		),
+	)
+	if err != nil {
+		svr.Log(err, "unable to send email to admin while posting job ad")
+	}
+	if sess != nil {
+		err = database.InitiatePaymentEventForJobAd(
+			svr.Conn,
+			sess.ID,
+			price.Amount,
+			price.Currency,
+			price.BaseAmount,
+			jobAdPurchaseDescription(jobRq.PlanType, jobRq.PlanDuration, price),
+			jobRq.Email,
+			jobID,
+			jobRq.PlanType,
+			int64(jobRq.PlanDuration),
+			price.PromoCode.ID,
+			price.Discount,
+		)
+		if err == promocode.ErrFullyRedeemed {
+			return jobID, randomTokenStr, nil, err
+		}
+		if err != nil {
+			svr.Log(err, "unable to save payment initiated event")
+		}
+	}
+	return jobID, randomTokenStr, sess, nil
+}
+
 func RetrieveMediaPageHandler(svr server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -3443,7 +3308,7 @@ func SaveMediaPageHandler(svr server.Server) http.HandlerFunc {
 			svr.Log(errors.New("content type not supported for encoding"), fmt.Sprintf("content type %s not supported for encoding", contentType))
 			svr.JSON(w, http.StatusInternalServerError, nil)
 		}
-		id, err := database.SaveMedia(svr.Conn, database.Media{cutImageBytes.Bytes(), contentType})
+		id, err := database.SaveMedia(svr.Conn, database.Media{Bytes: cutImageBytes.Bytes(), MediaType: contentType})
 		if err != nil {
 			svr.Log(err, "unable to save media image to db")
 			svr.JSON(w, http.StatusInternalServerError, nil)
@@ -3468,6 +3333,11 @@ func UpdateJobPageHandler(svr server.Server, jobRepo *job.Repository) http.Handl
 			svr.JSON(w, http.StatusNotFound, nil)
 			return
 		}
+		jobRq.ScreeningQuestions, err = jobRq.ScreeningQuestions.Normalize()
+		if err != nil {
+			svr.JSON(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		err = jobRepo.UpdateJob(jobRq, jobID)
 		if err != nil {
 			svr.Log(err, fmt.Sprintf("unable to update job request: %#v", jobRq))
@@ -3509,7 +3379,7 @@ func PermanentlyDeleteJobByToken(svr server.Server, jobRepo *job.Repository) htt
 	)
 }
 
-func ApproveJobPageHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
+func ApproveJobPageHandler(svr server.Server, jobRepo *job.Repository, savedSearchRepo *savedsearch.Repository) http.HandlerFunc {
 	return middleware.AdminAuthenticatedMiddleware(
 		svr.SessionStore,
 		svr.GetJWTSigningKey(),
@@ -3546,6 +3416,7 @@ func ApproveJobPageHandler(svr server.Server, jobRepo *job.Repository) http.Hand
 			if err := svr.CacheDelete(server.CacheKeyPinnedJobs); err != nil {
 				svr.Log(err, "unable to cleanup cache after approving job")
 			}
+			sendInstantSavedSearchAlerts(svr, jobRepo, savedSearchRepo)
 			svr.JSON(w, http.StatusOK, nil)
 		},
 	)
@@ -3666,10 +3537,14 @@ func EditJobViewPageHandler(svr server.Server, jobRepo *job.Repository, recRepo
 		if err != nil {
 			svr.Log(err, fmt.Sprintf("unable to marshal stats for job id %d", jobID))
 		}
-		applicants, err := jobRepo.GetApplicantsForJob(jobID)
+		cvQuery := strings.TrimSpace(r.URL.Query().Get("q"))
+		applicants, err := jobRepo.SearchApplicantsForJob(jobID, cvQuery)
 		if err != nil {
 			svr.Log(err, fmt.Sprintf("unable to retrieve job applicants for job id %d", jobPost.ID))
 		}
+		for _, a := range applicants {
+			a.CvSnippetHTML = svr.StringToHTML(a.CvSnippet)
+		}
 
 		profile, _ := recRepo.RecruiterProfileByEmail(jobPost.CompanyEmail)
 		isSignedOn := middleware.IsSignedOn(r, svr.SessionStore, svr.GetJWTSigningKey())
@@ -3691,12 +3566,34 @@ func EditJobViewPageHandler(svr server.Server, jobRepo *job.Repository, recRepo
 			"DefaultPlanExpiration":      time.Now().UTC().AddDate(0, 0, 30),
 			"StripePublishableKey":       svr.GetConfig().StripePublishableKey,
 			"Applicants":                 applicants,
+			"ApplicantStages":            job.ApplicantStages,
+			"CvQuery":                    cvQuery,
+			"ApplicantRatings":           applicantRatings(),
+			"ApplicantAnswers":           applicantAnswers(applicants),
 			"HasProfile":                 profile.ID != "",
 			"IsSignedOn":                 isSignedOn,
 		})
 	}
 }
 
+func applicantRatings() []int {
+	ratings := make([]int, 0, job.ApplicantMaxRating)
+	for i := 1; i <= job.ApplicantMaxRating; i++ {
+		ratings = append(ratings, i)
+	}
+	return ratings
+}
+
+// applicantAnswers returns the screening answers of each applicant keyed by apply token and question ID,
+// used to filter the applicants on the edit page
+func applicantAnswers(applicants []*job.Applicant) map[string]map[string][]string {
+	answers := make(map[string]map[string][]string, len(applicants))
+	for _, a := range applicants {
+		answers[a.Token] = a.Answers.ByQuestion()
+	}
+	return answers
+}
+
 func ManageJobBySlugViewPageHandler(svr server.Server, jobRepo *job.Repository) http.HandlerFunc {
 	return middleware.AdminAuthenticatedMiddleware(
 		svr.SessionStore,
@@ -3779,8 +3676,14 @@ func DownloadJobApplicationCvHandler(svr server.Server, jobRepo *job.Repository)
 			svr.JSON(w, http.StatusNotFound, nil)
 			return
 		}
-		w.Header().Set("Content-Type", "application/pdf")
+		if applicant.CvPurgedAt.Valid {
+			svr.JSON(w, http.StatusGone, "This CV was deleted at the end of its retention period")
+			return
+		}
+		w.Header().Set("Content-Type", applicant.CvContentType)
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", applicant.CvSize))
+		// browsers display PDFs and text inline, office documents are downloaded
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "cv"+cv.Extension(applicant.CvContentType)))
 
 		_, err = w.Write(applicant.Cv)
 		if err != nil {
@@ -4028,7 +3931,7 @@ func GetUserBlogPostsHandler(svr server.Server, blogPostRepo *blog.Repository) h
 	)
 }
 
-func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, recRepo *recruiter.Repository) http.HandlerFunc {
+func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, recRepo *recruiter.Repository, apiKeyRepo *apikey.Repository) http.HandlerFunc {
 	return middleware.UserAuthenticatedMiddleware(
 		svr.SessionStore,
 		svr.GetJWTSigningKey(),
@@ -4039,6 +3942,10 @@ func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, re
 				svr.JSON(w, http.StatusForbidden, nil)
 				return
 			}
+			apiKeys, err := apiKeyRepo.GetForUser(profile.UserID)
+			if err != nil {
+				svr.Log(err, "unable to retrieve api keys for user")
+			}
 			switch profile.Type {
 			case user.UserTypeDeveloper:
 				dev, err := devRepo.DeveloperProfileByEmail(profile.Email)
@@ -4054,6 +3961,7 @@ func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, re
 					"UserCreatedAt": profile.CreatedAt,
 					"ProfileID":     dev.ID,
 					"UserType":      profile.Type,
+					"APIKeys":       apiKeys,
 					"Developer":     dev,
 					"DevOfferLink1": svr.GetConfig().DevOfferLink1,
 					"DevOfferLink2": svr.GetConfig().DevOfferLink2,
@@ -4082,6 +3990,7 @@ func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, re
 					"UserCreatedAt":        profile.CreatedAt,
 					"ProfileID":            rec.ID,
 					"UserType":             profile.Type,
+					"APIKeys":              apiKeys,
 					"Recruiter":            rec,
 					"StripePublishableKey": svr.GetConfig().StripePublishableKey,
 				})
@@ -4099,6 +4008,7 @@ func ProfileHomepageHandler(svr server.Server, devRepo *developer.Repository, re
 					"UserCreatedAt": profile.CreatedAt,
 					"ProfileID":     dev.ID,
 					"UserType":      profile.Type,
+					"APIKeys":       apiKeys,
 					"Developer":     dev,
 					"DevOfferLink1": svr.GetConfig().DevOfferLink1,
 					"DevOfferLink2": svr.GetConfig().DevOfferLink2,
@@ -4134,7 +4044,7 @@ func TriggerExpiredUserSignOnTokensTask(svr server.Server, userRepo *user.Reposi
 	)
 }
 
-func RecruiterJobPosts(svr server.Server, devRepo *developer.Repository, recRepo *recruiter.Repository, jobRepo *job.Repository) http.HandlerFunc {
+func RecruiterJobPosts(svr server.Server, devRepo *developer.Repository, recRepo *recruiter.Repository, jobRepo *job.Repository, invoiceRepo *invoice.Repository, jobCreditRepo *jobcredit.Repository) http.HandlerFunc {
 	return middleware.UserAuthenticatedMiddleware(
 		svr.SessionStore,
 		svr.GetJWTSigningKey(),
@@ -4162,16 +4072,43 @@ func RecruiterJobPosts(svr server.Server, devRepo *developer.Repository, recRepo
 				svr.JSON(w, http.StatusInternalServerError, "Oops! An internal error has occurred")
 				return
 			}
+			invoices, err := invoiceRepo.InvoicesForEmail(profile.Email)
+			if err != nil {
+				svr.Log(err, "unable to get invoices for recruiter")
+				svr.JSON(w, http.StatusInternalServerError, "Oops! An internal error has occurred")
+				return
+			}
+			creditBalances := make([]jobcredit.Balance, 0)
+			if rec.ID != "" {
+				creditBalances, err = jobCreditRepo.Balances(rec.ID)
+				if err != nil {
+					svr.Log(err, "unable to get job credits for recruiter")
+					svr.JSON(w, http.StatusInternalServerError, "Oops! An internal error has occurred")
+					return
+				}
+			}
+			creditPackOptions, err := jobCreditPackOptions(svr)
+			if err != nil {
+				svr.Log(err, "unable to price job credit packs")
+				svr.JSON(w, http.StatusInternalServerError, "Oops! An internal error has occurred")
+				return
+			}
 			svr.Render(r, w, http.StatusOK, "recruiter-job-posts.html", map[string]interface{}{
-				"Jobs":          jobsForPage,
-				"totalJobCount": totalJobCount,
-				"IsAdmin":       profile.IsAdmin,
-				"UserID":        profile.UserID,
-				"UserEmail":     profile.Email,
-				"UserCreatedAt": profile.CreatedAt,
-				"ProfileID":     rec.ID,
-				"UserType":      profile.Type,
-				"Recruiter":     rec,
+				"Jobs":                 jobsForPage,
+				"Invoices":             invoices,
+				"CreditBalances":       creditBalances,
+				"CreditPackOptions":    creditPackOptions,
+				"CreditPackDiscount":   svr.GetConfig().JobCreditPackDiscount,
+				"CreditsPurchased":     r.URL.Query().Get("credits"),
+				"StripePublishableKey": svr.GetConfig().StripePublishableKey,
+				"totalJobCount":        totalJobCount,
+				"IsAdmin":              profile.IsAdmin,
+				"UserID":               profile.UserID,
+				"UserEmail":            profile.Email,
+				"UserCreatedAt":        profile.CreatedAt,
+				"ProfileID":            rec.ID,
+				"UserType":             profile.Type,
+				"Recruiter":            rec,
 			})
 		})
 }
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-cafe/job-board/internal/database"
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/invoice"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/server"
+	"github.com/gorilla/mux"
+)
+
+func invoiceIssuer(svr server.Server) invoice.Issuer {
+	return invoice.Issuer{
+		Lines: svr.GetConfig().InvoiceIssuer,
+		VATID: svr.GetConfig().InvoiceIssuerVATID,
+	}
+}
+
+// jobAdInvoice bills the job ad purchase to the details entered at checkout, falling back to the
+// job's company name and the purchase amount for sessions without them
+func jobAdInvoice(svr server.Server, jobPost job.JobPost, purchaseEvent database.PurchaseEvent, billing payment.CheckoutBilling) invoice.Invoice {
+	inv := invoice.Invoice{
+		JobID:           jobPost.ID,
+		StripeSessionID: purchaseEvent.StripeSessionID,
+		Email:           purchaseEvent.Email,
+		BillingName:     billing.Name,
+		BillingAddress:  billing.Address,
+		BillingVATID:    billing.VATID,
+		Description:     fmt.Sprintf("%s Job Ad \"%s\", %s", svr.GetConfig().SiteName, jobPost.JobTitle, purchaseEvent.Description),
+		Amount:          int(billing.AmountTotal),
+		Currency:        strings.ToUpper(billing.Currency),
+	}
+	if inv.BillingName == "" {
+		inv.BillingName = jobPost.Company
+	}
+	if inv.Amount == 0 || inv.Currency == "" {
+		inv.Amount = purchaseEvent.Amount
+		inv.Currency = strings.ToUpper(purchaseEvent.Currency)
+	}
+	return inv
+}
+
+func sendInvoiceEmail(svr server.Server, inv invoice.Invoice) error {
+	return svr.SendTemplatedEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		email.Address{Email: inv.Email},
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		fmt.Sprintf("Your %s invoice %s", svr.GetConfig().SiteName, inv.NumberString()),
+		"invoice-email",
+		map[string]interface{}{
+			"Invoice": inv,
+		},
+		email.Attachment{
+			Name:        inv.FileName(),
+			ContentType: "application/pdf",
+			B64Data:     base64.StdEncoding.EncodeToString(inv.PDF),
+		},
+	)
+}
+
+// DownloadInvoiceHandler serves the PDF of an invoice billed to the signed on user or for one of
+// their jobs, admins can download any invoice
+func DownloadInvoiceHandler(svr server.Server, invoiceRepo *invoice.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			id := mux.Vars(r)["id"]
+			var inv invoice.Invoice
+			if profile.IsAdmin {
+				inv, err = invoiceRepo.InvoiceByID(id)
+			} else {
+				inv, err = invoiceRepo.InvoiceForEmail(id, profile.Email)
+			}
+			if err == sql.ErrNoRows {
+				svr.JSON(w, http.StatusNotFound, "invoice not found")
+				return
+			}
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to retrieve invoice %s", id))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(inv.PDF)))
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", inv.FileName()))
+			if _, err := w.Write(inv.PDF); err != nil {
+				svr.Log(err, fmt.Sprintf("unable to write invoice %s", id))
+			}
+		},
+	)
+}
@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/invoice"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/jobcredit"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/recruiter"
+	"github.com/golang-cafe/job-board/internal/server"
+	stripe "github.com/stripe/stripe-go"
+)
+
+// jobCreditPackOptions lists the credit packs on sale for each plan, priced in US dollars at the
+// monthly plan price with the bulk discount off
+func jobCreditPackOptions(svr server.Server) ([]jobcredit.PackOption, error) {
+	prices, err := svr.JobAdPlanPrices(payment.BaseCurrency)
+	if err != nil {
+		return nil, err
+	}
+	options := make([]jobcredit.PackOption, 0)
+	for _, planType := range []string{job.JobPlanTypeBasic, job.JobPlanTypePro, job.JobPlanTypePlatinum} {
+		for _, credits := range svr.GetConfig().JobCreditPackSizes {
+			options = append(options, jobcredit.PackOption{
+				PlanType: planType,
+				Credits:  credits,
+				Amount:   int(jobcredit.PackPrice(prices.Monthly(planType), credits, svr.GetConfig().JobCreditPackDiscount)),
+			})
+		}
+	}
+	return options, nil
+}
+
+// JobCreditsHandler returns the job credits the signed on recruiter has left on each plan
+func JobCreditsHandler(svr server.Server, recRepo *recruiter.Repository, jobCreditRepo *jobcredit.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			rec, err := recRepo.RecruiterProfileByEmail(profile.Email)
+			if err != nil {
+				svr.Log(err, "unable to find recruiter profile")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			res := make([]map[string]interface{}, 0)
+			if rec.ID == "" {
+				svr.JSON(w, http.StatusOK, map[string]interface{}{"balances": res})
+				return
+			}
+			balances, err := jobCreditRepo.Balances(rec.ID)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to retrieve job credits for recruiter %s", rec.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			for _, b := range balances {
+				res = append(res, map[string]interface{}{
+					"plan_type":           b.PlanType,
+					"credits":             b.Credits,
+					"next_expiry_at":      b.NextExpiryAt.Format("2006-01-02"),
+					"next_expiry_credits": b.NextExpiryCredits,
+				})
+			}
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"balances": res})
+		},
+	)
+}
+
+// BuyJobCreditPackHandler starts the checkout of a job credit pack for the signed on recruiter
+func BuyJobCreditPackHandler(svr server.Server, recRepo *recruiter.Repository, paymentRepo *payment.Repository, jobCreditRepo *jobcredit.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			req := struct {
+				PlanType string `json:"plan_type"`
+				Credits  int    `json:"credits"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				svr.JSON(w, http.StatusBadRequest, "invalid request")
+				return
+			}
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			rec, err := recRepo.RecruiterProfileByEmail(profile.Email)
+			if err != nil {
+				svr.Log(err, "unable to find recruiter profile")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if rec.ID == "" {
+				svr.JSON(w, http.StatusForbidden, "job credits can only be bought with a recruiter account")
+				return
+			}
+			options, err := jobCreditPackOptions(svr)
+			if err != nil {
+				svr.Log(err, "unable to price job credit packs")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			var option jobcredit.PackOption
+			for _, o := range options {
+				if o.PlanType == req.PlanType && o.Credits == req.Credits {
+					option = o
+				}
+			}
+			if option.Credits == 0 {
+				svr.JSON(w, http.StatusBadRequest, "invalid job credit pack")
+				return
+			}
+			sess, err := paymentRepo.CreateJobCreditPackSession(rec.Email, rec.ID, option.PlanType, option.Credits, int64(option.Amount))
+			if err != nil {
+				svr.Log(err, "unable to create job credit pack payment session")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			_, err = jobCreditRepo.CreatePack(jobcredit.Pack{
+				RecruiterID:     rec.ID,
+				Email:           rec.Email,
+				PlanType:        option.PlanType,
+				Credits:         option.Credits,
+				Amount:          option.Amount,
+				Currency:        payment.BaseCurrency,
+				StripeSessionID: sess.ID,
+			})
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to save job credit pack for session %s", sess.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, map[string]string{"s_id": sess.ID})
+		},
+	)
+}
+
+// jobCreditPackInvoice bills the credit pack to the details entered at checkout, falling back to the
+// recruiter's email and the pack price for sessions without them
+func jobCreditPackInvoice(svr server.Server, pack jobcredit.Pack, billing payment.CheckoutBilling) invoice.Invoice {
+	inv := invoice.Invoice{
+		StripeSessionID: pack.StripeSessionID,
+		Email:           pack.Email,
+		BillingName:     billing.Name,
+		BillingAddress:  billing.Address,
+		BillingVATID:    billing.VATID,
+		Description:     fmt.Sprintf("%s %s", svr.GetConfig().SiteName, payment.JobCreditPackDescription(pack.PlanType, pack.Credits)),
+		Amount:          int(billing.AmountTotal),
+		Currency:        strings.ToUpper(billing.Currency),
+	}
+	if inv.BillingName == "" {
+		inv.BillingName = pack.Email
+	}
+	if inv.Amount == 0 || inv.Currency == "" {
+		inv.Amount = pack.Amount
+		inv.Currency = pack.Currency
+	}
+	return inv
+}
+
+// completeJobCreditPack makes the credits of a paid pack available and invoices it
+func completeJobCreditPack(svr server.Server, jobCreditRepo *jobcredit.Repository, invoiceRepo *invoice.Repository, pack jobcredit.Pack, paymentIntentID string, billing payment.CheckoutBilling) error {
+	expiresAt := time.Now().UTC().AddDate(0, 0, svr.GetConfig().JobCreditExpiryDays)
+	completed, err := jobCreditRepo.Complete(pack.StripeSessionID, paymentIntentID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("unable to complete job credit pack for session id %s: %v", pack.StripeSessionID, err)
+	}
+	inv, created, err := invoiceRepo.Create(jobCreditPackInvoice(svr, pack, billing), invoiceIssuer(svr))
+	if err != nil {
+		return fmt.Errorf("unable to create invoice for job credit pack session id %s: %v", pack.StripeSessionID, err)
+	}
+	if completed {
+		err = svr.GetEmail().SendHTMLEmail(
+			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+			email.Address{Email: pack.Email},
+			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+			fmt.Sprintf("Your job credits are ready on %s", svr.GetConfig().SiteName),
+			fmt.Sprintf("Your payment has been received successfully and %d %s Plan job credits have been added to your account. Each credit pays for a month of a %s Plan Job Ad and they can be spent until %s. You can check your balance by following this link %s%s/profile/jobs",
+				pack.Credits,
+				strings.Title(pack.PlanType),
+				strings.Title(pack.PlanType),
+				expiresAt.Format("2 January 2006"),
+				svr.GetConfig().URLProtocol,
+				svr.GetConfig().SiteHost,
+			),
+		)
+		if err != nil {
+			svr.Log(err, "unable to send email while completing job credit pack")
+		}
+	}
+	if created {
+		if err := sendInvoiceEmail(svr, inv); err != nil {
+			svr.Log(err, fmt.Sprintf("unable to send invoice %s", inv.NumberString()))
+		}
+	}
+	return nil
+}
+
+// revokeJobCreditPack takes away the credits left in a pack whose payment was reversed, jobs already
+// paid with its credits stay live
+func revokeJobCreditPack(svr server.Server, jobCreditRepo *jobcredit.Repository, pack jobcredit.Pack, ch *stripe.Charge, reversal string) error {
+	if err := jobCreditRepo.Revoke(pack.StripeSessionID); err != nil {
+		return fmt.Errorf("unable to revoke job credit pack %s after %s of charge %s: %v", pack.ID, reversal, ch.ID, err)
+	}
+	notifyAdminOfPaymentReversal(svr, ch, reversal, fmt.Sprintf("The %d credits left of %s bought by %s have been revoked", pack.Remaining, payment.JobCreditPackDescription(pack.PlanType, pack.Credits), pack.Email))
+	return nil
+}
+
+// publishJobPostWithCredits saves the job and publishes it straight away, paid with the credits the
+// recruiter has for its plan. It returns the edit token, jobcredit.ErrInsufficientCredits when the
+// recruiter hasn't enough credits left
+func publishJobPostWithCredits(svr server.Server, jobRepo *job.Repository, jobCreditRepo *jobcredit.Repository, rec recruiter.Recruiter, jobRq *job.JobRq) (string, error) {
+	balances, err := jobCreditRepo.Balances(rec.ID)
+	if err != nil {
+		return "", err
+	}
+	if jobcredit.CreditsFor(balances, jobRq.PlanType) < jobRq.PlanDuration {
+		return "", jobcredit.ErrInsufficientCredits
+	}
+	// the job is listed on the recruiter's account the credits belong to
+	jobRq.Email = rec.Email
+	jobID, token, err := saveJobDraft(svr, jobRepo, jobRq)
+	if err != nil {
+		return "", err
+	}
+	if err := jobCreditRepo.Spend(rec.ID, jobRq.PlanType, jobRq.PlanDuration, jobID); err != nil {
+		return "", err
+	}
+	expiration, err := jobRepo.PlanTypeAndDurationToExpirations(jobRq.PlanType, jobRq.PlanDuration)
+	if err == nil {
+		err = jobRepo.UpdateJobPlan(jobID, jobRq.PlanType, jobRq.PlanDuration, expiration, job.PlanEventPaidWithCredits)
+	}
+	if err != nil {
+		if restoreErr := jobCreditRepo.Restore(jobID); restoreErr != nil {
+			svr.Log(restoreErr, fmt.Sprintf("unable to restore job credits spent on job id %d", jobID))
+		}
+		return "", fmt.Errorf("unable to publish job id %d with job credits: %v", jobID, err)
+	}
+	err = svr.GetEmail().SendHTMLEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+		email.Address{Email: svr.GetEmail().DefaultAdminAddress()},
+		email.Address{Email: jobRq.Email},
+		fmt.Sprintf("New Job Ad on %s", svr.GetConfig().SiteName),
+		fmt.Sprintf(
+			"Hey! There is a new Ad on %s paid with %d %s Plan job credits. You can review it at %s%s/manage/%s",
+			svr.GetConfig().SiteName,
+			jobRq.PlanDuration,
+			strings.Title(jobRq.PlanType),
+			svr.GetConfig().URLProtocol,
+			svr.GetConfig().SiteHost,
+			token,
+		),
+	)
+	if err != nil {
+		svr.Log(err, "unable to send email to admin while posting job ad with job credits")
+	}
+	if err := sendJobAdLiveEmail(svr, jobRq.Email, token); err != nil {
+		svr.Log(err, "unable to send email while publishing job ad with job credits")
+	}
+	if err := svr.CacheDelete(server.CacheKeyPinnedJobs); err != nil {
+		svr.Log(err, "unable to cleanup cache after publishing job")
+	}
+	return token, nil
+}
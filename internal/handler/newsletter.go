@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-cafe/job-board/internal/database"
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/newsletter"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+const newsletterBatchSize = 100
+
+// newsletterSending guards against overlapping runs of the newsletter task
+var newsletterSending int32
+
+// TriggerWeeklyNewsletter sends the weekly newsletter campaign. Progress is
+// checkpointed in the meta table after every batch so a crashed run resumes
+// from the last recipient on the next trigger
+func TriggerWeeklyNewsletter(svr server.Server, jobRepo *job.Repository, newsletterRepo *newsletter.Repository) http.HandlerFunc {
+	return middleware.MachineAuthenticatedMiddleware(
+		svr.GetConfig().MachineToken,
+		func(w http.ResponseWriter, r *http.Request) {
+			if !atomic.CompareAndSwapInt32(&newsletterSending, 0, 1) {
+				svr.JSON(w, http.StatusConflict, map[string]interface{}{"status": "already running"})
+				return
+			}
+			go func() {
+				defer atomic.StoreInt32(&newsletterSending, 0)
+				// emails of past campaigns are delivered by the outbox after the campaign completes
+				pending, err := newsletterRepo.CampaignsPendingDelivery()
+				if err != nil {
+					svr.Log(err, "unable to retrieve newsletter campaigns pending delivery")
+				}
+				for _, id := range pending {
+					if err := newsletterRepo.UpdateCampaignProgress(id); err != nil {
+						svr.Log(err, fmt.Sprintf("unable to update newsletter campaign %s progress", id))
+					}
+				}
+				campaign, err := currentOrNewNewsletterCampaign(svr, jobRepo, newsletterRepo)
+				if err != nil {
+					svr.Log(err, "unable to start newsletter campaign")
+					return
+				}
+				if campaign.ID == "" {
+					log.Printf("found 0 jobs eligible for weekly newsletter. quitting")
+					return
+				}
+				if err := sendNewsletterCampaign(svr, jobRepo, newsletterRepo, campaign); err != nil {
+					svr.Log(err, fmt.Sprintf("unable to send newsletter campaign %s", campaign.ID))
+				}
+			}()
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+		},
+	)
+}
+
+// currentOrNewNewsletterCampaign resumes the campaign recorded in meta, or creates a new one
+// from the jobs approved since the previous campaign whose plan includes the newsletter. An empty
+// campaign means there is nothing to send
+func currentOrNewNewsletterCampaign(svr server.Server, jobRepo *job.Repository, newsletterRepo *newsletter.Repository) (newsletter.Campaign, error) {
+	campaignID, err := jobRepo.GetValue(newsletter.MetaKeyCurrentCampaignID)
+	if err != nil {
+		return newsletter.Campaign{}, err
+	}
+	if campaignID != "" {
+		campaign, err := newsletterRepo.GetCampaign(campaignID)
+		if err == nil {
+			log.Printf("resuming newsletter campaign %s\n", campaign.ID)
+			return campaign, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return newsletter.Campaign{}, err
+		}
+	}
+	// only jobs approved since the previous campaign are new to subscribers
+	since, err := newsletterRepo.LastCampaignCreatedAt()
+	if err != nil {
+		return newsletter.Campaign{}, err
+	}
+	jobPosts, err := jobRepo.GetJobsForNewsletter(svr.GetConfig().NewsletterJobsToSend, since)
+	if err != nil {
+		return newsletter.Campaign{}, err
+	}
+	if len(jobPosts) == 0 {
+		return newsletter.Campaign{}, nil
+	}
+	recipientCount, err := database.CountEmailSubscribers(svr.Conn)
+	if err != nil {
+		return newsletter.Campaign{}, err
+	}
+	jobIDs := make([]int64, 0, len(jobPosts))
+	for _, j := range jobPosts {
+		jobIDs = append(jobIDs, int64(j.ID))
+	}
+	subject := fmt.Sprintf("%s Jobs This Week (%d New)", strings.Title(svr.GetConfig().SiteJobCategory), len(jobPosts))
+	campaign, err := newsletterRepo.CreateCampaign(subject, jobIDs, recipientCount)
+	if err != nil {
+		return newsletter.Campaign{}, err
+	}
+	if err := jobRepo.SetValue(newsletter.MetaKeyLastRecipient, ""); err != nil {
+		return newsletter.Campaign{}, err
+	}
+	if err := jobRepo.SetValue(newsletter.MetaKeyCurrentCampaignID, campaign.ID); err != nil {
+		return newsletter.Campaign{}, err
+	}
+	log.Printf("created newsletter campaign %s with %d jobs for %d subscribers\n", campaign.ID, len(jobPosts), recipientCount)
+	return campaign, nil
+}
+
+func sendNewsletterCampaign(svr server.Server, jobRepo *job.Repository, newsletterRepo *newsletter.Repository, campaign newsletter.Campaign) error {
+	jobPosts, err := jobRepo.GetJobsByIDs(campaign.JobIDs)
+	if err != nil {
+		return err
+	}
+	lastRecipient, err := jobRepo.GetValue(newsletter.MetaKeyLastRecipient)
+	if err != nil {
+		return err
+	}
+	siteURL := svr.GetConfig().URLProtocol + svr.GetConfig().SiteHost
+	for {
+		subscribers, err := database.GetEmailSubscribersBatch(svr.Conn, lastRecipient, newsletterBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(subscribers) == 0 {
+			break
+		}
+		for _, s := range subscribers {
+			// a crash mid batch means part of it was already delivered before the last checkpoint
+			sent, err := newsletterRepo.WasSent(campaign.ID, s.Email)
+			if err != nil {
+				return err
+			}
+			if sent {
+				continue
+			}
+			outboxID, sendErr := svr.QueueTemplatedEmail(
+				email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+				email.Address{Email: s.Email},
+				email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+				campaign.Subject,
+				"newsletter-email",
+				map[string]interface{}{
+					"Jobs":           jobPosts,
+					"Subject":        campaign.Subject,
+					"Email":          s.Email,
+					"UnsubscribeURL": fmt.Sprintf("%s/x/email/unsubscribe?token=%s", siteURL, s.Token),
+				},
+			)
+			if sendErr != nil {
+				svr.Log(sendErr, fmt.Sprintf("unable to send email for newsletter email %s", s.Email))
+			}
+			if err := newsletterRepo.LogSend(campaign.ID, s.Email, outboxID, sendErr); err != nil {
+				return err
+			}
+		}
+		lastRecipient = subscribers[len(subscribers)-1].Email
+		if err := jobRepo.SetValue(newsletter.MetaKeyLastRecipient, lastRecipient); err != nil {
+			return err
+		}
+		if err := newsletterRepo.UpdateCampaignProgress(campaign.ID); err != nil {
+			return err
+		}
+	}
+	if err := newsletterRepo.UpdateCampaignProgress(campaign.ID); err != nil {
+		return err
+	}
+	if err := newsletterRepo.CompleteCampaign(campaign.ID); err != nil {
+		return err
+	}
+	if err := jobRepo.SetValue(newsletter.MetaKeyCurrentCampaignID, ""); err != nil {
+		return err
+	}
+	if err := jobRepo.SetValue(newsletter.MetaKeyLastRecipient, ""); err != nil {
+		return err
+	}
+	log.Printf("completed newsletter campaign %s\n", campaign.ID)
+	return nil
+}
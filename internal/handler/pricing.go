@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/promocode"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+// jobAdPrice is the price of a job ad plan for its whole duration, in the minor unit of Currency
+type jobAdPrice struct {
+	Currency      string
+	MonthlyAmount int64
+	Amount        int64 // after the discount
+	Discount      int64
+	BaseAmount    int64               // Amount in US cents, for reporting
+	PromoCode     promocode.PromoCode // zero value without a promo code
+}
+
+// priceJobAd prices the plan in currency and applies the promo code if one is given. A promocode
+// error is returned when the code can't be redeemed for the plan, and payment.ErrCurrencyNotAvailable
+// when job ads can't be paid in currency
+func priceJobAd(svr server.Server, promoRepo *promocode.Repository, planType string, planDuration int, currency string, code string) (jobAdPrice, error) {
+	prices, err := svr.JobAdPlanPrices(currency)
+	if err != nil {
+		return jobAdPrice{}, err
+	}
+	monthlyAmount := prices.Monthly(planType)
+	price := jobAdPrice{
+		Currency:      prices.Currency,
+		MonthlyAmount: monthlyAmount,
+		Amount:        monthlyAmount * int64(planDuration),
+	}
+	price.BaseAmount = prices.ToBase(price.Amount)
+	if promocode.NormalizeCode(code) == "" {
+		return price, nil
+	}
+	promo, err := promoRepo.ByCode(code)
+	if err != nil {
+		return price, err
+	}
+	if err := promo.Validate(planType, time.Now().UTC()); err != nil {
+		return price, err
+	}
+	price.Amount, price.Discount, err = promo.Apply(price.Amount, prices.FromBase)
+	if err != nil {
+		return price, err
+	}
+	price.BaseAmount = prices.ToBase(price.Amount)
+	price.PromoCode = promo
+	return price, nil
+}
+
+// jobAdPurchaseDescription describes the plan bought, and the promo code redeemed if any
+func jobAdPurchaseDescription(planType string, planDuration int, price jobAdPrice) string {
+	description := payment.PlanTypeAndDurationToDescription(planType, int64(planDuration))
+	if price.Discount > 0 {
+		description = fmt.Sprintf("%s, promo code %s", description, price.PromoCode.Code)
+	}
+	return description
+}
+
+// isJobAdPriceUserError reports whether the job ad couldn't be priced because of what the user chose
+func isJobAdPriceUserError(err error) bool {
+	return promocode.IsRedeemError(err) || err == payment.ErrCurrencyNotAvailable
+}
+
+// jobAdPriceError answers a request whose job ad couldn't be priced, promo code and currency errors
+// are shown to the user
+func jobAdPriceError(svr server.Server, w http.ResponseWriter, err error) {
+	if isJobAdPriceUserError(err) {
+		svr.JSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	svr.Log(err, "unable to price job ad")
+	svr.JSON(w, http.StatusInternalServerError, nil)
+}
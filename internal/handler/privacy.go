@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/apikey"
+	"github.com/golang-cafe/job-board/internal/blog"
+	"github.com/golang-cafe/job-board/internal/bookmark"
+	"github.com/golang-cafe/job-board/internal/cv"
+	"github.com/golang-cafe/job-board/internal/developer"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/recruiter"
+	"github.com/golang-cafe/job-board/internal/savedsearch"
+	"github.com/golang-cafe/job-board/internal/server"
+	"github.com/golang-cafe/job-board/internal/user"
+)
+
+var developerMetadataTypes = []string{"experience", "education", "github"}
+
+type userDataApplication struct {
+	bookmark.Application
+	CVFile  string               `json:"cv_file,omitempty"`
+	Answers job.ScreeningAnswers `json:"screening_answers,omitempty"`
+}
+
+type userDataExport struct {
+	ExportedAt        time.Time                     `json:"exported_at"`
+	Account           user.User                     `json:"account"`
+	SignOns           []user.SignOn                 `json:"sign_ons"`
+	DeveloperProfile  *developer.Developer          `json:"developer_profile,omitempty"`
+	DeveloperMetadata []developer.DeveloperMetadata `json:"developer_metadata,omitempty"`
+	RecruiterProfile  *recruiter.Recruiter          `json:"recruiter_profile,omitempty"`
+	MessagesSent      []*developer.DeveloperMessage `json:"messages_sent"`
+	MessagesReceived  []*developer.DeveloperMessage `json:"messages_received"`
+	Bookmarks         []*bookmark.Bookmark          `json:"bookmarks"`
+	Applications      []userDataApplication         `json:"applications"`
+	BlogPosts         []blog.BlogPost               `json:"blog_posts"`
+	JobAlerts         []*savedsearch.SavedSearch    `json:"job_alerts"`
+	APIKeys           []*apikey.APIKey              `json:"api_keys"`
+	cvs               map[string][]byte
+}
+
+// ExportUserDataHandler sends the signed on user a zip archive with all the data held about them,
+// data.json plus the CVs of their job applications
+func ExportUserDataHandler(svr server.Server, userRepo *user.Repository, devRepo *developer.Repository, recRepo *recruiter.Repository, bookmarkRepo *bookmark.Repository, jobRepo *job.Repository, blogRepo *blog.Repository, savedSearchRepo *savedsearch.Repository, apiKeyRepo *apikey.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			u, err := userRepo.GetUserByID(profile.UserID)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to retrieve user %s", profile.UserID))
+				svr.JSON(w, http.StatusNotFound, nil)
+				return
+			}
+			export, err := collectUserData(u, userRepo, devRepo, recRepo, bookmarkRepo, jobRepo, blogRepo, savedSearchRepo, apiKeyRepo)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to collect data for user %s", u.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-data-%s.zip", strings.ToLower(svr.GetConfig().SiteName), export.ExportedAt.Format("2006-01-02"))))
+			if err := writeUserDataZIP(w, export); err != nil {
+				svr.Log(err, fmt.Sprintf("unable to write data export for user %s", u.ID))
+			}
+		},
+	)
+}
+
+func collectUserData(u user.User, userRepo *user.Repository, devRepo *developer.Repository, recRepo *recruiter.Repository, bookmarkRepo *bookmark.Repository, jobRepo *job.Repository, blogRepo *blog.Repository, savedSearchRepo *savedsearch.Repository, apiKeyRepo *apikey.Repository) (userDataExport, error) {
+	var err error
+	export := userDataExport{
+		ExportedAt: time.Now().UTC(),
+		Account:    u,
+		cvs:        make(map[string][]byte),
+	}
+	if export.SignOns, err = userRepo.SignOnHistory(u.Email); err != nil {
+		return export, err
+	}
+	dev, err := devRepo.DeveloperProfileByEmail(u.Email)
+	if err != nil {
+		return export, err
+	}
+	if dev.ID != "" {
+		export.DeveloperProfile = &dev
+		for _, t := range developerMetadataTypes {
+			metadata, err := devRepo.DeveloperMetadataByProfileID(t, dev.ID)
+			if err != nil {
+				return export, err
+			}
+			export.DeveloperMetadata = append(export.DeveloperMetadata, metadata...)
+		}
+		if export.MessagesReceived, err = devRepo.GetDeveloperMessagesSentTo(dev.ID); err != nil {
+			return export, err
+		}
+	}
+	rec, err := recRepo.RecruiterProfileByEmail(u.Email)
+	if err != nil {
+		return export, err
+	}
+	if rec.ID != "" {
+		export.RecruiterProfile = &rec
+	}
+	if export.MessagesSent, err = devRepo.GetDeveloperMessagesSentFrom(u.ID); err != nil {
+		return export, err
+	}
+	if export.Bookmarks, err = bookmarkRepo.GetBookmarksForUser(u.ID); err != nil {
+		return export, err
+	}
+	applications, err := bookmarkRepo.GetApplicationsForUser(u.ID)
+	if err != nil {
+		return export, err
+	}
+	for i, a := range applications {
+		application := userDataApplication{Application: *a}
+		if a.ApplyToken != "" {
+			applicant, err := jobRepo.GetApplicantByApplyToken(a.ApplyToken)
+			if err != nil {
+				return export, err
+			}
+			application.Answers = applicant.Answers
+			if !applicant.CvPurgedAt.Valid && len(applicant.Cv) > 0 {
+				application.CVFile = fmt.Sprintf("cvs/%d-%s%s", i+1, a.JobSlug, cv.Extension(applicant.CvContentType))
+				export.cvs[application.CVFile] = applicant.Cv
+			}
+		}
+		export.Applications = append(export.Applications, application)
+	}
+	if export.BlogPosts, err = blogRepo.GetByCreatedBy(u.ID); err != nil {
+		return export, err
+	}
+	if export.JobAlerts, err = savedSearchRepo.GetForUser(u.ID); err != nil {
+		return export, err
+	}
+	if export.APIKeys, err = apiKeyRepo.GetForUser(u.ID); err != nil {
+		return export, err
+	}
+	return export, nil
+}
+
+func writeUserDataZIP(w http.ResponseWriter, export userDataExport) error {
+	zw := zip.NewWriter(w)
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "data.json", Method: zip.Deflate, Modified: export.ExportedAt})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		return err
+	}
+	for name, b := range export.cvs {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(b); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// EraseUserHandler permanently deletes the signed on user's account and all the data linked to it.
+// The user confirms by sending their email address, admin accounts can't be erased this way and
+// recruiters need to cancel their developer directory subscription first
+func EraseUserHandler(svr server.Server, userRepo *user.Repository, recRepo *recruiter.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			req := &struct {
+				Email string `json:"email"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				svr.JSON(w, http.StatusBadRequest, nil)
+				return
+			}
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			if profile.IsAdmin {
+				svr.JSON(w, http.StatusForbidden, "admin accounts can't be erased")
+				return
+			}
+			if !strings.EqualFold(strings.TrimSpace(req.Email), profile.Email) {
+				svr.JSON(w, http.StatusBadRequest, "email address doesn't match your account")
+				return
+			}
+			u, err := userRepo.GetUserByID(profile.UserID)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to retrieve user %s", profile.UserID))
+				svr.JSON(w, http.StatusNotFound, nil)
+				return
+			}
+			// the recruiter profile holds the only reference to a subscription stripe would keep charging
+			rec, err := recRepo.RecruiterProfileByEmail(u.Email)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				svr.Log(err, fmt.Sprintf("unable to retrieve recruiter profile for user %s", u.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if err == nil && rec.HasSubscription() {
+				svr.JSON(w, http.StatusBadRequest, "please cancel your developer directory subscription from the billing portal before deleting your account")
+				return
+			}
+			if err := userRepo.EraseUser(u); err != nil {
+				svr.Log(err, fmt.Sprintf("unable to erase user %s", u.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			// the session JWT stays valid until it expires, drop the cookie
+			sess, err := svr.SessionStore.Get(r, "____gc")
+			if err == nil {
+				sess.Options.MaxAge = -1
+				if err := sess.Save(r, w); err != nil {
+					svr.Log(err, "unable to clear session cookie")
+				}
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
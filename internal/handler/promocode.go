@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/promocode"
+	"github.com/golang-cafe/job-board/internal/server"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// ValidatePromoCodeHandler checks a promo code entered on the job ad checkout, for the chosen plan
+// when one is given, and describes its discount
+func ValidatePromoCodeHandler(svr server.Server, promoRepo *promocode.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Code            string `json:"code"`
+			PlanType        string `json:"plan_type"`
+			PlanDurationStr string `json:"plan_duration"`
+			CurrencyCode    string `json:"currency_code"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			svr.JSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		if req.PlanType == "" {
+			promo, err := promoRepo.ByCode(req.Code)
+			if err == nil {
+				err = promo.Validate("", time.Now().UTC())
+			}
+			if err != nil {
+				jobAdPriceError(svr, w, err)
+				return
+			}
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"code": promo.Code, "discount": promo.DiscountString()})
+			return
+		}
+		planDuration, err := strconv.Atoi(req.PlanDurationStr)
+		if err != nil || planDuration < 1 || planDuration > 6 {
+			svr.JSON(w, http.StatusBadRequest, "invalid plan duration")
+			return
+		}
+		if req.CurrencyCode == "" {
+			req.CurrencyCode = payment.BaseCurrency
+		}
+		price, err := priceJobAd(svr, promoRepo, req.PlanType, planDuration, req.CurrencyCode, req.Code)
+		if err != nil {
+			jobAdPriceError(svr, w, err)
+			return
+		}
+		svr.JSON(w, http.StatusOK, map[string]interface{}{
+			"code":     price.PromoCode.Code,
+			"discount": price.PromoCode.DiscountString(),
+			"amount":   payment.FormatAmount(price.Amount, price.Currency),
+		})
+	}
+}
+
+func PromoCodesAdminPageHandler(svr server.Server, promoRepo *promocode.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			codes, err := promoRepo.List()
+			if err != nil {
+				svr.Log(err, "unable to retrieve promo codes")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.Render(r, w, http.StatusOK, "promo-codes-admin.html", map[string]interface{}{
+				"PromoCodes":   codes,
+				"Now":          time.Now().UTC(),
+				"PlanTypes":    []string{job.JobPlanTypeBasic, job.JobPlanTypePro, job.JobPlanTypePlatinum},
+				"MonthAndYear": time.Now().UTC().Format("January 2006"),
+			})
+		},
+	)
+}
+
+func CreatePromoCodeHandler(svr server.Server, promoRepo *promocode.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			req := struct {
+				Code           string   `json:"code"`
+				DiscountType   string   `json:"discount_type"`
+				DiscountValue  float64  `json:"discount_value"` // percentage or US dollars
+				PlanTypes      []string `json:"plan_types"`
+				MaxRedemptions int      `json:"max_redemptions"`
+				ExpiresAt      string   `json:"expires_at"` // YYYY-MM-DD, the code expires at the end of the day
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				svr.JSON(w, http.StatusBadRequest, "invalid request")
+				return
+			}
+			p := promocode.PromoCode{
+				Code:           promocode.NormalizeCode(req.Code),
+				DiscountType:   req.DiscountType,
+				PlanTypes:      req.PlanTypes,
+				MaxRedemptions: req.MaxRedemptions,
+			}
+			if p.Code == "" || len(p.Code) > 50 || strings.ContainsAny(p.Code, " \t") {
+				svr.JSON(w, http.StatusBadRequest, "the code must be a single word of up to 50 characters")
+				return
+			}
+			switch p.DiscountType {
+			case promocode.DiscountTypePercent:
+				p.DiscountValue = int(req.DiscountValue)
+				if float64(p.DiscountValue) != req.DiscountValue || p.DiscountValue < 1 || p.DiscountValue > 99 {
+					svr.JSON(w, http.StatusBadRequest, "percentage discounts must be a whole number between 1 and 99")
+					return
+				}
+			case promocode.DiscountTypeFixed:
+				p.DiscountValue = int(math.Round(req.DiscountValue * 100))
+				if p.DiscountValue < 1 {
+					svr.JSON(w, http.StatusBadRequest, "fixed discounts must be a positive amount")
+					return
+				}
+			default:
+				svr.JSON(w, http.StatusBadRequest, "invalid discount type")
+				return
+			}
+			for _, t := range p.PlanTypes {
+				if t != job.JobPlanTypeBasic && t != job.JobPlanTypePro && t != job.JobPlanTypePlatinum {
+					svr.JSON(w, http.StatusBadRequest, fmt.Sprintf("invalid plan type %s", t))
+					return
+				}
+			}
+			if p.MaxRedemptions < 0 {
+				svr.JSON(w, http.StatusBadRequest, "max redemptions can't be negative")
+				return
+			}
+			if req.ExpiresAt != "" {
+				expiresAt, err := time.Parse("2006-01-02", req.ExpiresAt)
+				if err != nil {
+					svr.JSON(w, http.StatusBadRequest, "invalid expiry date")
+					return
+				}
+				p.ExpiresAt = pq.NullTime{Time: expiresAt.AddDate(0, 0, 1), Valid: true}
+			}
+			if _, err := promoRepo.ByCode(p.Code); err != promocode.ErrNotFound {
+				if err != nil {
+					svr.Log(err, fmt.Sprintf("unable to look up promo code %s", p.Code))
+					svr.JSON(w, http.StatusInternalServerError, nil)
+					return
+				}
+				svr.JSON(w, http.StatusConflict, "this code already exists")
+				return
+			}
+			p, err := promoRepo.Create(p)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to create promo code %s", p.Code))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"id": p.ID})
+		},
+	)
+}
+
+func DisablePromoCodeHandler(svr server.Server, promoRepo *promocode.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			id := mux.Vars(r)["id"]
+			err := promoRepo.Disable(id)
+			if err == sql.ErrNoRows {
+				svr.JSON(w, http.StatusNotFound, "active promo code not found")
+				return
+			}
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to disable promo code %s", id))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/revenue"
+	"github.com/golang-cafe/job-board/internal/server"
+)
+
+// RevenueAdminPageHandler reports the sales completed between ?from= and ?to= (YYYY-MM-DD, both included)
+// per ?interval=day or month, the sales themselves are exported with ?format=csv. It defaults to the last 12 months
+func RevenueAdminPageHandler(svr server.Server, revenueRepo *revenue.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now().UTC()
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+			from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -11, 0)
+			to := today.AddDate(0, 0, 1)
+			var err error
+			if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+				if from, err = time.Parse("2006-01-02", fromStr); err != nil {
+					svr.JSON(w, http.StatusBadRequest, "invalid from date")
+					return
+				}
+			}
+			if toStr := r.URL.Query().Get("to"); toStr != "" {
+				if to, err = time.Parse("2006-01-02", toStr); err != nil {
+					svr.JSON(w, http.StatusBadRequest, "invalid to date")
+					return
+				}
+				to = to.AddDate(0, 0, 1)
+			}
+			if !from.Before(to) {
+				svr.JSON(w, http.StatusBadRequest, "the from date must be before the to date")
+				return
+			}
+			interval := r.URL.Query().Get("interval")
+			if interval != revenue.IntervalDay {
+				interval = revenue.IntervalMonth
+			}
+			sales, err := revenueRepo.Sales(from, to)
+			if err != nil {
+				svr.Log(err, "unable to retrieve sales")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if r.URL.Query().Get("format") == "csv" {
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("revenue-%s-%s.csv", from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))))
+				if err := writeSalesCSV(w, sales); err != nil {
+					svr.Log(err, "unable to export sales")
+				}
+				return
+			}
+			conversion, err := revenueRepo.Conversion(from, to)
+			if err != nil {
+				svr.Log(err, "unable to retrieve draft conversion")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.Render(r, w, http.StatusOK, "revenue-admin.html", map[string]interface{}{
+				"Report":       revenue.NewReport(sales, from, to, interval, conversion),
+				"FromDate":     from.Format("2006-01-02"),
+				"ToDate":       to.AddDate(0, 0, -1).Format("2006-01-02"),
+				"MonthAndYear": now.Format("January 2006"),
+			})
+		},
+	)
+}
+
+func writeSalesCSV(w http.ResponseWriter, sales []revenue.Sale) error {
+	cw := csv.NewWriter(w)
+	header := []string{"completed_at", "product", "stripe_id", "email", "company", "description", "plan_type", "duration", "amount", "currency", "amount_usd", "reversed_at"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, s := range sales {
+		var reversedAt string
+		if s.ReversedAt.Valid {
+			reversedAt = s.ReversedAt.Time.UTC().Format(time.RFC3339)
+		}
+		record := []string{
+			s.CompletedAt.UTC().Format(time.RFC3339),
+			s.Kind,
+			s.SessionID,
+			csvSafe(s.Email),
+			csvSafe(s.Company),
+			csvSafe(s.Description),
+			s.PlanType,
+			strconv.Itoa(s.Duration),
+			decimalAmount(s.Amount, s.Currency),
+			s.Currency,
+			decimalAmount(s.BaseAmount, payment.BaseCurrency),
+			reversedAt,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// decimalAmount formats an amount in the currency's minor unit as a plain number, e.g. 177.00
+func decimalAmount(amount int, currency string) string {
+	minor := int(payment.MinorUnits(currency))
+	if minor == 1 {
+		return strconv.Itoa(amount)
+	}
+	return fmt.Sprintf("%d.%02d", amount/minor, amount%minor)
+}
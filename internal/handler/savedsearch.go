@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/savedsearch"
+	"github.com/golang-cafe/job-board/internal/server"
+	"github.com/gorilla/mux"
+)
+
+const savedSearchJobsPageSize = 20
+
+func SavedSearchListHandler(svr server.Server, savedSearchRepo *savedsearch.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			searches, err := savedSearchRepo.GetForUser(profile.UserID)
+			if err != nil {
+				svr.Log(err, "unable to retrieve saved searches for user")
+			}
+			err = svr.Render(r, w, http.StatusOK, "job-alerts.html", map[string]interface{}{
+				"SavedSearches":        searches,
+				"AvailableCurrencies":  svr.GetConfig().AvailableCurrencies,
+				"AvailableSalaryBands": svr.GetConfig().AvailableSalaryBands,
+			})
+			if err != nil {
+				svr.Log(err, "unable to render job alerts page")
+			}
+		},
+	)
+}
+
+func CreateSavedSearchHandler(svr server.Server, savedSearchRepo *savedsearch.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			searchRq := &savedsearch.CreateRq{}
+			if err := json.NewDecoder(r.Body).Decode(searchRq); err != nil {
+				svr.JSON(w, http.StatusBadRequest, "invalid request")
+				return
+			}
+			s := savedsearch.SavedSearch{
+				UserID:    profile.UserID,
+				Location:  apiQueryRe.ReplaceAllString(strings.TrimSpace(searchRq.Location), ""),
+				Tag:       apiSearchRe.ReplaceAllString(strings.TrimSpace(searchRq.Tag), ""),
+				Salary:    searchRq.Salary,
+				Currency:  strings.ToUpper(strings.TrimSpace(searchRq.Currency)),
+				Frequency: searchRq.Frequency,
+			}
+			if len(s.Location) > 255 || len(s.Tag) > 255 {
+				svr.JSON(w, http.StatusBadRequest, "search is too long")
+				return
+			}
+			if _, ok := savedsearch.ValidFrequencies[s.Frequency]; !ok {
+				svr.JSON(w, http.StatusBadRequest, "invalid alert frequency")
+				return
+			}
+			if s.Currency == "" {
+				s.Currency = "USD"
+			}
+			var validCurrency bool
+			for _, availableCurrency := range svr.GetConfig().AvailableCurrencies {
+				if availableCurrency == s.Currency {
+					validCurrency = true
+					break
+				}
+			}
+			if !validCurrency {
+				svr.JSON(w, http.StatusBadRequest, "invalid currency")
+				return
+			}
+			if s.Salary != 0 {
+				var validSalary bool
+				for _, band := range svr.GetConfig().AvailableSalaryBands {
+					if band == s.Salary {
+						validSalary = true
+						break
+					}
+				}
+				if !validSalary {
+					svr.JSON(w, http.StatusBadRequest, "invalid salary")
+					return
+				}
+			}
+			count, err := savedSearchRepo.CountForUser(profile.UserID)
+			if err != nil {
+				svr.Log(err, "unable to count saved searches for user")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if count >= savedsearch.MaxPerUser {
+				svr.JSON(w, http.StatusBadRequest, fmt.Sprintf("you can have up to %d job alerts, please remove one first", savedsearch.MaxPerUser))
+				return
+			}
+			s, err = savedSearchRepo.Create(s)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to create saved search for user %s", profile.UserID))
+				svr.JSON(w, http.StatusInternalServerError, "unable to create job alert, please try again later")
+				return
+			}
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"id": s.ID})
+		},
+	)
+}
+
+func DeleteSavedSearchHandler(svr server.Server, savedSearchRepo *savedsearch.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			id := mux.Vars(r)["id"]
+			err = savedSearchRepo.Delete(id, profile.UserID)
+			if errors.Is(err, sql.ErrNoRows) {
+				svr.JSON(w, http.StatusNotFound, nil)
+				return
+			}
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to delete saved search %s", id))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.JSON(w, http.StatusOK, nil)
+		},
+	)
+}
+
+func UnsubscribeSavedSearchHandler(svr server.Server, savedSearchRepo *savedsearch.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := savedSearchRepo.DeleteByToken(r.URL.Query().Get("token"))
+		if errors.Is(err, sql.ErrNoRows) {
+			svr.TEXT(w, http.StatusOK, "This job alert has already been removed.")
+			return
+		}
+		if err != nil {
+			svr.Log(err, "unable to remove saved search")
+			svr.TEXT(w, http.StatusInternalServerError, "")
+			return
+		}
+		svr.TEXT(w, http.StatusOK, "Your job alert has been successfully removed.")
+	}
+}
+
+// TriggerSavedSearchAlerts emails every saved search with the given frequency
+// the jobs approved since its last alert. Instant alerts are sent when jobs are approved,
+// the task catches up on the ones that failed
+func TriggerSavedSearchAlerts(svr server.Server, jobRepo *job.Repository, savedSearchRepo *savedsearch.Repository, frequency string) http.HandlerFunc {
+	return middleware.MachineAuthenticatedMiddleware(
+		svr.GetConfig().MachineToken,
+		func(w http.ResponseWriter, r *http.Request) {
+			if frequency == savedsearch.FrequencyInstant {
+				sendInstantSavedSearchAlerts(svr, jobRepo, savedSearchRepo)
+			} else {
+				go sendSavedSearchAlerts(svr, jobRepo, savedSearchRepo, frequency)
+			}
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+		},
+	)
+}
+
+// instantSavedSearchAlerts folds the instant alert runs requested while one is in progress into a
+// single run once it's done, so that concurrent runs don't email the same jobs twice
+var instantSavedSearchAlerts struct {
+	sync.Mutex
+	running bool
+	rerun   bool
+}
+
+// sendInstantSavedSearchAlerts emails the instant saved searches the jobs just approved in the background
+func sendInstantSavedSearchAlerts(svr server.Server, jobRepo *job.Repository, savedSearchRepo *savedsearch.Repository) {
+	instantSavedSearchAlerts.Lock()
+	defer instantSavedSearchAlerts.Unlock()
+	if instantSavedSearchAlerts.running {
+		instantSavedSearchAlerts.rerun = true
+		return
+	}
+	instantSavedSearchAlerts.running = true
+	go func() {
+		for {
+			sendSavedSearchAlerts(svr, jobRepo, savedSearchRepo, savedsearch.FrequencyInstant)
+			instantSavedSearchAlerts.Lock()
+			if !instantSavedSearchAlerts.rerun {
+				instantSavedSearchAlerts.running = false
+				instantSavedSearchAlerts.Unlock()
+				return
+			}
+			instantSavedSearchAlerts.rerun = false
+			instantSavedSearchAlerts.Unlock()
+		}
+	}()
+}
+
+// sendSavedSearchAlerts emails every saved search with the given frequency the jobs approved since
+// its last alert
+func sendSavedSearchAlerts(svr server.Server, jobRepo *job.Repository, savedSearchRepo *savedsearch.Repository, frequency string) {
+	searches, err := savedSearchRepo.GetByFrequency(frequency)
+	if err != nil {
+		svr.Log(err, fmt.Sprintf("unable to retrieve %s saved searches", frequency))
+		return
+	}
+	siteURL := svr.GetConfig().URLProtocol + svr.GetConfig().SiteHost
+	var sent int
+	for _, s := range searches {
+		jobPosts, err := savedSearchNewJobs(jobRepo, s)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to retrieve jobs for saved search %s", s.ID))
+			continue
+		}
+		if len(jobPosts) == 0 {
+			continue
+		}
+		var jobsText []string
+		for _, j := range jobPosts {
+			jobsText = append(jobsText, fmt.Sprintf("%s with %s - %s | %s\n%s/job/%s", j.JobTitle, j.Company, j.Location, j.SalaryRange, siteURL, j.Slug))
+		}
+		err = svr.GetEmail().SendHTMLEmail(
+			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+			email.Address{Email: s.Email},
+			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+			fmt.Sprintf("%d New %s %s", len(jobPosts), strings.Title(svr.GetConfig().SiteJobCategory), strings.Title(s.Description())),
+			fmt.Sprintf(
+				"Here are the newest jobs matching your %s job alert \"%s\" on %s\n\n%s\n\nManage your job alerts %s/profile/job-alerts\nUnsubscribe from this job alert %s/x/job-alerts/unsubscribe?token=%s",
+				s.Frequency,
+				s.Description(),
+				svr.GetConfig().SiteName,
+				strings.Join(jobsText, "\n\n"),
+				siteURL,
+				siteURL,
+				s.Token,
+			),
+		)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to send job alert email for saved search %s", s.ID))
+			continue
+		}
+		sent++
+		// jobs approved while the alert was being sent are picked up by the next one
+		var lastApprovedAt time.Time
+		for _, j := range jobPosts {
+			if j.ApprovedAt != nil && j.ApprovedAt.After(lastApprovedAt) {
+				lastApprovedAt = *j.ApprovedAt
+			}
+		}
+		if err := savedSearchRepo.UpdateLastSentAt(s.ID, lastApprovedAt); err != nil {
+			svr.Log(err, fmt.Sprintf("unable to update last sent at for saved search %s", s.ID))
+		}
+	}
+	log.Printf("sent %d/%d %s job alerts\n", sent, len(searches), frequency)
+}
+
+// savedSearchNewJobs returns all the jobs matching the saved search approved since its last alert
+func savedSearchNewJobs(jobRepo *job.Repository, s *savedsearch.SavedSearch) ([]*job.JobPost, error) {
+	jobPosts := []*job.JobPost{}
+	for page := 1; ; page++ {
+		batch, total, err := jobRepo.JobsByQuery(s.Location, s.Tag, page, s.Salary, s.Currency, savedSearchJobsPageSize, true, job.JobFilters{ApprovedAfter: s.LastSentAt})
+		if err != nil {
+			return jobPosts, err
+		}
+		jobPosts = append(jobPosts, batch...)
+		if len(batch) < savedSearchJobsPageSize || len(jobPosts) >= total {
+			return jobPosts, nil
+		}
+	}
+}
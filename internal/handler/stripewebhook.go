@@ -0,0 +1,422 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/database"
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/invoice"
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/jobcredit"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/recruiter"
+	"github.com/golang-cafe/job-board/internal/savedsearch"
+	"github.com/golang-cafe/job-board/internal/server"
+	"github.com/gorilla/mux"
+	stripe "github.com/stripe/stripe-go"
+)
+
+const stripeEventsPageSize = 100
+
+// errStripeEventIgnored is returned by event processors for events that don't concern the site,
+// e.g. event types it doesn't handle or invoices for other products
+var errStripeEventIgnored = errors.New("stripe event ignored")
+
+// StripePaymentConfirmationWebhookHandler records every stripe event delivered and processes it once.
+// Duplicate deliveries are acknowledged without being processed again, failed events are answered
+// with a 500 so that stripe retries them
+func StripePaymentConfirmationWebhookHandler(svr server.Server, jobRepo *job.Repository, recruiterRepo *recruiter.Repository, paymentRepo *payment.Repository, eventRepo *payment.EventRepository, invoiceRepo *invoice.Repository, jobCreditRepo *jobcredit.Repository, savedSearchRepo *savedsearch.Repository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		const MaxBodyBytes = int64(65536)
+		req.Body = http.MaxBytesReader(w, req.Body, MaxBodyBytes)
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			svr.Log(err, "error reading request body from stripe")
+			svr.JSON(w, http.StatusServiceUnavailable, nil)
+			return
+		}
+
+		stripeSig := req.Header.Get("Stripe-Signature")
+		event, err := payment.ConstructEvent(body, svr.GetConfig().StripeEndpointSecret, stripeSig)
+		if err != nil {
+			svr.Log(err, "error while verifying stripe event")
+			svr.JSON(w, http.StatusBadRequest, nil)
+			return
+		}
+		if err := eventRepo.Record(event, body); err != nil {
+			svr.Log(err, fmt.Sprintf("unable to record stripe event %s", event.ID))
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		claimed, err := eventRepo.Claim(event.ID, payment.WebhookEventStatusPending, payment.WebhookEventStatusFailed)
+		if err != nil {
+			svr.Log(err, fmt.Sprintf("unable to claim stripe event %s", event.ID))
+			svr.JSON(w, http.StatusInternalServerError, nil)
+			return
+		}
+		if !claimed {
+			svr.JSON(w, http.StatusOK, map[string]interface{}{"status": "duplicate"})
+			return
+		}
+		status, _ := runStripeEvent(svr, jobRepo, recruiterRepo, paymentRepo, eventRepo, invoiceRepo, jobCreditRepo, savedSearchRepo, event)
+		if status == payment.WebhookEventStatusFailed {
+			svr.JSON(w, http.StatusInternalServerError, map[string]interface{}{"status": status})
+			return
+		}
+		svr.JSON(w, http.StatusOK, map[string]interface{}{"status": status})
+	}
+}
+
+// runStripeEvent processes a claimed event and records the outcome
+func runStripeEvent(svr server.Server, jobRepo *job.Repository, recruiterRepo *recruiter.Repository, paymentRepo *payment.Repository, eventRepo *payment.EventRepository, invoiceRepo *invoice.Repository, jobCreditRepo *jobcredit.Repository, savedSearchRepo *savedsearch.Repository, event stripe.Event) (string, error) {
+	err := processStripeEvent(svr, jobRepo, recruiterRepo, paymentRepo, invoiceRepo, jobCreditRepo, savedSearchRepo, event)
+	status := payment.WebhookEventStatusProcessed
+	switch {
+	case err == errStripeEventIgnored:
+		status, err = payment.WebhookEventStatusIgnored, nil
+	case err != nil:
+		status = payment.WebhookEventStatusFailed
+		svr.Log(err, fmt.Sprintf("unable to process stripe event %s %s", event.Type, event.ID))
+	}
+	if completeErr := eventRepo.Complete(event.ID, status, err); completeErr != nil {
+		svr.Log(completeErr, fmt.Sprintf("unable to save outcome of stripe event %s", event.ID))
+	}
+	return status, err
+}
+
+func processStripeEvent(svr server.Server, jobRepo *job.Repository, recruiterRepo *recruiter.Repository, paymentRepo *payment.Repository, invoiceRepo *invoice.Repository, jobCreditRepo *jobcredit.Repository, savedSearchRepo *savedsearch.Repository, event stripe.Event) error {
+	switch event.Type {
+	case payment.EventCheckoutSessionCompleted:
+		var sess stripe.CheckoutSession
+		if err := payment.DecodeEventObject(event, &sess); err != nil {
+			return err
+		}
+		if sess.Mode == stripe.CheckoutSessionModeSubscription {
+			return processDevDirectorySubscriptionCheckout(svr, recruiterRepo, &sess)
+		}
+		billing, err := payment.DecodeCheckoutBilling(event)
+		if err != nil {
+			return err
+		}
+		return processCheckoutSessionCompleted(svr, jobRepo, recruiterRepo, invoiceRepo, jobCreditRepo, savedSearchRepo, &sess, billing)
+	case payment.EventInvoicePaid:
+		return processDevDirectoryInvoicePaid(svr, recruiterRepo, event)
+	case payment.EventInvoicePaymentFailed:
+		return processDevDirectoryInvoicePaymentFailed(svr, recruiterRepo, event)
+	case payment.EventSubscriptionDeleted:
+		return processDevDirectorySubscriptionDeleted(svr, recruiterRepo, event)
+	case payment.EventChargeRefunded:
+		var ch stripe.Charge
+		if err := payment.DecodeEventObject(event, &ch); err != nil {
+			return err
+		}
+		// a partial refund leaves the plan in place
+		if !ch.Refunded {
+			return errStripeEventIgnored
+		}
+		return processPaymentReversal(svr, jobRepo, recruiterRepo, paymentRepo, jobCreditRepo, &ch, database.PaymentReversalRefund)
+	case payment.EventChargeDisputeCreated:
+		var dispute stripe.Dispute
+		if err := payment.DecodeEventObject(event, &dispute); err != nil {
+			return err
+		}
+		if dispute.Charge == nil {
+			return fmt.Errorf("dispute %s has no charge", dispute.ID)
+		}
+		ch, err := paymentRepo.GetCharge(dispute.Charge.ID)
+		if err != nil {
+			return err
+		}
+		return processPaymentReversal(svr, jobRepo, recruiterRepo, paymentRepo, jobCreditRepo, ch, database.PaymentReversalDispute)
+	}
+	return errStripeEventIgnored
+}
+
+func processCheckoutSessionCompleted(svr server.Server, jobRepo *job.Repository, recruiterRepo *recruiter.Repository, invoiceRepo *invoice.Repository, jobCreditRepo *jobcredit.Repository, savedSearchRepo *savedsearch.Repository, sess *stripe.CheckoutSession, billing payment.CheckoutBilling) error {
+	var paymentIntentID string
+	if sess.PaymentIntent != nil {
+		paymentIntentID = sess.PaymentIntent.ID
+	}
+	isJobAd, err := database.IsJobAdPaymentEvent(svr.Conn, sess.ID)
+	if err != nil {
+		return fmt.Errorf("IsJobAdPaymentEvent: %v", err)
+	}
+	if isJobAd {
+		// no rows are affected when a previous attempt at this event failed after saving the payment
+		if _, err := database.SaveSuccessfulPaymentForJobAd(svr.Conn, sess.ID, paymentIntentID); err != nil {
+			return fmt.Errorf("unable to save successful payment for session id %s: %v", sess.ID, err)
+		}
+		jobPost, err := jobRepo.GetJobByStripeSessionID(sess.ID)
+		if err != nil {
+			return fmt.Errorf("unable to find job by stripe session id %s: %v", sess.ID, err)
+		}
+		purchaseEvent, err := database.GetJobAdPurchaseEventBySessionID(svr.Conn, sess.ID)
+		if err != nil {
+			return fmt.Errorf("unable to find purchase event by stripe session id %s: %v", sess.ID, err)
+		}
+		jobToken, err := jobRepo.TokenByJobID(jobPost.ID)
+		if err != nil {
+			return fmt.Errorf("unable to find token for job id %d session id %s: %v", jobPost.ID, sess.ID, err)
+		}
+		expiration, err := jobRepo.PlanTypeAndDurationToExpirations(
+			purchaseEvent.PlanType,
+			purchaseEvent.PlanDuration,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to get expiration for plan type %s and duration %d for session id %s: %v", purchaseEvent.PlanType, purchaseEvent.PlanDuration, sess.ID, err)
+		}
+		if err := jobRepo.UpdateJobPlan(jobPost.ID, purchaseEvent.PlanType, purchaseEvent.PlanDuration, expiration, job.PlanEventPurchased); err != nil {
+			return fmt.Errorf("unable to update job id %d to new ad type %s and duration %d for session id %s: %v", jobPost.ID, purchaseEvent.PlanType, purchaseEvent.PlanDuration, sess.ID, err)
+		}
+		inv, created, err := invoiceRepo.Create(jobAdInvoice(svr, jobPost, purchaseEvent, billing), invoiceIssuer(svr))
+		if err != nil {
+			return fmt.Errorf("unable to create invoice for job id %d session id %s: %v", jobPost.ID, sess.ID, err)
+		}
+		if err := sendJobAdLiveEmail(svr, purchaseEvent.Email, jobToken); err != nil {
+			svr.Log(err, "unable to send email while upgrading job ad")
+		}
+		if created {
+			if err := sendInvoiceEmail(svr, inv); err != nil {
+				svr.Log(err, fmt.Sprintf("unable to send invoice %s", inv.NumberString()))
+			}
+		}
+		if err := svr.CacheDelete(server.CacheKeyPinnedJobs); err != nil {
+			svr.Log(err, "unable to cleanup cache after approving job")
+		}
+		sendInstantSavedSearchAlerts(svr, jobRepo, savedSearchRepo)
+		return nil
+	}
+	isDevDirectory, err := database.IsDevDirectoryPaymentEvent(svr.Conn, sess.ID)
+	if err != nil {
+		return fmt.Errorf("IsDevDirectoryPaymentEvent: %v", err)
+	}
+	if isDevDirectory {
+		if _, err := database.SaveSuccessfulPaymentForDevDirectory(svr.Conn, sess.ID, paymentIntentID); err != nil {
+			return fmt.Errorf("unable to save successful payment for dev directory session id %s: %v", sess.ID, err)
+		}
+		purchaseEvent, err := database.GetDevDirectoryPurchaseEventBySessionID(svr.Conn, sess.ID)
+		if err != nil {
+			return fmt.Errorf("unable to find purchase event by stripe session id %s: %v", sess.ID, err)
+		}
+		if err := recruiterRepo.UpdateRecruiterPlanExpiration(purchaseEvent.Email, purchaseEvent.ExpiredAt); err != nil {
+			return fmt.Errorf("unable to update recruiter developer directory access for session id %s: %v", sess.ID, err)
+		}
+		err = svr.GetEmail().SendHTMLEmail(
+			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+			email.Address{Email: purchaseEvent.Email},
+			email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+			fmt.Sprintf("Your Developer Directory Access is active on %s", svr.GetConfig().SiteName),
+			fmt.Sprintf("Your payment has been received successfully and you can now access the Developer Directory. Please follow this link to login %s%s/auth?email=%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, purchaseEvent.Email))
+		if err != nil {
+			svr.Log(err, "unable to send email while activating recruiter developer directory plan")
+		}
+		return nil
+	}
+	pack, err := jobCreditRepo.PackBySessionID(sess.ID)
+	if err == nil {
+		return completeJobCreditPack(svr, jobCreditRepo, invoiceRepo, pack, paymentIntentID, billing)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("unable to find job credit pack by stripe session id %s: %v", sess.ID, err)
+	}
+	return fmt.Errorf("session id %s is not dev, job ad or job credit pack type", sess.ID)
+}
+
+// processPaymentReversal takes away what a refunded or disputed charge paid for. Job ads are downgraded
+// to an expired basic plan, developer directory access ends straight away and the credits left in job
+// credit packs are revoked
+func processPaymentReversal(svr server.Server, jobRepo *job.Repository, recruiterRepo *recruiter.Repository, paymentRepo *payment.Repository, jobCreditRepo *jobcredit.Repository, ch *stripe.Charge, reversal string) error {
+	if ch.PaymentIntent != "" {
+		sessionID, err := database.CheckoutSessionIDByPaymentIntentID(svr.Conn, ch.PaymentIntent)
+		if err != nil {
+			return err
+		}
+		if sessionID == "" {
+			if sessionID, err = paymentRepo.CheckoutSessionIDForPaymentIntent(ch.PaymentIntent); err != nil {
+				return err
+			}
+		}
+		if sessionID != "" {
+			isJobAd, err := database.IsJobAdPaymentEvent(svr.Conn, sessionID)
+			if err != nil {
+				return fmt.Errorf("IsJobAdPaymentEvent: %v", err)
+			}
+			if isJobAd {
+				return reverseJobAdPayment(svr, jobRepo, sessionID, ch, reversal)
+			}
+			isDevDirectory, err := database.IsDevDirectoryPaymentEvent(svr.Conn, sessionID)
+			if err != nil {
+				return fmt.Errorf("IsDevDirectoryPaymentEvent: %v", err)
+			}
+			if isDevDirectory {
+				purchaseEvent, err := database.GetDevDirectoryPurchaseEventBySessionID(svr.Conn, sessionID)
+				if err != nil {
+					return fmt.Errorf("unable to find purchase event by stripe session id %s: %v", sessionID, err)
+				}
+				if err := database.SaveReversedPaymentForDevDirectory(svr.Conn, sessionID, reversal); err != nil {
+					return err
+				}
+				return revokeDevDirectoryAccess(svr, recruiterRepo, purchaseEvent.Email, ch, reversal)
+			}
+			pack, err := jobCreditRepo.PackBySessionID(sessionID)
+			if err == nil {
+				return revokeJobCreditPack(svr, jobCreditRepo, pack, ch, reversal)
+			}
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("unable to find job credit pack by stripe session id %s: %v", sessionID, err)
+			}
+		}
+	}
+	// subscription renewals are charged outside of checkout
+	if ch.Customer != nil {
+		rec, err := recruiterRepo.RecruiterProfileByStripeCustomerID(ch.Customer.ID)
+		if err != nil {
+			return err
+		}
+		if rec.ID != "" {
+			return revokeDevDirectoryAccess(svr, recruiterRepo, rec.Email, ch, reversal)
+		}
+	}
+	return errStripeEventIgnored
+}
+
+func sendJobAdLiveEmail(svr server.Server, to, jobToken string) error {
+	return svr.GetEmail().SendHTMLEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		email.Address{Email: to},
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		fmt.Sprintf("Your Job Ad is live on %s", svr.GetConfig().SiteName),
+		fmt.Sprintf("Your Job Ad has been approved and it's now live. You can edit the Job Ad at any time and check page views and clickouts by following this link %s%s/edit/%s. You can also create an account by following this link: %s%s/auth?email=%s",
+			svr.GetConfig().URLProtocol,
+			svr.GetConfig().SiteHost,
+			jobToken,
+			svr.GetConfig().URLProtocol,
+			svr.GetConfig().SiteHost,
+			to,
+		),
+	)
+}
+
+func reverseJobAdPayment(svr server.Server, jobRepo *job.Repository, sessionID string, ch *stripe.Charge, reversal string) error {
+	jobPost, err := jobRepo.GetJobByStripeSessionID(sessionID)
+	if err != nil {
+		return fmt.Errorf("unable to find job by stripe session id %s: %v", sessionID, err)
+	}
+	expiration, err := jobRepo.PlanTypeAndDurationToExpirations(job.JobPlanTypeBasic, 0)
+	if err != nil {
+		return err
+	}
+	if err := jobRepo.UpdateJobPlan(jobPost.ID, job.JobPlanTypeBasic, 0, expiration, job.PlanEventPaymentReversed); err != nil {
+		return fmt.Errorf("unable to downgrade job id %d after %s of charge %s: %v", jobPost.ID, reversal, ch.ID, err)
+	}
+	if err := database.SaveReversedPaymentForJobAd(svr.Conn, sessionID, reversal); err != nil {
+		return err
+	}
+	if err := svr.CacheDelete(server.CacheKeyPinnedJobs); err != nil {
+		svr.Log(err, "unable to cleanup cache after downgrading job")
+	}
+	notifyAdminOfPaymentReversal(svr, ch, reversal, fmt.Sprintf("Job Ad %s with %s (id %d) has been downgraded to an expired basic plan", jobPost.JobTitle, jobPost.Company, jobPost.ID))
+	return nil
+}
+
+func revokeDevDirectoryAccess(svr server.Server, recruiterRepo *recruiter.Repository, recruiterEmail string, ch *stripe.Charge, reversal string) error {
+	if err := recruiterRepo.UpdateRecruiterPlanExpiration(recruiterEmail, time.Now().UTC()); err != nil {
+		return fmt.Errorf("unable to revoke developer directory access for %s after %s of charge %s: %v", recruiterEmail, reversal, ch.ID, err)
+	}
+	notifyAdminOfPaymentReversal(svr, ch, reversal, fmt.Sprintf("Developer Directory access for %s has been revoked", recruiterEmail))
+	return nil
+}
+
+func notifyAdminOfPaymentReversal(svr server.Server, ch *stripe.Charge, reversal, outcome string) {
+	err := svr.GetEmail().SendHTMLEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+		email.Address{Email: svr.GetEmail().DefaultAdminAddress()},
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().NoReplySenderAddress()},
+		fmt.Sprintf("Stripe %s for charge %s on %s", reversal, ch.ID, svr.GetConfig().SiteName),
+		fmt.Sprintf("Charge %s of %d %s was reversed by a %s. %s.", ch.ID, ch.Amount, ch.Currency, reversal, outcome),
+	)
+	if err != nil {
+		svr.Log(err, "unable to send payment reversal email to admin")
+	}
+}
+
+func StripeEventsAdminPageHandler(svr server.Server, eventRepo *payment.EventRepository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			status := r.URL.Query().Get("status")
+			switch status {
+			case payment.WebhookEventStatusPending, payment.WebhookEventStatusProcessing, payment.WebhookEventStatusProcessed, payment.WebhookEventStatusIgnored:
+			default:
+				status = payment.WebhookEventStatusFailed
+			}
+			stats, err := eventRepo.Stats()
+			if err != nil {
+				svr.Log(err, "unable to retrieve stripe event stats")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			events, err := eventRepo.ListByStatus(status, stripeEventsPageSize)
+			if err != nil {
+				svr.Log(err, "unable to retrieve stripe events")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.Render(r, w, http.StatusOK, "stripe-events-admin.html", map[string]interface{}{
+				"Stats":        stats,
+				"Events":       events,
+				"Status":       status,
+				"MonthAndYear": time.Now().UTC().Format("January 2006"),
+			})
+		},
+	)
+}
+
+// ReplayStripeEventHandler processes a stored failed or ignored event again
+func ReplayStripeEventHandler(svr server.Server, jobRepo *job.Repository, recruiterRepo *recruiter.Repository, paymentRepo *payment.Repository, eventRepo *payment.EventRepository, invoiceRepo *invoice.Repository, jobCreditRepo *jobcredit.Repository, savedSearchRepo *savedsearch.Repository) http.HandlerFunc {
+	return middleware.AdminAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			stored, err := eventRepo.Get(mux.Vars(r)["id"])
+			if err == sql.ErrNoRows {
+				svr.JSON(w, http.StatusNotFound, "stripe event not found")
+				return
+			}
+			if err != nil {
+				svr.Log(err, "unable to retrieve stripe event")
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			event, err := stored.Event()
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to decode stored stripe event %s", stored.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			claimed, err := eventRepo.Claim(stored.ID, payment.WebhookEventStatusFailed, payment.WebhookEventStatusIgnored)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to claim stripe event %s", stored.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if !claimed {
+				svr.JSON(w, http.StatusConflict, fmt.Sprintf("only failed and ignored events can be replayed, this event is %s", stored.Status))
+				return
+			}
+			status, err := runStripeEvent(svr, jobRepo, recruiterRepo, paymentRepo, eventRepo, invoiceRepo, jobCreditRepo, savedSearchRepo, event)
+			res := map[string]interface{}{"status": status}
+			if err != nil {
+				res["error"] = err.Error()
+			}
+			svr.JSON(w, http.StatusOK, res)
+		},
+	)
+}
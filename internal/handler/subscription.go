@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-cafe/job-board/internal/database"
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/recruiter"
+	"github.com/golang-cafe/job-board/internal/server"
+	stripe "github.com/stripe/stripe-go"
+)
+
+// BillingPortalHandler redirects the signed on recruiter to the stripe billing portal to manage
+// their developer directory subscription
+func BillingPortalHandler(svr server.Server, recRepo *recruiter.Repository, paymentRepo *payment.Repository) http.HandlerFunc {
+	return middleware.UserAuthenticatedMiddleware(
+		svr.SessionStore,
+		svr.GetJWTSigningKey(),
+		func(w http.ResponseWriter, r *http.Request) {
+			profile, err := middleware.GetUserFromJWT(r, svr.SessionStore, svr.GetJWTSigningKey())
+			if err != nil {
+				svr.Log(err, "unable to retrieve user from JWT")
+				svr.JSON(w, http.StatusForbidden, nil)
+				return
+			}
+			rec, err := recRepo.RecruiterProfileByEmail(profile.Email)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to retrieve recruiter profile for %s", profile.Email))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			if rec.StripeCustomerID == "" {
+				svr.JSON(w, http.StatusNotFound, "no subscription found for this account")
+				return
+			}
+			portalURL, err := paymentRepo.CreateBillingPortalSession(
+				rec.StripeCustomerID,
+				fmt.Sprintf("%s%s/profile/home#developer-subscription", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost),
+			)
+			if err != nil {
+				svr.Log(err, fmt.Sprintf("unable to create billing portal session for recruiter %s", rec.ID))
+				svr.JSON(w, http.StatusInternalServerError, nil)
+				return
+			}
+			svr.Redirect(w, r, http.StatusSeeOther, portalURL)
+		},
+	)
+}
+
+func processDevDirectorySubscriptionCheckout(svr server.Server, recRepo *recruiter.Repository, sess *stripe.CheckoutSession) error {
+	if sess.Customer == nil || sess.Subscription == nil {
+		return fmt.Errorf("subscription checkout session %s has no customer or subscription", sess.ID)
+	}
+	rec, err := recRepo.RecruiterProfileByID(sess.ClientReferenceID)
+	if err != nil {
+		return fmt.Errorf("unable to find recruiter %s for subscription checkout session %s: %v", sess.ClientReferenceID, sess.ID, err)
+	}
+	if err := recRepo.SaveRecruiterSubscription(rec.ID, sess.Customer.ID, sess.Subscription.ID); err != nil {
+		return fmt.Errorf("unable to save subscription %s for recruiter %s: %v", sess.Subscription.ID, rec.ID, err)
+	}
+	err = svr.GetEmail().SendHTMLEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		email.Address{Email: rec.Email},
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		fmt.Sprintf("Your Developer Directory Access is active on %s", svr.GetConfig().SiteName),
+		fmt.Sprintf("Your subscription has been set up successfully and you can now access the Developer Directory. It renews automatically, you can update your payment details or cancel at any time from your dashboard. Please follow this link to login %s%s/auth?email=%s", svr.GetConfig().URLProtocol, svr.GetConfig().SiteHost, rec.Email))
+	if err != nil {
+		svr.Log(err, "unable to send email while activating recruiter developer directory subscription")
+	}
+	return nil
+}
+
+// recruiterForInvoice finds the recruiter billed by the invoice. The invoice for the first period can
+// arrive before the checkout session is completed, when the customer isn't linked to the profile yet
+func recruiterForInvoice(recRepo *recruiter.Repository, inv stripe.Invoice) (recruiter.Recruiter, error) {
+	if inv.Customer != nil {
+		rec, err := recRepo.RecruiterProfileByStripeCustomerID(inv.Customer.ID)
+		if err != nil || rec.ID != "" {
+			return rec, err
+		}
+	}
+	rec, err := recRepo.RecruiterProfileByEmail(strings.ToLower(inv.CustomerEmail))
+	if err != nil {
+		return rec, err
+	}
+	if rec.ID == "" {
+		return rec, fmt.Errorf("no recruiter found for invoice %s customer email %s", inv.ID, inv.CustomerEmail)
+	}
+	return rec, nil
+}
+
+func processDevDirectoryInvoicePaid(svr server.Server, recRepo *recruiter.Repository, event stripe.Event) error {
+	var inv stripe.Invoice
+	if err := payment.DecodeEventObject(event, &inv); err != nil {
+		return err
+	}
+	interval, periodEnd, ok := payment.DevDirectoryInvoicePeriod(inv)
+	if !ok || inv.Customer == nil {
+		return errStripeEventIgnored
+	}
+	rec, err := recruiterForInvoice(recRepo, inv)
+	if err != nil {
+		return err
+	}
+	expiredAt := periodEnd.AddDate(0, 0, svr.GetConfig().DevDirectoryGraceDays)
+	if err := recRepo.RenewRecruiterSubscription(rec.ID, inv.Customer.ID, inv.Subscription, interval, periodEnd, expiredAt); err != nil {
+		return fmt.Errorf("unable to renew developer directory access for recruiter %s invoice %s: %v", rec.ID, inv.ID, err)
+	}
+	if inv.AmountPaid > 0 {
+		var paymentIntentID string
+		if inv.PaymentIntent != nil {
+			paymentIntentID = inv.PaymentIntent.ID
+		}
+		duration := int64(1)
+		if interval == string(stripe.PlanIntervalYear) {
+			duration = 12
+		}
+		err := database.SaveDevDirectorySubscriptionPayment(
+			svr.Conn,
+			inv.ID,
+			paymentIntentID,
+			inv.AmountPaid,
+			strings.ToUpper(string(inv.Currency)),
+			fmt.Sprintf("Developer Directory %sly subscription", interval),
+			rec.ID,
+			rec.Email,
+			duration,
+			expiredAt,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to save developer directory subscription payment for recruiter %s invoice %s: %v", rec.ID, inv.ID, err)
+		}
+	}
+	return nil
+}
+
+func processDevDirectoryInvoicePaymentFailed(svr server.Server, recRepo *recruiter.Repository, event stripe.Event) error {
+	var inv stripe.Invoice
+	if err := payment.DecodeEventObject(event, &inv); err != nil {
+		return err
+	}
+	if _, _, ok := payment.DevDirectoryInvoicePeriod(inv); !ok {
+		return errStripeEventIgnored
+	}
+	rec, err := recruiterForInvoice(recRepo, inv)
+	if err != nil {
+		return err
+	}
+	if err := recRepo.UpdateRecruiterSubscriptionStatus(rec.ID, recruiter.SubscriptionStatusPastDue); err != nil {
+		return fmt.Errorf("unable to mark subscription as past due for recruiter %s: %v", rec.ID, err)
+	}
+	err = svr.SendTemplatedEmail(
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		email.Address{Email: rec.Email},
+		email.Address{Name: svr.GetEmail().DefaultSenderName(), Email: svr.GetEmail().SupportSenderAddress()},
+		fmt.Sprintf("Your Developer Directory payment on %s failed", svr.GetConfig().SiteName),
+		"dev-directory-payment-failed-email",
+		map[string]interface{}{
+			"Recruiter":  rec,
+			"InvoiceURL": inv.HostedInvoiceURL,
+		},
+	)
+	if err != nil {
+		svr.Log(err, fmt.Sprintf("unable to send payment failed email to recruiter %s", rec.ID))
+	}
+	return nil
+}
+
+func processDevDirectorySubscriptionDeleted(svr server.Server, recRepo *recruiter.Repository, event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := payment.DecodeEventObject(event, &sub); err != nil {
+		return err
+	}
+	if !payment.IsDevDirectorySubscription(sub) || sub.Customer == nil {
+		return errStripeEventIgnored
+	}
+	rec, err := recRepo.RecruiterProfileByStripeCustomerID(sub.Customer.ID)
+	if err != nil {
+		return fmt.Errorf("unable to find recruiter for subscription %s: %v", sub.ID, err)
+	}
+	// a recruiter who subscribed again keeps the newer subscription
+	if rec.ID == "" || rec.StripeSubscriptionID != sub.ID {
+		return errStripeEventIgnored
+	}
+	if err := recRepo.CancelRecruiterSubscription(rec.ID); err != nil {
+		return fmt.Errorf("unable to cancel subscription %s for recruiter %s: %v", sub.ID, rec.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package invoice
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/payment"
+)
+
+// Invoice is issued for every paid job ad or job credit pack checkout, billed to the company details
+// entered at checkout
+type Invoice struct {
+	ID              string
+	Number          int
+	JobID           int // 0 for invoices not for a single job, e.g. job credit packs
+	StripeSessionID string
+	Email           string
+	BillingName     string
+	BillingAddress  string // one address line per line
+	BillingVATID    string
+	Description     string
+	Amount          int // amount in the minor unit of Currency
+	Currency        string
+	CreatedAt       time.Time
+	PDF             []byte // only loaded when a single invoice is retrieved
+}
+
+// Issuer is the seller the invoices are issued by
+type Issuer struct {
+	Lines []string // name followed by the address lines
+	VATID string
+}
+
+// NumberString is the invoice number as printed on the invoice, e.g. INV-000042
+func (i Invoice) NumberString() string {
+	return fmt.Sprintf("INV-%06d", i.Number)
+}
+
+func (i Invoice) FileName() string {
+	return i.NumberString() + ".pdf"
+}
+
+// AmountString formats the amount with its currency code, e.g. USD 177.00
+func (i Invoice) AmountString() string {
+	return payment.FormatAmount(int64(i.Amount), i.Currency)
+}
+
+func (i Invoice) BillingAddressLines() []string {
+	lines := make([]string, 0)
+	for _, l := range strings.Split(i.BillingAddress, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,196 @@
+package invoice
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	pdfPageWidth  = 595 // A4 in points
+	pdfPageHeight = 842
+	pdfMargin     = 50
+
+	fontRegular = "F1"
+	fontBold    = "F2"
+)
+
+// winAnsi maps the characters outside latin-1 that the standard fonts' WinAnsiEncoding can show
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfString encodes s as a PDF literal string in WinAnsiEncoding, characters it can't show are
+// replaced with a question mark
+func pdfString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for _, r := range s {
+		var c byte
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			sb.WriteByte('\\')
+			c = byte(r)
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			c = byte(r)
+		default:
+			var ok bool
+			if c, ok = winAnsi[r]; !ok {
+				c = '?'
+			}
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// wrap breaks s into lines of at most width characters on spaces
+func wrap(s string, width int) []string {
+	lines := make([]string, 0)
+	var line string
+	for _, word := range strings.Fields(s) {
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) > width:
+			lines = append(lines, line)
+			line = word
+		default:
+			line += " " + word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// pdfPage collects the drawing operators of a single page document
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (p *pdfPage) text(x, y float64, font string, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.1f %.1f Td %s Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "%.1f %.1f m %.1f %.1f l S\n", x1, y1, x2, y2)
+}
+
+// document writes the page as a PDF with the standard Helvetica fonts, which viewers provide
+// so no font is embedded
+func (p *pdfPage) document(title string, created time.Time) ([]byte, error) {
+	var stream bytes.Buffer
+	zw := zlib.NewWriter(&stream)
+	if _, err := zw.Write(p.content.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /%s 4 0 R /%s 5 0 R >> >> /Contents 6 0 R >>", pdfPageWidth, pdfPageHeight, fontRegular, fontBold),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.Bytes()),
+		fmt.Sprintf("<< /Title %s /CreationDate (D:%s) >>", pdfString(title), created.UTC().Format("20060102150405Z")),
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, len(objects), xref)
+	return b.Bytes(), nil
+}
+
+// PDF renders the invoice as a one page A4 PDF
+func PDF(inv Invoice, issuer Issuer) ([]byte, error) {
+	const (
+		leading    = 15
+		metaX      = 350
+		amountX    = 450
+		descWidth  = 65 // characters that fit in the description column
+		blockWidth = 35 // characters that fit in the from and bill to blocks
+	)
+	p := &pdfPage{}
+	date := inv.CreatedAt.Format("2 January 2006")
+
+	y := float64(pdfPageHeight - pdfMargin - 20)
+	p.text(pdfMargin, y, fontBold, 22, "Invoice")
+	p.text(metaX, y+8, fontBold, 10, "Invoice number")
+	p.text(metaX+90, y+8, fontRegular, 10, inv.NumberString())
+	p.text(metaX, y+8-leading, fontBold, 10, "Date of issue")
+	p.text(metaX+90, y+8-leading, fontRegular, 10, date)
+	p.text(metaX, y+8-2*leading, fontBold, 10, "Status")
+	p.text(metaX+90, y+8-2*leading, fontRegular, 10, "Paid")
+
+	y -= 50
+	p.text(pdfMargin, y, fontBold, 10, "From")
+	p.text(metaX, y, fontBold, 10, "Bill to")
+	from := make([]string, 0)
+	for _, l := range issuer.Lines {
+		from = append(from, wrap(l, blockWidth)...)
+	}
+	if issuer.VATID != "" {
+		from = append(from, "VAT ID: "+issuer.VATID)
+	}
+	billTo := wrap(inv.BillingName, blockWidth)
+	for _, l := range inv.BillingAddressLines() {
+		billTo = append(billTo, wrap(l, blockWidth)...)
+	}
+	if inv.BillingVATID != "" {
+		billTo = append(billTo, "VAT ID: "+inv.BillingVATID)
+	}
+	billTo = append(billTo, inv.Email)
+	rows := len(from)
+	if len(billTo) > rows {
+		rows = len(billTo)
+	}
+	for i := 0; i < rows; i++ {
+		y -= leading
+		if i < len(from) {
+			p.text(pdfMargin, y, fontRegular, 10, from[i])
+		}
+		if i < len(billTo) {
+			p.text(metaX, y, fontRegular, 10, billTo[i])
+		}
+	}
+
+	y -= 50
+	p.text(pdfMargin, y, fontBold, 10, "Description")
+	p.text(amountX, y, fontBold, 10, "Amount")
+	y -= 8
+	p.line(pdfMargin, y, pdfPageWidth-pdfMargin, y)
+	for i, l := range wrap(inv.Description, descWidth) {
+		y -= leading
+		p.text(pdfMargin, y, fontRegular, 10, l)
+		if i == 0 {
+			p.text(amountX, y, fontRegular, 10, inv.AmountString())
+		}
+	}
+	y -= 8
+	p.line(pdfMargin, y, pdfPageWidth-pdfMargin, y)
+	y -= leading + 2
+	p.text(amountX-60, y, fontBold, 10, "Total")
+	p.text(amountX, y, fontBold, 10, inv.AmountString())
+	y -= leading
+	p.text(amountX-60, y, fontRegular, 10, "Paid")
+	p.text(amountX, y, fontRegular, 10, inv.AmountString())
+
+	p.text(pdfMargin, pdfMargin, fontRegular, 9, fmt.Sprintf("Paid by card on %s. Thank you for your business.", date))
+	return p.document(fmt.Sprintf("Invoice %s", inv.NumberString()), inv.CreatedAt)
+}
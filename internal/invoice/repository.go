@@ -0,0 +1,139 @@
+package invoice
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const invoiceColumns = `i.id, i.number, COALESCE(i.job_id, 0), i.stripe_session_id, i.email, i.billing_name, i.billing_address, i.billing_vat_id, i.description, i.amount, i.currency, i.created_at`
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db}
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanInvoice(row scanner, extra ...interface{}) (Invoice, error) {
+	var inv Invoice
+	dest := []interface{}{
+		&inv.ID,
+		&inv.Number,
+		&inv.JobID,
+		&inv.StripeSessionID,
+		&inv.Email,
+		&inv.BillingName,
+		&inv.BillingAddress,
+		&inv.BillingVATID,
+		&inv.Description,
+		&inv.Amount,
+		&inv.Currency,
+		&inv.CreatedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return inv, err
+}
+
+// Create issues the invoice with the next invoice number and stores it along with its PDF. A checkout
+// session is invoiced once, created is false and the existing invoice is returned when it already was
+func (r *Repository) Create(inv Invoice, issuer Issuer) (Invoice, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return inv, false, err
+	}
+	defer tx.Rollback()
+	// invoice numbers are sequential without gaps, concurrent invoices wait for each other
+	if _, err := tx.Exec(`LOCK TABLE invoice IN EXCLUSIVE MODE`); err != nil {
+		return inv, false, err
+	}
+	var pdf []byte
+	existing, err := scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+`, i.pdf FROM invoice i WHERE i.stripe_session_id = $1`, inv.StripeSessionID), &pdf)
+	if err == nil {
+		existing.PDF = pdf
+		return existing, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return inv, false, err
+	}
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(number), 0) + 1 FROM invoice`).Scan(&inv.Number); err != nil {
+		return inv, false, err
+	}
+	k, err := ksuid.NewRandom()
+	if err != nil {
+		return inv, false, err
+	}
+	inv.ID = k.String()
+	inv.CreatedAt = time.Now().UTC()
+	inv.PDF, err = PDF(inv, issuer)
+	if err != nil {
+		return inv, false, err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO invoice (id, number, job_id, stripe_session_id, email, billing_name, billing_address, billing_vat_id, description, amount, currency, pdf, created_at) VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		inv.ID,
+		inv.Number,
+		inv.JobID,
+		inv.StripeSessionID,
+		inv.Email,
+		inv.BillingName,
+		inv.BillingAddress,
+		inv.BillingVATID,
+		inv.Description,
+		inv.Amount,
+		inv.Currency,
+		inv.PDF,
+		inv.CreatedAt,
+	)
+	if err != nil {
+		return inv, false, err
+	}
+	return inv, true, tx.Commit()
+}
+
+// InvoicesForEmail lists the invoices billed to the email or for jobs posted with it, newest first
+func (r *Repository) InvoicesForEmail(email string) ([]Invoice, error) {
+	rows, err := r.db.Query(
+		`SELECT `+invoiceColumns+` FROM invoice i LEFT JOIN job j ON j.id = i.job_id WHERE lower(i.email) = lower($1) OR lower(j.company_email) = lower($1) ORDER BY i.number DESC`,
+		email,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invoices := make([]Invoice, 0)
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
+
+// InvoiceForEmail returns the invoice with its PDF if it's billed to the email or for a job posted
+// with it, sql.ErrNoRows otherwise
+func (r *Repository) InvoiceForEmail(id, email string) (Invoice, error) {
+	var pdf []byte
+	inv, err := scanInvoice(r.db.QueryRow(
+		`SELECT `+invoiceColumns+`, i.pdf FROM invoice i LEFT JOIN job j ON j.id = i.job_id WHERE i.id = $1 AND (lower(i.email) = lower($2) OR lower(j.company_email) = lower($2))`,
+		id,
+		email,
+	), &pdf)
+	inv.PDF = pdf
+	return inv, err
+}
+
+func (r *Repository) InvoiceByID(id string) (Invoice, error) {
+	var pdf []byte
+	inv, err := scanInvoice(r.db.QueryRow(`SELECT `+invoiceColumns+`, i.pdf FROM invoice i WHERE i.id = $1`, id), &pdf)
+	inv.PDF = pdf
+	return inv, err
+}
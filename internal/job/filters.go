@@ -0,0 +1,89 @@
+package job
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ValidSalaryPeriods = map[string]struct{}{
+	"year":  {},
+	"month": {},
+	"hour":  {},
+}
+
+var ValidPostedWithinDays = map[int]struct{}{
+	1:  {},
+	3:  {},
+	7:  {},
+	14: {},
+	30: {},
+}
+
+type JobFilters struct {
+	VisaSponsorship  bool
+	SalaryPeriod     string
+	RemoteOnly       bool
+	PostedWithinDays int
+	Company          string
+
+	// ApprovedAfter is not exposed on the query string, it's used by job alerts to only match new jobs
+	ApprovedAfter time.Time
+}
+
+func ParseJobFiltersFromQuery(query url.Values) JobFilters {
+	var filters JobFilters
+	filters.VisaSponsorship = isTruthy(query.Get("visa"))
+	filters.RemoteOnly = isTruthy(query.Get("remote"))
+	if period := strings.ToLower(query.Get("period")); period != "" {
+		if _, ok := ValidSalaryPeriods[period]; ok {
+			filters.SalaryPeriod = period
+		}
+	}
+	// If we can't convert the string to an int we're happy leaving the zero value
+	days, _ := strconv.Atoi(query.Get("days"))
+	if _, ok := ValidPostedWithinDays[days]; ok {
+		filters.PostedWithinDays = days
+	}
+	filters.Company = strings.TrimSpace(query.Get("company"))
+	if len(filters.Company) > 100 {
+		filters.Company = filters.Company[:100]
+	}
+
+	return filters
+}
+
+func (f JobFilters) IsEmpty() bool {
+	return !f.VisaSponsorship && !f.RemoteOnly && f.SalaryPeriod == "" && f.PostedWithinDays == 0 && f.Company == "" && f.ApprovedAfter.IsZero()
+}
+
+// Query returns the filters encoded as query string values, so they can be
+// carried over to pagination links and search redirects
+func (f JobFilters) Query() url.Values {
+	query := url.Values{}
+	if f.VisaSponsorship {
+		query.Set("visa", "1")
+	}
+	if f.RemoteOnly {
+		query.Set("remote", "1")
+	}
+	if f.SalaryPeriod != "" {
+		query.Set("period", f.SalaryPeriod)
+	}
+	if f.PostedWithinDays > 0 {
+		query.Set("days", strconv.Itoa(f.PostedWithinDays))
+	}
+	if f.Company != "" {
+		query.Set("company", f.Company)
+	}
+	return query
+}
+
+func isTruthy(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
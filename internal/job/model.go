@@ -72,6 +72,10 @@ type JobRq struct {
 	CompanyIconID     string `json:"company_icon_id,omitempty"`
 	SalaryCurrencyISO string `json:"salary_currency_iso"`
 	VisaSponsorship   bool   `json:"visa_sponsorship,omitempty"`
+	PromoCode         string `json:"promo_code,omitempty"`
+	UseCredits        bool   `json:"use_credits,omitempty"` // pay with the poster's job credits instead of checking out
+
+	ScreeningQuestions ScreeningQuestions `json:"screening_questions,omitempty"`
 }
 
 const (
@@ -80,6 +84,30 @@ const (
 	JobPlanTypePlatinum = "platinum"
 )
 
+// events recorded in the job plan history
+const (
+	PlanEventPurchased       = "purchased"
+	PlanEventPaidWithCredits = "paid_with_credits"
+	PlanEventPaymentReversed = "payment_reversed"
+	PlanEventRenewalOffered  = "renewal_offered"
+	PlanEventExpired         = "expired"
+)
+
+// PlanRenewal is a paid job plan expiring soon or recently expired, for the poster to be offered a renewal
+type PlanRenewal struct {
+	JobID         int
+	JobTitle      string
+	Company       string
+	CompanyEmail  string
+	Slug          string
+	EditToken     string
+	PlanType      string
+	PlanDuration  int
+	PlanExpiredAt time.Time
+	JobExpired    bool        // the job was marked as expired, it is downgraded but not offered a renewal
+	OfferedAt     pq.NullTime // set once a renewal was offered before this plan expired
+}
+
 type JobRqUpsell struct {
 	Token           string `json:"token"`
 	Email           string `json:"email"`
@@ -87,6 +115,8 @@ type JobRqUpsell struct {
 	PlanType        string `json:"plan_type"`
 	PlanDuration    int
 	PlanDurationStr string `json:"plan_duration"`
+	CurrencyCode    string `json:"currency_code,omitempty"`
+	PromoCode       string `json:"promo_code,omitempty"`
 }
 
 type JobRqUpdate struct {
@@ -105,6 +135,8 @@ type JobRqUpdate struct {
 	Token            string `json:"token"`
 	CompanyIconID    string `json:"company_icon_id,omitempty"`
 	SalaryPeriod     string `json:"salary_period"`
+
+	ScreeningQuestions ScreeningQuestions `json:"screening_questions,omitempty"`
 }
 
 type JobPost struct {
@@ -142,9 +174,12 @@ type JobPost struct {
 	FrontPageEligibilityExpiredAt   time.Time
 	CompanyPageEligibilityExpiredAt time.Time
 	PlanExpiredAt                   time.Time
+	SearchSnippet                   string
 	JobDescriptionHTML              interface{}
 	InterviewProcessHTML            interface{}
 	PerksHTML                       interface{}
+	SearchSnippetHTML               interface{}
+	ScreeningQuestions              ScreeningQuestions
 }
 
 type JobPostForEdit struct {
@@ -165,6 +200,7 @@ type JobPostForEdit struct {
 	FrontPageEligibilityExpiredAt                                             time.Time
 	CompanyPageEligibilityExpiredAt                                           time.Time
 	PlanExpiredAt                                                             time.Time
+	ScreeningQuestions                                                        ScreeningQuestions
 }
 
 type JobStat struct {
@@ -178,11 +214,148 @@ type JobApplyURL struct {
 	URL string
 }
 
+const (
+	ApplicantStageNew       = "new"
+	ApplicantStageScreening = "screening"
+	ApplicantStageInterview = "interview"
+	ApplicantStageOffer     = "offer"
+	ApplicantStageRejected  = "rejected"
+	ApplicantStageHired     = "hired"
+
+	ApplicantMaxRating = 5
+)
+
+// ApplicantStages lists the applicant tracking stages in pipeline order
+var ApplicantStages = []string{
+	ApplicantStageNew,
+	ApplicantStageScreening,
+	ApplicantStageInterview,
+	ApplicantStageOffer,
+	ApplicantStageRejected,
+	ApplicantStageHired,
+}
+
+func IsValidApplicantStage(stage string) bool {
+	for _, s := range ApplicantStages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
 type Applicant struct {
-	Token       string
-	Cv          []byte
-	Email       string
-	CreatedAt   time.Time
-	ConfirmedAt pq.NullTime
-	CvSize      int
+	Token          string
+	Cv             []byte
+	CvContentType  string
+	CvSnippet      string
+	CvSnippetHTML  interface{}
+	CvPurgedAt     pq.NullTime
+	Email          string
+	CreatedAt      time.Time
+	ConfirmedAt    pq.NullTime
+	CvSize         int
+	Stage          string
+	Rating         int
+	StageUpdatedAt pq.NullTime
+	Notes          []ApplicantNote
+	Answers        ScreeningAnswers
+}
+
+// CvRetention describes the applicant CVs still held for a job and when they are due to be deleted
+type CvRetention struct {
+	JobID        int
+	JobTitle     string
+	Company      string
+	CompanyEmail string
+	Slug         string
+	EditToken    string
+	Applicants   int
+	PurgeAt      time.Time
+	NotifiedAt   pq.NullTime
+}
+
+// NoticeDue reports whether the employer should be told the CVs are about to be deleted, either
+// because they haven't been told yet or the deletion date moved since
+func (c CvRetention) NoticeDue(now time.Time, notice time.Duration) bool {
+	noticeAt := c.PurgeAt.Add(-notice)
+	return !now.Before(noticeAt) && (!c.NotifiedAt.Valid || c.NotifiedAt.Time.Before(noticeAt))
+}
+
+// PurgeDue reports whether the CVs can be deleted, the employer must have been notified at least
+// the notice period beforehand
+func (c CvRetention) PurgeDue(now time.Time, notice time.Duration) bool {
+	if !c.NotifiedAt.Valid || c.NotifiedAt.Time.Before(c.PurgeAt.Add(-notice)) {
+		return false
+	}
+	return !now.Before(c.PurgeAt) && !now.Before(c.NotifiedAt.Time.Add(notice))
+}
+
+type ApplicantNote struct {
+	ID         string
+	ApplyToken string
+	Text       string
+	CreatedAt  time.Time
+}
+
+// ApplicantStageRq moves one or more applicants of the same job to a new stage, Token is the
+// job edit token and can be omitted when the recruiter is signed in
+type ApplicantStageRq struct {
+	Token       string   `json:"token"`
+	ApplyTokens []string `json:"apply_tokens"`
+	Stage       string   `json:"stage"`
+	NotifyEmail bool     `json:"notify"`
+}
+
+type ApplicantNoteRq struct {
+	Token      string `json:"token"`
+	ApplyToken string `json:"apply_token"`
+	Text       string `json:"text"`
+}
+
+type ApplicantRatingRq struct {
+	Token      string `json:"token"`
+	ApplyToken string `json:"apply_token"`
+	Rating     int    `json:"rating"`
+}
+
+type APIJobSalary struct {
+	Min      int64  `json:"min"`
+	Max      int64  `json:"max"`
+	Currency string `json:"currency"`
+	Period   string `json:"period"`
+	Range    string `json:"range"`
+}
+
+type APIJob struct {
+	ID               string       `json:"id"`
+	Slug             string       `json:"slug"`
+	Title            string       `json:"title"`
+	Company          string       `json:"company"`
+	CompanyURL       string       `json:"company_url"`
+	CompanyIconURL   string       `json:"company_icon_url,omitempty"`
+	Location         string       `json:"location"`
+	Salary           APIJobSalary `json:"salary"`
+	Description      string       `json:"description"`
+	Perks            string       `json:"perks"`
+	InterviewProcess string       `json:"interview_process"`
+	URL              string       `json:"url"`
+	ApplyURL         string       `json:"apply_url"`
+	IsQuickApply     bool         `json:"is_quick_apply"`
+	Expired          bool         `json:"expired"`
+	CreatedAt        time.Time    `json:"created_at"`
+	Snippet          string       `json:"snippet,omitempty"`
+}
+
+type APIJobList struct {
+	Data       []APIJob `json:"data"`
+	Total      int      `json:"total"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+type APIJobDraft struct {
+	ID         string `json:"id"`
+	Token      string `json:"token"`
+	EditURL    string `json:"edit_url"`
+	PaymentURL string `json:"payment_url,omitempty"`
 }
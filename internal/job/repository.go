@@ -5,11 +5,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"html"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gosimple/slug"
+	"github.com/lib/pq"
 	"github.com/segmentio/ksuid"
 )
 
@@ -37,11 +39,11 @@ func (r *Repository) JobsCountByEmail(email string) (int, error) {
 }
 
 func (r *Repository) GetJobByApplyToken(token string) (JobPost, Applicant, error) {
-	res := r.db.QueryRow(`SELECT t.cv, t.email, j.id, j.job_title, j.company, company_url, salary_range, location, how_to_apply, slug, j.external_id
+	res := r.db.QueryRow(`SELECT t.cv, t.cv_content_type, t.email, t.screening_answers, j.id, j.job_title, j.company, company_url, salary_range, location, how_to_apply, slug, j.external_id
 	FROM job j JOIN apply_token t ON t.job_id = j.id AND t.token = $1 WHERE j.approved_at IS NOT NULL AND t.created_at < NOW() + INTERVAL '3 days' AND t.confirmed_at IS NULL`, token)
 	job := JobPost{}
 	applicant := Applicant{}
-	err := res.Scan(&applicant.Cv, &applicant.Email, &job.ID, &job.JobTitle, &job.Company, &job.CompanyURL, &job.SalaryRange, &job.Location, &job.HowToApply, &job.Slug, &job.ExternalID)
+	err := res.Scan(&applicant.Cv, &applicant.CvContentType, &applicant.Email, &applicant.Answers, &job.ID, &job.JobTitle, &job.Company, &job.CompanyURL, &job.SalaryRange, &job.Location, &job.HowToApply, &job.Slug, &job.ExternalID)
 	if err != nil {
 		return JobPost{}, applicant, err
 	}
@@ -50,34 +52,69 @@ func (r *Repository) GetJobByApplyToken(token string) (JobPost, Applicant, error
 }
 
 func (r *Repository) GetApplicantsForJob(jobID int) ([]*Applicant, error) {
+	return r.SearchApplicantsForJob(jobID, "")
+}
+
+// SearchApplicantsForJob returns the applicants whose CV text matches the given keywords (web search syntax),
+// best matches first, with a highlighted snippet of the matching CV text. An empty query returns all applicants
+func (r *Repository) SearchApplicantsForJob(jobID int, query string) ([]*Applicant, error) {
 	applicants := []*Applicant{}
+	query = strings.Join(strings.Fields(query), " ")
+	stmt := `SELECT t.token, t.cv, t.cv_content_type, t.cv_purged_at, t.email, t.created_at, t.confirmed_at, t.stage, t.rating, t.stage_updated_at, t.screening_answers`
+	args := []interface{}{jobID}
+	if query != "" {
+		stmt += fmt.Sprintf(`, ts_headline(t.cv_text, websearch_to_tsquery($2), 'MaxFragments=2, MinWords=5, MaxWords=20, StartSel="%s", StopSel="%s"') AS snippet
+		FROM apply_token t WHERE t.job_id = $1 AND t.cv_search_document @@ websearch_to_tsquery($2)
+		ORDER BY ts_rank(t.cv_search_document, websearch_to_tsquery($2)) DESC, t.confirmed_at ASC, t.created_at ASC`, snippetStartSel, snippetStopSel)
+		args = append(args, query)
+	} else {
+		stmt += `, '' AS snippet FROM apply_token t WHERE t.job_id = $1 ORDER BY t.confirmed_at ASC, t.created_at ASC`
+	}
 	var rows *sql.Rows
-	rows, err := r.db.Query(`SELECT t.token, t.cv, t.email, t.created_at, t.confirmed_at FROM apply_token t WHERE t.job_id = $1 ORDER BY t.confirmed_at ASC, t.created_at ASC`, jobID)
+	rows, err := r.db.Query(stmt, args...)
 	if err != nil {
 		return applicants, err
 	}
 
 	defer rows.Close()
+	byToken := make(map[string]*Applicant)
 	for rows.Next() {
 		applicant := &Applicant{}
-		err := rows.Scan(&applicant.Token, &applicant.Cv, &applicant.Email, &applicant.CreatedAt, &applicant.ConfirmedAt)
+		var snippet string
+		err := rows.Scan(&applicant.Token, &applicant.Cv, &applicant.CvContentType, &applicant.CvPurgedAt, &applicant.Email, &applicant.CreatedAt, &applicant.ConfirmedAt, &applicant.Stage, &applicant.Rating, &applicant.StageUpdatedAt, &applicant.Answers, &snippet)
 		if err != nil {
 			return applicants, err
 		}
 		applicant.CvSize = binary.Size(applicant.Cv)
+		applicant.CvSnippet = highlightSnippet(snippet)
 		applicants = append(applicants, applicant)
+		byToken[applicant.Token] = applicant
 	}
 	err = rows.Err()
 	if err != nil {
 		return applicants, err
 	}
-	return applicants, nil
+	notes, err := r.db.Query(`SELECT n.id, n.apply_token, n.text, n.created_at FROM applicant_note n JOIN apply_token t ON t.token = n.apply_token WHERE t.job_id = $1 ORDER BY n.created_at ASC`, jobID)
+	if err != nil {
+		return applicants, err
+	}
+	defer notes.Close()
+	for notes.Next() {
+		var note ApplicantNote
+		if err := notes.Scan(&note.ID, &note.ApplyToken, &note.Text, &note.CreatedAt); err != nil {
+			return applicants, err
+		}
+		if applicant, ok := byToken[note.ApplyToken]; ok {
+			applicant.Notes = append(applicant.Notes, note)
+		}
+	}
+	return applicants, notes.Err()
 }
 
 func (r *Repository) GetApplicantByApplyToken(applyToken string) (Applicant, error) {
-	res := r.db.QueryRow(`SELECT t.token, t.cv, t.email, t.created_at, t.confirmed_at FROM apply_token t WHERE t.token = $1`, applyToken)
+	res := r.db.QueryRow(`SELECT t.token, t.cv, t.cv_content_type, t.cv_purged_at, t.email, t.created_at, t.confirmed_at, t.stage, t.rating, t.stage_updated_at, t.screening_answers FROM apply_token t WHERE t.token = $1`, applyToken)
 	applicant := Applicant{}
-	err := res.Scan(&applicant.Token, &applicant.Cv, &applicant.Email, &applicant.CreatedAt, &applicant.ConfirmedAt)
+	err := res.Scan(&applicant.Token, &applicant.Cv, &applicant.CvContentType, &applicant.CvPurgedAt, &applicant.Email, &applicant.CreatedAt, &applicant.ConfirmedAt, &applicant.Stage, &applicant.Rating, &applicant.StageUpdatedAt, &applicant.Answers)
 	if err != nil {
 		return applicant, err
 	}
@@ -115,8 +152,8 @@ func (r *Repository) SaveDraft(job *JobRq) (int, error) {
 		return 0, err
 	}
 	sqlStatement := `
-			INSERT INTO job (job_title, company, company_url, salary_range, salary_min, salary_max, salary_currency, location, description, perks, interview_process, how_to_apply, created_at, url_id, slug, company_email, company_icon_image_id, external_id, salary_period, salary_currency_iso, visa_sponsorship, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, 'year', $19, $20, $21, $22, $23, $24, $25, $26, $27, $28) RETURNING id`
+			INSERT INTO job (job_title, company, company_url, salary_range, salary_min, salary_max, salary_currency, location, description, perks, interview_process, how_to_apply, created_at, url_id, slug, company_email, company_icon_image_id, external_id, salary_period, salary_currency_iso, visa_sponsorship, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at, screening_questions, search_document)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, 'year', $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, COALESCE($29::jsonb, '[]'::jsonb), ` + fmt.Sprintf(jobSearchDocument, "$1::varchar", "$2::varchar", "$9::text") + `) RETURNING id`
 	slugTitle := slug.Make(fmt.Sprintf("%s %s %d", job.JobTitle, job.Company, time.Now().UTC().Unix()))
 	createdAt := time.Now().UTC().Unix()
 	salaryMinInt, err := strconv.Atoi(strings.TrimSpace(job.SalaryMin))
@@ -159,6 +196,7 @@ func (r *Repository) SaveDraft(job *JobRq) (int, error) {
 		expiration.NewsletterEligibilityExpiredAt,
 		expiration.PlanExpiredAt,
 		expiration.SocialMediaEligibilityExpiredAt,
+		job.ScreeningQuestions,
 	)
 
 	if err := res.Scan(&lastInsertID); err != nil {
@@ -178,7 +216,7 @@ func (r *Repository) UpdateJob(job *JobRqUpdate, jobID int) error {
 	}
 	salaryRange := salaryToSalaryRangeString(salaryMinInt, salaryMaxInt, job.SalaryCurrency)
 	_, err = r.db.Exec(
-		`UPDATE job SET job_title = $1, company = $2, company_url = $3, salary_min = $4, salary_max = $5, salary_currency = $6, salary_range = $7, location = $8, description = $9, perks = $10, interview_process = $11, how_to_apply = $12, company_icon_image_id = $13 WHERE id = $14`,
+		`UPDATE job SET job_title = $1, company = $2, company_url = $3, salary_min = $4, salary_max = $5, salary_currency = $6, salary_range = $7, location = $8, description = $9, perks = $10, interview_process = $11, how_to_apply = $12, company_icon_image_id = $13, screening_questions = COALESCE($15::jsonb, screening_questions), search_document = `+fmt.Sprintf(jobSearchDocument, "$1::varchar", "$2::varchar", "$9::text")+` WHERE id = $14`,
 		job.JobTitle,
 		job.Company,
 		job.CompanyURL,
@@ -193,6 +231,7 @@ func (r *Repository) UpdateJob(job *JobRqUpdate, jobID int) error {
 		job.HowToApply,
 		job.CompanyIconID,
 		jobID,
+		job.ScreeningQuestions,
 	)
 	if err != nil {
 		return err
@@ -367,13 +406,13 @@ func (r *Repository) TopNJobsByCurrencyAndLocation(currency, location string, ma
 func (r *Repository) JobPostBySlug(slug string) (*JobPost, error) {
 	job := &JobPost{}
 	row := r.db.QueryRow(
-		`SELECT id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts
+		`SELECT id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, screening_questions
 		FROM job
 		WHERE approved_at IS NOT NULL
 		AND slug = $1`, slug)
 	var createdAt time.Time
 	var perks, interview, companyIcon sql.NullString
-	err := row.Scan(&job.ID, &job.JobTitle, &job.Company, &job.CompanyURL, &job.SalaryRange, &job.Location, &job.JobDescription, &perks, &interview, &job.HowToApply, &createdAt, &job.CreatedAt, &job.Slug, &job.SalaryMin, &job.SalaryMax, &job.SalaryCurrency, &companyIcon, &job.ExternalID, &job.SalaryPeriod, &job.Expired, &job.LastWeekClickouts)
+	err := row.Scan(&job.ID, &job.JobTitle, &job.Company, &job.CompanyURL, &job.SalaryRange, &job.Location, &job.JobDescription, &perks, &interview, &job.HowToApply, &createdAt, &job.CreatedAt, &job.Slug, &job.SalaryMin, &job.SalaryMax, &job.SalaryCurrency, &companyIcon, &job.ExternalID, &job.SalaryPeriod, &job.Expired, &job.LastWeekClickouts, &job.ScreeningQuestions)
 	if companyIcon.Valid {
 		job.CompanyIconID = companyIcon.String
 	}
@@ -418,7 +457,7 @@ func (r *Repository) JobPostBySlugAdmin(slug string) (*JobPost, error) {
 func (r *Repository) JobPostByIDForEdit(jobID int) (*JobPostForEdit, error) {
 	job := &JobPostForEdit{}
 	row := r.db.QueryRow(
-		`SELECT job_title, company, company_email, company_url, salary_min, salary_max, salary_currency, location, description, perks, interview_process, how_to_apply, created_at, slug, approved_at, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at
+		`SELECT job_title, company, company_email, company_url, salary_min, salary_max, salary_currency, location, description, perks, interview_process, how_to_apply, created_at, slug, approved_at, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at, screening_questions
 		FROM job
 		WHERE id = $1`, jobID)
 	var perks, interview, companyURL, companyIconID sql.NullString
@@ -452,6 +491,7 @@ func (r *Repository) JobPostByIDForEdit(jobID int) (*JobPostForEdit, error) {
 		&job.NewsletterEligibilityExpiredAt,
 		&job.PlanExpiredAt,
 		&job.SocialMediaEligibilityExpiredAt,
+		&job.ScreeningQuestions,
 	)
 	if err != nil {
 		return job, err
@@ -476,11 +516,11 @@ func (r *Repository) JobPostByIDForEdit(jobID int) (*JobPostForEdit, error) {
 func (r *Repository) JobPostByExternalIDForEdit(externalID string) (*JobPostForEdit, error) {
 	job := &JobPostForEdit{}
 	row := r.db.QueryRow(
-		`SELECT id, job_title, company, company_email, company_url, salary_min, salary_max, salary_currency, location, description, perks, interview_process, how_to_apply, created_at, slug, approved_at, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period
+		`SELECT id, job_title, company, company_email, company_url, salary_min, salary_max, salary_currency, location, description, perks, interview_process, how_to_apply, created_at, slug, approved_at, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, screening_questions
 		FROM job
 		WHERE external_id = $1`, externalID)
 	var perks, interview, companyURL, companyIconID sql.NullString
-	err := row.Scan(&job.ID, &job.JobTitle, &job.Company, &job.CompanyEmail, &companyURL, &job.SalaryMin, &job.SalaryMax, &job.SalaryCurrency, &job.Location, &job.JobDescription, &perks, &interview, &job.HowToApply, &job.CreatedAt, &job.Slug, &job.ApprovedAt, &job.SalaryMin, &job.SalaryMax, &job.SalaryCurrency, &companyIconID, &job.ExternalID, &job.SalaryPeriod)
+	err := row.Scan(&job.ID, &job.JobTitle, &job.Company, &job.CompanyEmail, &companyURL, &job.SalaryMin, &job.SalaryMax, &job.SalaryCurrency, &job.Location, &job.JobDescription, &perks, &interview, &job.HowToApply, &job.CreatedAt, &job.Slug, &job.ApprovedAt, &job.SalaryMin, &job.SalaryMax, &job.SalaryCurrency, &companyIconID, &job.ExternalID, &job.SalaryPeriod, &job.ScreeningQuestions)
 	if err != nil {
 		return job, err
 	}
@@ -540,6 +580,12 @@ func (r *Repository) DeleteJobCascade(jobID int) error {
 	); err != nil {
 		return err
 	}
+	if _, err := r.db.Exec(
+		`DELETE FROM applicant_note WHERE apply_token IN (SELECT token FROM apply_token WHERE job_id = $1)`,
+		jobID,
+	); err != nil {
+		return err
+	}
 	if _, err := r.db.Exec(
 		`DELETE FROM apply_token WHERE job_id = $1`,
 		jobID,
@@ -720,15 +766,91 @@ func (r *Repository) GetPinnedJobs() ([]*JobPost, error) {
 	return jobs, nil
 }
 
-func (r *Repository) JobsByQuery(location, tag string, pageId, salary int, currency string, jobsPerPage int, includePinnedJobs bool) ([]*JobPost, int, error) {
+// jobSearchDocument builds the weighted search_document column from the job title, company and description.
+// Parameters also bound to the columns need casting to the column type, or postgres deduces them as text
+const jobSearchDocument = `setweight(to_tsvector(coalesce(%s, '')), 'A') || setweight(to_tsvector(coalesce(%s, '')), 'B') || setweight(to_tsvector(coalesce(%s, '')), 'C')`
+
+// markers used by ts_headline, swapped for <mark> once the snippet is html escaped
+const (
+	snippetStartSel = "[[[mark]]]"
+	snippetStopSel  = "[[[/mark]]]"
+)
+
+func (r *Repository) JobsByQuery(location, tag string, pageId, salary int, currency string, jobsPerPage int, includePinnedJobs bool, filters JobFilters) ([]*JobPost, int, error) {
 	jobs := []*JobPost{}
-	var rows *sql.Rows
 	offset := pageId*jobsPerPage - jobsPerPage
-	// replace `|` with white space
-	// remove double white spaces
-	// join with `|` for ps query
-	tag = strings.Join(strings.Fields(strings.ReplaceAll(tag, "|", " ")), "|")
-	rows, err := getQueryForArgs(r.db, location, tag, salary, currency, offset, jobsPerPage, includePinnedJobs)
+	tag = strings.Join(strings.Fields(tag), " ")
+
+	query := `SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at`
+	from := ` FROM job`
+	where := ` WHERE approved_at IS NOT NULL`
+	orderBy := ` ORDER BY created_at DESC`
+	var args []interface{}
+	argIndex := 1
+
+	if !includePinnedJobs {
+		where += ` AND front_page_eligibility_expired_at < NOW()`
+	}
+
+	if salary != 0 {
+		from += fmt.Sprintf(` LEFT JOIN fx_rate ON fx_rate.base = job.salary_currency_iso AND fx_rate.target = $%d`, argIndex)
+		where += fmt.Sprintf(` AND (COALESCE(fx_rate.value, 1)*job.salary_max) >= $%d`, argIndex+1)
+		args = append(args, currency, salary)
+		argIndex += 2
+	}
+
+	if tag != "" {
+		query += fmt.Sprintf(`, ts_headline(description, websearch_to_tsquery($%d), 'MaxFragments=2, MinWords=10, MaxWords=25, StartSel="%s", StopSel="%s"') AS snippet`, argIndex, snippetStartSel, snippetStopSel)
+		where += fmt.Sprintf(` AND search_document @@ websearch_to_tsquery($%d)`, argIndex)
+		orderBy = fmt.Sprintf(` ORDER BY ts_rank(search_document, websearch_to_tsquery($%d)) DESC, created_at DESC`, argIndex)
+		args = append(args, tag)
+		argIndex++
+	} else {
+		query += `, '' AS snippet`
+	}
+
+	if location != "" {
+		where += fmt.Sprintf(` AND location ILIKE '%%' || $%d || '%%'`, argIndex)
+		args = append(args, location)
+		argIndex++
+	}
+
+	if filters.RemoteOnly {
+		where += ` AND location ILIKE '%remote%'`
+	}
+
+	if filters.VisaSponsorship {
+		where += ` AND visa_sponsorship = true`
+	}
+
+	if filters.SalaryPeriod != "" {
+		where += fmt.Sprintf(` AND salary_period = $%d`, argIndex)
+		args = append(args, filters.SalaryPeriod)
+		argIndex++
+	}
+
+	if filters.PostedWithinDays > 0 {
+		where += fmt.Sprintf(` AND approved_at >= NOW() - make_interval(days => $%d)`, argIndex)
+		args = append(args, filters.PostedWithinDays)
+		argIndex++
+	}
+
+	if filters.Company != "" {
+		where += fmt.Sprintf(` AND company ILIKE '%%' || $%d || '%%'`, argIndex)
+		args = append(args, filters.Company)
+		argIndex++
+	}
+
+	if !filters.ApprovedAfter.IsZero() {
+		where += fmt.Sprintf(` AND approved_at > $%d`, argIndex)
+		args = append(args, filters.ApprovedAfter)
+		argIndex++
+	}
+
+	query += from + where + orderBy + fmt.Sprintf(` LIMIT $%d OFFSET $%d`, argIndex, argIndex+1)
+	args = append(args, jobsPerPage, offset)
+
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return jobs, 0, err
 	}
@@ -738,6 +860,7 @@ func (r *Repository) JobsByQuery(location, tag string, pageId, salary int, curre
 		job := &JobPost{}
 		var createdAt time.Time
 		var perks, interview, companyIcon sql.NullString
+		var snippet string
 		err = rows.Scan(
 			&fullRowsCount,
 			&job.ID,
@@ -769,6 +892,7 @@ func (r *Repository) JobsByQuery(location, tag string, pageId, salary int, curre
 			&job.NewsletterEligibilityExpiredAt,
 			&job.PlanExpiredAt,
 			&job.SocialMediaEligibilityExpiredAt,
+			&snippet,
 		)
 		if companyIcon.Valid {
 			job.CompanyIconID = companyIcon.String
@@ -780,6 +904,10 @@ func (r *Repository) JobsByQuery(location, tag string, pageId, salary int, curre
 			job.InterviewProcess = interview.String
 		}
 		job.TimeAgo = createdAt.UTC().Format("January 2006")
+		// only approved jobs are listed, created_at is the approval time
+		approvedAt := createdAt
+		job.ApprovedAt = &approvedAt
+		job.SearchSnippet = highlightSnippet(snippet)
 		if err != nil {
 			return jobs, fullRowsCount, err
 		}
@@ -948,8 +1076,39 @@ func (r *Repository) GetLastNJobsFromID(max, jobID int) ([]*JobPost, error) {
 	return jobs, nil
 }
 
+// GetJobsForNewsletter returns the newest jobs approved after since whose plan includes the newsletter
+func (r *Repository) GetJobsForNewsletter(max int, since time.Time) ([]*JobPost, error) {
+	return r.newsletterJobs(`SELECT id, job_title, company, salary_range, location, slug, salary_currency, company_icon_image_id, external_id, salary_period FROM job WHERE approved_at IS NOT NULL AND approved_at > $2 AND expired = false AND newsletter_eligibility_expired_at > NOW() ORDER BY approved_at DESC LIMIT $1`, max, since)
+}
+
+func (r *Repository) GetJobsByIDs(ids []int64) ([]*JobPost, error) {
+	return r.newsletterJobs(`SELECT id, job_title, company, salary_range, location, slug, salary_currency, company_icon_image_id, external_id, salary_period FROM job WHERE id = ANY($1) ORDER BY approved_at DESC`, pq.Array(ids))
+}
+
+func (r *Repository) newsletterJobs(query string, args ...interface{}) ([]*JobPost, error) {
+	var jobs []*JobPost
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return jobs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		job := &JobPost{}
+		var companyIcon sql.NullString
+		err := rows.Scan(&job.ID, &job.JobTitle, &job.Company, &job.SalaryRange, &job.Location, &job.Slug, &job.SalaryCurrency, &companyIcon, &job.ExternalID, &job.SalaryPeriod)
+		if err != nil {
+			return jobs, err
+		}
+		if companyIcon.Valid {
+			job.CompanyIconID = companyIcon.String
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
 func (r *Repository) MarkJobAsExpired(jobID int) error {
-	_, err := r.db.Exec(`UPDATE job SET expired = true WHERE id = $1`, jobID)
+	_, err := r.db.Exec(`UPDATE job SET expired = true, expired_at = COALESCE(expired_at, NOW()) WHERE id = $1`, jobID)
 	return err
 }
 
@@ -1061,8 +1220,18 @@ func (r *Repository) PlanTypeAndDurationToExpirationsFromExistingExpirations(exp
 	return val, nil
 }
 
-func (r *Repository) UpdateJobPlan(jobID int, planType string, planDuration int, expiration JobExpirationEntity) error {
-	_, err := r.db.Exec(
+// UpdateJobPlan moves the job to a new plan and records the change in the job plan history with event
+func (r *Repository) UpdateJobPlan(jobID int, planType string, planDuration int, expiration JobExpirationEntity, event string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	var previousPlanType string
+	if err := tx.QueryRow(`SELECT plan_type FROM job WHERE id = $1 FOR UPDATE`, jobID).Scan(&previousPlanType); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(
 		`UPDATE job SET plan_type = $1, plan_duration = $2, newsletter_eligibility_expired_at = $3, blog_eligibility_expired_at = $4, social_media_eligibility_expired_at = $5, front_page_eligibility_expired_at = $6, company_page_eligibility_expired_at = $7, plan_expired_at = $8, approved_at = NOW() WHERE id = $9`,
 		planType,
 		planDuration,
@@ -1073,6 +1242,113 @@ func (r *Repository) UpdateJobPlan(jobID int, planType string, planDuration int,
 		expiration.CompanyPageEligibilityExpiredAt,
 		expiration.PlanExpiredAt,
 		jobID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordPlanEvent(tx, jobID, event, planType, planDuration, previousPlanType, expiration.PlanExpiredAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// PlanRenewals returns the paid plans of approved jobs expiring between from and to, soonest first.
+// Jobs posted by excludeEmail are left out
+func (r *Repository) PlanRenewals(from, to time.Time, excludeEmail string) ([]PlanRenewal, error) {
+	renewals := []PlanRenewal{}
+	rows, err := r.db.Query(
+		`SELECT j.id, j.job_title, j.company, j.company_email, j.slug, COALESCE((SELECT t.token FROM edit_token t WHERE t.job_id = j.id LIMIT 1), ''), j.plan_type, j.plan_duration, j.plan_expired_at, COALESCE(j.expired, false),
+		(SELECT MAX(h.created_at) FROM job_plan_history h WHERE h.job_id = j.id AND h.event = $4 AND h.plan_expired_at = j.plan_expired_at)
+		FROM job j
+		WHERE j.approved_at IS NOT NULL AND j.plan_duration > 0 AND j.plan_expired_at >= $1 AND j.plan_expired_at < $2 AND j.company_email != $3
+		ORDER BY j.plan_expired_at ASC`,
+		from,
+		to,
+		excludeEmail,
+		PlanEventRenewalOffered,
+	)
+	if err != nil {
+		return renewals, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p PlanRenewal
+		if err := rows.Scan(&p.JobID, &p.JobTitle, &p.Company, &p.CompanyEmail, &p.Slug, &p.EditToken, &p.PlanType, &p.PlanDuration, &p.PlanExpiredAt, &p.JobExpired, &p.OfferedAt); err != nil {
+			return renewals, err
+		}
+		renewals = append(renewals, p)
+	}
+	return renewals, rows.Err()
+}
+
+// ExpirePlan downgrades a job whose plan expired to an expired basic plan, ending the eligibility of
+// any plan feature still running. It returns false when the plan was renewed or downgraded meanwhile
+func (r *Repository) ExpirePlan(jobID int) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	var previousPlanType string
+	var planExpiredAt time.Time
+	err = tx.QueryRow(
+		`SELECT plan_type, plan_expired_at FROM job WHERE id = $1 AND plan_duration > 0 AND plan_expired_at <= NOW() FOR UPDATE`,
+		jobID,
+	).Scan(&previousPlanType, &planExpiredAt)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return false, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	_, err = tx.Exec(
+		`UPDATE job SET plan_type = $1, plan_duration = 0,
+		newsletter_eligibility_expired_at = LEAST(newsletter_eligibility_expired_at, NOW()),
+		blog_eligibility_expired_at = LEAST(blog_eligibility_expired_at, NOW()),
+		social_media_eligibility_expired_at = LEAST(social_media_eligibility_expired_at, NOW()),
+		front_page_eligibility_expired_at = LEAST(front_page_eligibility_expired_at, NOW()),
+		company_page_eligibility_expired_at = LEAST(company_page_eligibility_expired_at, NOW())
+		WHERE id = $2`,
+		JobPlanTypeBasic,
+		jobID,
+	)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := recordPlanEvent(tx, jobID, PlanEventExpired, JobPlanTypeBasic, 0, previousPlanType, planExpiredAt); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RecordPlanRenewalOffered records that the poster was offered to renew the plan before it expires
+func (r *Repository) RecordPlanRenewalOffered(p PlanRenewal) error {
+	return recordPlanEvent(r.db, p.JobID, PlanEventRenewalOffered, p.PlanType, p.PlanDuration, p.PlanType, p.PlanExpiredAt)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func recordPlanEvent(db execer, jobID int, event, planType string, planDuration int, previousPlanType string, planExpiredAt time.Time) error {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT INTO job_plan_history (id, job_id, event, plan_type, plan_duration, previous_plan_type, plan_expired_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())`,
+		id.String(),
+		jobID,
+		event,
+		planType,
+		planDuration,
+		previousPlanType,
+		planExpiredAt,
+	)
 	return err
 }
 
@@ -1146,12 +1422,148 @@ func (r *Repository) SetValue(key, val string) error {
 	return err
 }
 
-func (r *Repository) ApplyToJob(jobID int, cv []byte, email, token string) error {
-	stmt := `INSERT INTO apply_token (token, job_id, created_at, email, cv) VALUES ($1, $2, NOW(), $3, $4)`
-	_, err := r.db.Exec(stmt, token, jobID, email, cv)
+// ApplyToJob stores a new, unconfirmed application. cvText is the text extracted from the CV, indexed
+// for keyword search across the job's applicants
+func (r *Repository) ApplyToJob(jobID int, cv []byte, cvContentType, cvText, email, token string, answers ScreeningAnswers) error {
+	stmt := `INSERT INTO apply_token (token, job_id, created_at, email, cv, cv_content_type, cv_text, cv_search_document, screening_answers) VALUES ($1, $2, NOW(), $3, $4, $5, $6, to_tsvector($6::text), $7)`
+	_, err := r.db.Exec(stmt, token, jobID, email, cv, cvContentType, cvText, answers)
+	return err
+}
+
+// CvsWithoutText returns up to limit applicants whose CV was never indexed for keyword search,
+// applications sent before CV search was added
+func (r *Repository) CvsWithoutText(limit int) ([]Applicant, error) {
+	applicants := []Applicant{}
+	rows, err := r.db.Query(
+		`SELECT token, cv, cv_content_type FROM apply_token
+		WHERE cv_search_document IS NULL AND cv_purged_at IS NULL AND length(cv) > 0
+		ORDER BY created_at ASC LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return applicants, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Applicant
+		if err := rows.Scan(&a.Token, &a.Cv, &a.CvContentType); err != nil {
+			return applicants, err
+		}
+		applicants = append(applicants, a)
+	}
+	return applicants, rows.Err()
+}
+
+// SaveCvText indexes the text extracted from the applicant's CV, an empty text still marks the CV as indexed
+func (r *Repository) SaveCvText(applyToken, cvText string) error {
+	_, err := r.db.Exec(`UPDATE apply_token SET cv_text = $2, cv_search_document = to_tsvector($2::text) WHERE token = $1`, applyToken, cvText)
 	return err
 }
 
+// JobIDForApplicants returns the job the given applicants applied to, it fails when they don't all
+// belong to the same job
+func (r *Repository) JobIDForApplicants(applyTokens []string) (int, error) {
+	rows, err := r.db.Query(`SELECT DISTINCT job_id FROM apply_token WHERE token = ANY($1)`, pq.Array(applyTokens))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	jobIDs := make([]int, 0, 1)
+	for rows.Next() {
+		var jobID int
+		if err := rows.Scan(&jobID); err != nil {
+			return 0, err
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(jobIDs) != 1 {
+		return 0, sql.ErrNoRows
+	}
+	return jobIDs[0], nil
+}
+
+// UpdateApplicantsStage moves the applicants to stage and returns the ones whose stage changed
+func (r *Repository) UpdateApplicantsStage(jobID int, applyTokens []string, stage string) ([]Applicant, error) {
+	rows, err := r.db.Query(
+		`UPDATE apply_token SET stage = $1, stage_updated_at = NOW()
+		WHERE job_id = $2 AND token = ANY($3) AND stage != $1
+		RETURNING token, email, created_at, confirmed_at, stage, rating, stage_updated_at`,
+		stage,
+		jobID,
+		pq.Array(applyTokens),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applicants := make([]Applicant, 0, len(applyTokens))
+	for rows.Next() {
+		var a Applicant
+		if err := rows.Scan(&a.Token, &a.Email, &a.CreatedAt, &a.ConfirmedAt, &a.Stage, &a.Rating, &a.StageUpdatedAt); err != nil {
+			return nil, err
+		}
+		applicants = append(applicants, a)
+	}
+	return applicants, rows.Err()
+}
+
+func (r *Repository) UpdateApplicantRating(jobID int, applyToken string, rating int) error {
+	res, err := r.db.Exec(`UPDATE apply_token SET rating = $1 WHERE job_id = $2 AND token = $3`, rating, jobID, applyToken)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) AddApplicantNote(applyToken, text string) (ApplicantNote, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return ApplicantNote{}, err
+	}
+	note := ApplicantNote{
+		ID:         id.String(),
+		ApplyToken: applyToken,
+		Text:       text,
+		CreatedAt:  time.Now().UTC(),
+	}
+	_, err = r.db.Exec(`INSERT INTO applicant_note (id, apply_token, text, created_at) VALUES ($1, $2, $3, $4)`, note.ID, note.ApplyToken, note.Text, note.CreatedAt)
+	return note, err
+}
+
+// WithdrawApplication deletes the applications to the job sent from the email address, CV and
+// employer notes included
+func (r *Repository) WithdrawApplication(jobID int, email string) error {
+	if _, err := r.db.Exec(
+		`DELETE FROM applicant_note WHERE apply_token IN (SELECT token FROM apply_token WHERE job_id = $1 AND email = $2)`,
+		jobID,
+		email,
+	); err != nil {
+		return err
+	}
+	res, err := r.db.Exec(`DELETE FROM apply_token WHERE job_id = $1 AND email = $2`, jobID, email)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (r *Repository) ConfirmApplyToJob(token string) error {
 	_, err := r.db.Exec(
 		`UPDATE apply_token SET confirmed_at = NOW() WHERE token = $1`,
@@ -1160,6 +1572,84 @@ func (r *Repository) ConfirmApplyToJob(token string) error {
 	return err
 }
 
+// CvRetentions returns the jobs holding applicant CVs due for deletion before the given time. CVs are kept
+// retentionDays after the job's plan expires or the job is marked as expired, whichever comes first, and
+// never less than retentionDays after the latest application
+func (r *Repository) CvRetentions(retentionDays int, before time.Time) ([]CvRetention, error) {
+	retentions := []CvRetention{}
+	rows, err := r.db.Query(`WITH retained AS (
+		SELECT a.job_id, COUNT(*) AS applicants, MAX(a.created_at) AS last_applied_at
+		FROM apply_token a WHERE a.cv_purged_at IS NULL GROUP BY a.job_id
+	)
+	SELECT * FROM (
+		SELECT j.id, j.job_title, j.company, j.company_email, j.slug, COALESCE((SELECT t.token FROM edit_token t WHERE t.job_id = j.id LIMIT 1), ''), retained.applicants,
+		GREATEST(LEAST(j.plan_expired_at, j.expired_at), retained.last_applied_at) + $1::int * INTERVAL '1 day' AS purge_at, j.cv_purge_notified_at
+		FROM job j JOIN retained ON retained.job_id = j.id
+	) r WHERE r.purge_at < $2 ORDER BY r.purge_at ASC`, retentionDays, before)
+	if err != nil {
+		return retentions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c CvRetention
+		if err := rows.Scan(&c.JobID, &c.JobTitle, &c.Company, &c.CompanyEmail, &c.Slug, &c.EditToken, &c.Applicants, &c.PurgeAt, &c.NotifiedAt); err != nil {
+			return retentions, err
+		}
+		retentions = append(retentions, c)
+	}
+	return retentions, rows.Err()
+}
+
+func (r *Repository) MarkCvRetentionNotified(jobID int) error {
+	_, err := r.db.Exec(`UPDATE job SET cv_purge_notified_at = NOW() WHERE id = $1`, jobID)
+	return err
+}
+
+// PurgeCvsForJob deletes the CV files and extracted text of the job's applicants, keeping the rest of
+// the application, strips the CVs attached to the new applicant emails in the outbox and records the
+// purge in the audit log
+func (r *Repository) PurgeCvsForJob(jobID int, reason string) (int, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var purged int
+	var size int64
+	err = tx.QueryRow(`WITH purged AS (
+		UPDATE apply_token a SET cv = '', cv_text = '', cv_search_document = NULL, cv_purged_at = NOW()
+		FROM apply_token prev WHERE prev.token = a.token AND a.job_id = $1 AND a.cv_purged_at IS NULL
+		RETURNING octet_length(prev.cv) AS size
+	) SELECT COUNT(*), COALESCE(SUM(size), 0) FROM purged`, jobID).Scan(&purged, &size)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if purged == 0 {
+		tx.Rollback()
+		return 0, nil
+	}
+	// the new applicant emails sent to the employer carry the CV as an attachment
+	_, err = tx.Exec(`UPDATE email_outbox o SET message = o.message - 'attachment'
+		FROM apply_token a JOIN job j ON j.id = a.job_id
+		WHERE a.job_id = $1 AND o.message ? 'attachment'
+		AND lower(o.message->'replyTo'->>'email') = lower(a.email)
+		AND o.message->'to' @> jsonb_build_array(jsonb_build_object('email', j.how_to_apply))`, jobID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	_, err = tx.Exec(`INSERT INTO cv_purge_log (id, job_id, applicants, bytes, reason, created_at) VALUES ($1, $2, $3, $4, $5, NOW())`, id.String(), jobID, purged, size, reason)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return purged, tx.Commit()
+}
+
 func (r *Repository) CleanupExpiredApplyTokens() error {
 	_, err := r.db.Exec(
 		`DELETE FROM apply_token WHERE created_at < NOW() - INTERVAL '3 days' OR confirmed_at IS NOT NULL`,
@@ -1189,80 +1679,12 @@ func salaryToSalaryRangeString(salaryMin, salaryMax int, currency string) string
 	return fmt.Sprintf("%s%s - %s%s", currency, salaryMinStr, currency, salaryMaxStr)
 }
 
-func getQueryForArgs(conn *sql.DB, location, tag string, salary int, currency string, offset, max int, includePinnedJobs bool) (*sql.Rows, error) {
-	planTypeFilter := "AND front_page_eligibility_expired_at < NOW()"
-	if includePinnedJobs {
-		planTypeFilter = "AND 1=1"
-	}
-	if tag == "" && location == "" && salary == 0 {
-		return conn.Query(`
-		SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at
-		FROM job
-		WHERE approved_at IS NOT NULL `+planTypeFilter+` ORDER BY created_at DESC LIMIT $2 OFFSET $1`, offset, max)
-	}
-	if tag == "" && location != "" && salary == 0 {
-		return conn.Query(`
-		SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at 
-		FROM job
-		WHERE approved_at IS NOT NULL `+planTypeFilter+` AND location ILIKE '%' || $1 || '%'
-		ORDER BY created_at DESC LIMIT $3 OFFSET $2`, location, offset, max)
+func highlightSnippet(snippet string) string {
+	if snippet == "" {
+		return ""
 	}
-	if tag != "" && location == "" && salary == 0 {
-		return conn.Query(`
-	SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at
-	FROM
-	(
-		SELECT id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, approved_at, created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at, to_tsvector(job_title) || to_tsvector(company) || to_tsvector(description) AS doc
-		FROM job WHERE approved_at IS NOT NULL `+planTypeFilter+`
-	) AS job_
-	WHERE job_.doc @@ to_tsquery($1)
-	ORDER BY ts_rank(job_.doc, to_tsquery($1)) DESC, created_at DESC LIMIT $3 OFFSET $2`, tag, offset, max)
-	}
-	if tag != "" && location != "" && salary == 0 {
-		return conn.Query(`
-	SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at
-	FROM
-	(
-		SELECT id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, approved_at, created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at, to_tsvector(job_title) || to_tsvector(company) || to_tsvector(description) AS doc
-		FROM job WHERE approved_at IS NOT NULL `+planTypeFilter+`
-	) AS job_
-	WHERE job_.doc @@ to_tsquery($1)
-	AND location ILIKE '%' || $2 || '%'
-	ORDER BY ts_rank(job_.doc, to_tsquery($1)) DESC, created_at DESC LIMIT $4 OFFSET $3`, tag, location, offset, max)
-	}
-	if tag == "" && location == "" && salary != 0 {
-		return conn.Query(`
-		SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at
-		FROM job FULL JOIN fx_rate ON fx_rate.base = job.salary_currency_iso AND fx_rate.target = $4
-		WHERE approved_at IS NOT NULL `+planTypeFilter+` AND (COALESCE(fx_rate.value, 1)*job.salary_max) >= $3 ORDER BY created_at DESC LIMIT $2 OFFSET $1`, offset, max, salary, currency)
-	}
-	if tag == "" && location != "" && salary != 0 {
-		return conn.Query(`
-		SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at 
-		FROM job FULL JOIN fx_rate ON fx_rate.base = job.salary_currency_iso AND fx_rate.target = $5
-		WHERE approved_at IS NOT NULL `+planTypeFilter+` AND location ILIKE '%' || $1 || '%' AND (COALESCE(fx_rate.value, 1)*job.salary_max) >= $4
-		ORDER BY created_at DESC LIMIT $3 OFFSET $2`, location, offset, max, salary, currency)
-	}
-	if tag != "" && location == "" && salary != 0 {
-		return conn.Query(`
-	SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at
-	FROM
-	(
-		SELECT id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, approved_at, created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at, to_tsvector(job_title) || to_tsvector(company) || to_tsvector(description) AS doc
-		FROM job FULL JOIN fx_rate ON fx_rate.base = job.salary_currency_iso AND fx_rate.target = $5 WHERE approved_at IS NOT NULL `+planTypeFilter+` AND (COALESCE(fx_rate.value, 1)*job.salary_max) >= $4
-	) AS job_
-	WHERE job_.doc @@ to_tsquery($1)
-	ORDER BY ts_rank(job_.doc, to_tsquery($1)) DESC, created_at DESC LIMIT $3 OFFSET $2`, tag, offset, max, salary, currency)
-	}
-
-	return conn.Query(`
-	SELECT count(*) OVER() AS full_count, id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, COALESCE(approved_at, created_at) as created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at
-	FROM
-	(
-		SELECT id, job_title, company, company_url, salary_range, location, description, perks, interview_process, how_to_apply, approved_at, created_at, url_id, slug, salary_min, salary_max, salary_currency, company_icon_image_id, external_id, salary_period, expired, last_week_clickouts, plan_type, plan_duration, blog_eligibility_expired_at, company_page_eligibility_expired_at, front_page_eligibility_expired_at, newsletter_eligibility_expired_at, plan_expired_at, social_media_eligibility_expired_at, to_tsvector(job_title) || to_tsvector(company) || to_tsvector(description) AS doc
-		FROM job FULL JOIN fx_rate ON fx_rate.base = job.salary_currency_iso AND fx_rate.target = $6 WHERE approved_at IS NOT NULL `+planTypeFilter+` AND (COALESCE(fx_rate.value, 1)*job.salary_max) >= $5
-	) AS job_
-	WHERE job_.doc @@ to_tsquery($1)
-	AND location ILIKE '%' || $2 || '%'
-	ORDER BY ts_rank(job_.doc, to_tsquery($1)) DESC, created_at DESC LIMIT $4 OFFSET $3`, tag, location, offset, max, salary, currency)
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetStartSel, "<mark>")
+	snippet = strings.ReplaceAll(snippet, snippetStopSel, "</mark>")
+	return snippet
 }
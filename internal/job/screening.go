@@ -0,0 +1,215 @@
+package job
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	ScreeningQuestionText           = "text"
+	ScreeningQuestionYesNo          = "yes_no"
+	ScreeningQuestionSingleChoice   = "single_choice"
+	ScreeningQuestionMultipleChoice = "multiple_choice"
+	ScreeningQuestionNumber         = "number"
+
+	ScreeningAnswerYes = "yes"
+	ScreeningAnswerNo  = "no"
+
+	MaxScreeningQuestions = 10
+
+	maxScreeningQuestionLength = 300
+	maxScreeningOptions        = 20
+	maxScreeningOptionLength   = 100
+	maxScreeningAnswerLength   = 2000
+
+	// screeningFormFieldPrefix prefixes the quick apply form field of each question, e.g. screening-<id>
+	screeningFormFieldPrefix = "screening-"
+)
+
+// ScreeningQuestion is asked to applicants in the quick apply form, Options only applies
+// to single and multiple choice questions
+type ScreeningQuestion struct {
+	ID       string   `json:"id"`
+	Text     string   `json:"text"`
+	Type     string   `json:"type"`
+	Options  []string `json:"options,omitempty"`
+	Required bool     `json:"required"`
+}
+
+// FormField is the name of the quick apply form field holding the answer to the question
+func (q ScreeningQuestion) FormField() string {
+	return screeningFormFieldPrefix + q.ID
+}
+
+// ScreeningQuestions is stored as JSON on the job, a nil value leaves the stored questions unchanged on update
+type ScreeningQuestions []ScreeningQuestion
+
+func (qs ScreeningQuestions) Value() (driver.Value, error) {
+	if qs == nil {
+		return nil, nil
+	}
+	return json.Marshal(qs)
+}
+
+func (qs *ScreeningQuestions) Scan(src interface{}) error {
+	return scanJSON(src, qs)
+}
+
+// Normalize trims and validates the questions set by the employer and gives new questions an ID
+func (qs ScreeningQuestions) Normalize() (ScreeningQuestions, error) {
+	if qs == nil {
+		return nil, nil
+	}
+	if len(qs) > MaxScreeningQuestions {
+		return nil, fmt.Errorf("you can add up to %d screening questions", MaxScreeningQuestions)
+	}
+	normalized := make(ScreeningQuestions, 0, len(qs))
+	seen := make(map[string]struct{}, len(qs))
+	for _, q := range qs {
+		q.Text = strings.TrimSpace(q.Text)
+		if q.Text == "" || len(q.Text) > maxScreeningQuestionLength {
+			return nil, fmt.Errorf("screening questions must be between 1 and %d characters", maxScreeningQuestionLength)
+		}
+		if _, ok := seen[q.ID]; q.ID == "" || ok {
+			q.ID = ksuid.New().String()
+		}
+		seen[q.ID] = struct{}{}
+		switch q.Type {
+		case ScreeningQuestionText, ScreeningQuestionYesNo, ScreeningQuestionNumber:
+			q.Options = nil
+		case ScreeningQuestionSingleChoice, ScreeningQuestionMultipleChoice:
+			options := make([]string, 0, len(q.Options))
+			for _, o := range q.Options {
+				o = strings.TrimSpace(o)
+				if o == "" || containsString(options, o) {
+					continue
+				}
+				if len(o) > maxScreeningOptionLength {
+					return nil, fmt.Errorf("screening question options must be up to %d characters", maxScreeningOptionLength)
+				}
+				options = append(options, o)
+			}
+			if len(options) < 2 || len(options) > maxScreeningOptions {
+				return nil, fmt.Errorf("%q must have between 2 and %d options", q.Text, maxScreeningOptions)
+			}
+			q.Options = options
+		default:
+			return nil, fmt.Errorf("invalid screening question type %q", q.Type)
+		}
+		normalized = append(normalized, q)
+	}
+	return normalized, nil
+}
+
+// Answers validates the applicant's answers submitted through the quick apply form
+func (qs ScreeningQuestions) Answers(form map[string][]string) (ScreeningAnswers, error) {
+	answers := make(ScreeningAnswers, 0, len(qs))
+	for _, q := range qs {
+		values := make([]string, 0, 1)
+		for _, v := range form[q.FormField()] {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			if q.Required {
+				return nil, fmt.Errorf("please answer %q", q.Text)
+			}
+			continue
+		}
+		if q.Type != ScreeningQuestionMultipleChoice && len(values) > 1 {
+			return nil, fmt.Errorf("please give a single answer to %q", q.Text)
+		}
+		switch q.Type {
+		case ScreeningQuestionText:
+			if len(values[0]) > maxScreeningAnswerLength {
+				return nil, fmt.Errorf("the answer to %q must be up to %d characters", q.Text, maxScreeningAnswerLength)
+			}
+		case ScreeningQuestionYesNo:
+			values[0] = strings.ToLower(values[0])
+			if values[0] != ScreeningAnswerYes && values[0] != ScreeningAnswerNo {
+				return nil, fmt.Errorf("please answer yes or no to %q", q.Text)
+			}
+		case ScreeningQuestionNumber:
+			n, err := strconv.ParseFloat(values[0], 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("the answer to %q must be a number", q.Text)
+			}
+			values[0] = strconv.FormatFloat(n, 'f', -1, 64)
+		case ScreeningQuestionSingleChoice, ScreeningQuestionMultipleChoice:
+			for _, v := range values {
+				if !containsString(q.Options, v) {
+					return nil, fmt.Errorf("invalid answer to %q", q.Text)
+				}
+			}
+		}
+		answers = append(answers, ScreeningAnswer{
+			QuestionID: q.ID,
+			Question:   q.Text,
+			Type:       q.Type,
+			Values:     values,
+		})
+	}
+	return answers, nil
+}
+
+// ScreeningAnswer keeps a copy of the question, so that answers still read
+// correctly after the employer edits or removes the question
+type ScreeningAnswer struct {
+	QuestionID string   `json:"question_id"`
+	Question   string   `json:"question"`
+	Type       string   `json:"type"`
+	Values     []string `json:"values"`
+}
+
+func (a ScreeningAnswer) String() string {
+	return strings.Join(a.Values, ", ")
+}
+
+type ScreeningAnswers []ScreeningAnswer
+
+func (as ScreeningAnswers) Value() (driver.Value, error) {
+	if as == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(as)
+}
+
+func (as *ScreeningAnswers) Scan(src interface{}) error {
+	return scanJSON(src, as)
+}
+
+// ByQuestion returns the answer values keyed by question ID
+func (as ScreeningAnswers) ByQuestion() map[string][]string {
+	byQuestion := make(map[string][]string, len(as))
+	for _, a := range as {
+		byQuestion[a.QuestionID] = a.Values
+	}
+	return byQuestion
+}
+
+func scanJSON(src interface{}, dst interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dst)
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	}
+	return fmt.Errorf("unsupported type %T for json column", src)
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package jobcredit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrInsufficientCredits = errors.New("you don't have enough job credits left for this plan")
+
+// Pack is a bundle of prepaid job ad credits bought by a recruiter. A credit pays for one month of
+// a job ad on the plan of the pack, credits still left when the pack expires are lost
+type Pack struct {
+	ID              string
+	RecruiterID     string
+	Email           string
+	PlanType        string
+	Credits         int
+	Remaining       int
+	Amount          int // in US cents
+	Currency        string
+	StripeSessionID string
+	CreatedAt       time.Time
+	CompletedAt     pq.NullTime // set once paid
+	ExpiresAt       pq.NullTime
+	RevokedAt       pq.NullTime // set when the payment was refunded or disputed
+}
+
+// Balance is what a recruiter has left of the credits for a plan
+type Balance struct {
+	PlanType          string
+	Credits           int
+	NextExpiryAt      time.Time // expiry of the packs expiring soonest
+	NextExpiryCredits int
+}
+
+// PackOption is a credit pack on sale
+type PackOption struct {
+	PlanType string
+	Credits  int
+	Amount   int // in US cents
+}
+
+// PackPrice returns the price of a pack of credits for a plan costing monthlyAmount a month, with the
+// bulk discount percentage off
+func PackPrice(monthlyAmount int64, credits, discount int) int64 {
+	return monthlyAmount * int64(credits) * int64(100-discount) / 100
+}
+
+// CreditsFor returns the credits left for the plan
+func CreditsFor(balances []Balance, planType string) int {
+	for _, b := range balances {
+		if b.PlanType == planType {
+			return b.Credits
+		}
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+package jobcredit
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/segmentio/ksuid"
+)
+
+const packColumns = `id, recruiter_id, email, plan_type, credits, remaining, amount, currency, stripe_session_id, created_at, completed_at, expires_at, revoked_at`
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db}
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPack(row scanner) (Pack, error) {
+	var p Pack
+	err := row.Scan(
+		&p.ID,
+		&p.RecruiterID,
+		&p.Email,
+		&p.PlanType,
+		&p.Credits,
+		&p.Remaining,
+		&p.Amount,
+		&p.Currency,
+		&p.StripeSessionID,
+		&p.CreatedAt,
+		&p.CompletedAt,
+		&p.ExpiresAt,
+		&p.RevokedAt,
+	)
+	return p, err
+}
+
+// CreatePack records the checkout of a credit pack, its credits can be spent once it's completed
+func (r *Repository) CreatePack(p Pack) (Pack, error) {
+	k, err := ksuid.NewRandom()
+	if err != nil {
+		return p, err
+	}
+	p.ID = k.String()
+	p.Remaining = p.Credits
+	p.CreatedAt = time.Now().UTC()
+	_, err = r.db.Exec(
+		`INSERT INTO job_credit_pack (id, recruiter_id, email, plan_type, credits, remaining, amount, currency, stripe_session_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		p.ID,
+		p.RecruiterID,
+		p.Email,
+		p.PlanType,
+		p.Credits,
+		p.Remaining,
+		p.Amount,
+		p.Currency,
+		p.StripeSessionID,
+		p.CreatedAt,
+	)
+	return p, err
+}
+
+// PackBySessionID returns the pack bought with the checkout session, sql.ErrNoRows if the session
+// isn't for a credit pack
+func (r *Repository) PackBySessionID(sessionID string) (Pack, error) {
+	return scanPack(r.db.QueryRow(`SELECT `+packColumns+` FROM job_credit_pack WHERE stripe_session_id = $1`, sessionID))
+}
+
+// Complete makes the credits of a paid pack available until expiresAt. It returns false when the pack
+// was completed already
+func (r *Repository) Complete(sessionID, paymentIntentID string, expiresAt time.Time) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE job_credit_pack SET completed_at = NOW(), expires_at = $2, stripe_payment_intent_id = NULLIF($3, '') WHERE stripe_session_id = $1 AND completed_at IS NULL`,
+		sessionID,
+		expiresAt,
+		paymentIntentID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Revoke takes away the credits left in a pack whose payment was refunded or disputed
+func (r *Repository) Revoke(sessionID string) error {
+	_, err := r.db.Exec(`UPDATE job_credit_pack SET remaining = 0, revoked_at = NOW() WHERE stripe_session_id = $1 AND revoked_at IS NULL`, sessionID)
+	return err
+}
+
+// Balances returns the credits the recruiter can spend on each plan
+func (r *Repository) Balances(recruiterID string) ([]Balance, error) {
+	rows, err := r.db.Query(
+		`SELECT plan_type, remaining, expires_at FROM job_credit_pack
+		WHERE recruiter_id = $1 AND completed_at IS NOT NULL AND revoked_at IS NULL AND remaining > 0 AND expires_at > NOW()
+		ORDER BY expires_at ASC`,
+		recruiterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byPlan := make(map[string]*Balance)
+	for rows.Next() {
+		var (
+			planType  string
+			remaining int
+			expiresAt time.Time
+		)
+		if err := rows.Scan(&planType, &remaining, &expiresAt); err != nil {
+			return nil, err
+		}
+		b, ok := byPlan[planType]
+		if !ok {
+			b = &Balance{PlanType: planType, NextExpiryAt: expiresAt}
+			byPlan[planType] = b
+		}
+		b.Credits += remaining
+		if expiresAt.Equal(b.NextExpiryAt) {
+			b.NextExpiryCredits += remaining
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	balances := make([]Balance, 0, len(byPlan))
+	for _, planType := range []string{job.JobPlanTypeBasic, job.JobPlanTypePro, job.JobPlanTypePlatinum} {
+		if b, ok := byPlan[planType]; ok {
+			balances = append(balances, *b)
+		}
+	}
+	return balances, nil
+}
+
+// Spend takes the credits for a job off the recruiter's packs for the plan, from the packs expiring
+// soonest first. It returns ErrInsufficientCredits if there aren't enough credits left
+func (r *Repository) Spend(recruiterID, planType string, credits int, jobID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(
+		`SELECT id, remaining FROM job_credit_pack
+		WHERE recruiter_id = $1 AND plan_type = $2 AND completed_at IS NOT NULL AND revoked_at IS NULL AND remaining > 0 AND expires_at > NOW()
+		ORDER BY expires_at ASC
+		FOR UPDATE`,
+		recruiterID,
+		planType,
+	)
+	if err != nil {
+		return err
+	}
+	type packCredits struct {
+		id        string
+		remaining int
+	}
+	packs := make([]packCredits, 0)
+	var available int
+	for rows.Next() {
+		var p packCredits
+		if err := rows.Scan(&p.id, &p.remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		packs = append(packs, p)
+		available += p.remaining
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if available < credits {
+		return ErrInsufficientCredits
+	}
+	left := credits
+	for _, p := range packs {
+		if left == 0 {
+			break
+		}
+		spent := p.remaining
+		if spent > left {
+			spent = left
+		}
+		if _, err := tx.Exec(`UPDATE job_credit_pack SET remaining = remaining - $1 WHERE id = $2`, spent, p.id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO job_credit_spend (job_credit_pack_id, job_id, credits, created_at) VALUES ($1, $2, $3, NOW())`, p.id, jobID, spent); err != nil {
+			return err
+		}
+		left -= spent
+	}
+	return tx.Commit()
+}
+
+// Restore gives back the credits spent on a job that couldn't be published
+func (r *Repository) Restore(jobID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(
+		`UPDATE job_credit_pack p SET remaining = p.remaining + s.credits
+		FROM job_credit_spend s WHERE s.job_credit_pack_id = p.id AND s.job_id = $1`,
+		jobID,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM job_credit_spend WHERE job_id = $1`, jobID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,12 +1,18 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/golang-cafe/job-board/internal/apikey"
 	"github.com/golang-cafe/job-board/internal/gzip"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -118,6 +124,56 @@ func MachineAuthenticatedMiddleware(machineToken string, next http.HandlerFunc)
 	})
 }
 
+type apiKeyContextKey struct{}
+
+// APIKeyAuthenticatedMiddleware authenticates requests carrying an API key in
+// the Authorization (Bearer) or x-api-key header, checks the key has the
+// required scope and applies the per key rate limit
+func APIKeyAuthenticatedMiddleware(keyRepo *apikey.Repository, scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plain := r.Header.Get("x-api-key")
+		if auth := r.Header.Get("Authorization"); plain == "" && strings.HasPrefix(auth, "Bearer ") {
+			plain = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if plain == "" {
+			apiError(w, http.StatusUnauthorized, "missing api key")
+			return
+		}
+		k, err := keyRepo.GetActiveByKey(plain)
+		if err != nil {
+			apiError(w, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+		if !k.HasScope(scope) {
+			apiError(w, http.StatusForbidden, fmt.Sprintf("api key is missing the %s scope", scope))
+			return
+		}
+		ok, retryAfter := keyRepo.Allow(k)
+		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", k.RateLimit))
+		if !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+			apiError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		if err := keyRepo.TrackUsage(k.ID); err != nil {
+			log.Printf("unable to track usage for api key %s: %v", k.ID, err)
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)))
+	})
+}
+
+// GetAPIKeyFromRequest returns the key set by APIKeyAuthenticatedMiddleware
+func GetAPIKeyFromRequest(r *http.Request) (apikey.APIKey, bool) {
+	k, ok := r.Context().Value(apiKeyContextKey{}).(apikey.APIKey)
+	return k, ok
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": msg})
+}
+
 func UserAuthenticatedMiddleware(sessionStore *sessions.CookieStore, jwtKey []byte, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sess, err := sessionStore.Get(r, "____gc")
@@ -0,0 +1,28 @@
+package newsletter
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	CampaignStatusSending   = "sending"
+	CampaignStatusCompleted = "completed"
+
+	// meta keys used to checkpoint the campaign currently being sent
+	MetaKeyCurrentCampaignID = "newsletter_current_campaign_id"
+	MetaKeyLastRecipient     = "newsletter_last_recipient_email"
+)
+
+type Campaign struct {
+	ID             string
+	Subject        string
+	JobIDs         []int64
+	Status         string
+	RecipientCount int
+	SentCount      int
+	FailedCount    int
+	CreatedAt      time.Time
+	CompletedAt    pq.NullTime
+}
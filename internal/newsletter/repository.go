@@ -0,0 +1,138 @@
+package newsletter
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/email"
+	"github.com/lib/pq"
+	"github.com/segmentio/ksuid"
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db}
+}
+
+func (r *Repository) CreateCampaign(subject string, jobIDs []int64, recipientCount int) (Campaign, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return Campaign{}, err
+	}
+	c := Campaign{
+		ID:             id.String(),
+		Subject:        subject,
+		JobIDs:         jobIDs,
+		Status:         CampaignStatusSending,
+		RecipientCount: recipientCount,
+		CreatedAt:      time.Now().UTC(),
+	}
+	_, err = r.db.Exec(
+		`INSERT INTO newsletter_campaign (id, subject, job_ids, status, recipient_count, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		c.ID,
+		c.Subject,
+		pq.Array(c.JobIDs),
+		c.Status,
+		c.RecipientCount,
+		c.CreatedAt,
+	)
+	return c, err
+}
+
+// LastCampaignCreatedAt returns when the latest campaign picked its jobs, the zero time if there
+// was no campaign yet
+func (r *Repository) LastCampaignCreatedAt() (time.Time, error) {
+	var createdAt pq.NullTime
+	err := r.db.QueryRow(`SELECT MAX(created_at) FROM newsletter_campaign`).Scan(&createdAt)
+	return createdAt.Time, err
+}
+
+func (r *Repository) GetCampaign(id string) (Campaign, error) {
+	c := Campaign{}
+	row := r.db.QueryRow(`SELECT id, subject, job_ids, status, recipient_count, sent_count, failed_count, created_at, completed_at FROM newsletter_campaign WHERE id = $1`, id)
+	err := row.Scan(&c.ID, &c.Subject, pq.Array(&c.JobIDs), &c.Status, &c.RecipientCount, &c.SentCount, &c.FailedCount, &c.CreatedAt, &c.CompletedAt)
+	return c, err
+}
+
+// UpdateCampaignProgress records the outcome of the campaign emails the outbox has finished with and
+// recomputes the sent and failed counters from the send log
+func (r *Repository) UpdateCampaignProgress(id string) error {
+	_, err := r.db.Exec(`UPDATE newsletter_send_log l SET
+		delivered_at = CASE WHEN o.status = $2 THEN o.sent_at END,
+		error = CASE WHEN o.status = $3 THEN COALESCE(o.last_error, 'undelivered') END
+		FROM email_outbox o
+		WHERE o.id = l.email_outbox_id AND l.campaign_id = $1 AND l.delivered_at IS NULL AND l.error IS NULL AND o.status IN ($2, $3)`,
+		id,
+		email.OutboxStatusSent,
+		email.OutboxStatusDead,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`UPDATE newsletter_campaign SET
+		sent_count = (SELECT COUNT(*) FROM newsletter_send_log WHERE campaign_id = $1 AND delivered_at IS NOT NULL),
+		failed_count = (SELECT COUNT(*) FROM newsletter_send_log WHERE campaign_id = $1 AND error IS NOT NULL)
+		WHERE id = $1`, id)
+	return err
+}
+
+// CampaignsPendingDelivery returns the campaigns with emails still waiting in the outbox
+func (r *Repository) CampaignsPendingDelivery() ([]string, error) {
+	rows, err := r.db.Query(`SELECT DISTINCT campaign_id FROM newsletter_send_log WHERE email_outbox_id IS NOT NULL AND delivered_at IS NULL AND error IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *Repository) CompleteCampaign(id string) error {
+	_, err := r.db.Exec(`UPDATE newsletter_campaign SET status = $1, completed_at = NOW() WHERE id = $2`, CampaignStatusCompleted, id)
+	return err
+}
+
+// WasSent reports whether the campaign email to the given address was delivered or is still in
+// the outbox, emails dead-lettered by the outbox count as not sent
+func (r *Repository) WasSent(campaignID, address string) (bool, error) {
+	var sent bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS(
+			SELECT 1 FROM newsletter_send_log l LEFT JOIN email_outbox o ON o.id = l.email_outbox_id
+			WHERE l.campaign_id = $1 AND l.email = $2 AND l.error IS NULL AND (o.status IS NULL OR o.status != $3)
+		)`,
+		campaignID,
+		address,
+		email.OutboxStatusDead,
+	).Scan(&sent)
+	return sent, err
+}
+
+// LogSend records the email sent to a recipient with the ID of its outbox message, sendErr is nil
+// on success. Emails sent without an outbox are delivered straight away
+func (r *Repository) LogSend(campaignID, address, outboxID string, sendErr error) error {
+	var errStr sql.NullString
+	if sendErr != nil {
+		errStr = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+	_, err := r.db.Exec(
+		`INSERT INTO newsletter_send_log (campaign_id, email, sent_at, error, email_outbox_id, delivered_at)
+		VALUES ($1, $2, NOW(), $3, NULLIF($4, ''), CASE WHEN $3::text IS NULL AND $4 = '' THEN NOW() END)
+		ON CONFLICT (campaign_id, email) DO UPDATE SET sent_at = EXCLUDED.sent_at, error = EXCLUDED.error, email_outbox_id = EXCLUDED.email_outbox_id, delivered_at = EXCLUDED.delivered_at`,
+		campaignID,
+		address,
+		errStr,
+		outboxID,
+	)
+	return err
+}
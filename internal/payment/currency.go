@@ -0,0 +1,97 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/golang-cafe/job-board/internal/job"
+)
+
+// BaseCurrency is the currency of the configured plan prices, revenue is reported in it
+const BaseCurrency = "USD"
+
+var ErrCurrencyNotAvailable = errors.New("job ads can't be paid in this currency")
+
+// zeroDecimalCurrencies are charged by stripe in whole units rather than cents
+var zeroDecimalCurrencies = map[string]bool{"JPY": true, "KRW": true}
+
+// MinorUnits returns how many of the smallest unit stripe charges in make one unit of the currency
+func MinorUnits(currency string) int64 {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return 1
+	}
+	return 100
+}
+
+// ConvertAmount converts an amount in the minor unit of from to the minor unit of to, rate is the
+// units of to per unit of from
+func ConvertAmount(amount int64, from, to string, rate float64) int64 {
+	units := float64(amount) / float64(MinorUnits(from)) * rate
+	return int64(math.Round(units * float64(MinorUnits(to))))
+}
+
+// RoundPrice rounds a converted price up to a whole unit of the currency, and prices of 100 units
+// or more up to two significant digits so they read like list prices, e.g. 183.40 to 190
+func RoundPrice(amount int64, currency string) int64 {
+	minor := MinorUnits(currency)
+	units := (amount + minor - 1) / minor
+	step := int64(1)
+	for u := units; u >= 100; u /= 10 {
+		step *= 10
+	}
+	return (units + step - 1) / step * step * minor
+}
+
+// FormatAmount formats an amount in the currency's minor unit with its currency code, e.g. USD 177.00
+func FormatAmount(amount int64, currency string) string {
+	currency = strings.ToUpper(currency)
+	minor := MinorUnits(currency)
+	if minor == 1 {
+		return fmt.Sprintf("%s %s", currency, humanize.Comma(amount))
+	}
+	return fmt.Sprintf("%s %s.%02d", currency, humanize.Comma(amount/minor), amount%minor)
+}
+
+// PlanPrices are the monthly prices of the job ad plans in the minor unit of Currency
+type PlanPrices struct {
+	Currency string
+	Basic    int64
+	Pro      int64
+	Platinum int64
+	FXRate   float64 // units of Currency per unit of BaseCurrency
+}
+
+func (p PlanPrices) Monthly(planType string) int64 {
+	switch planType {
+	case job.JobPlanTypeBasic:
+		return p.Basic
+	case job.JobPlanTypePro:
+		return p.Pro
+	case job.JobPlanTypePlatinum:
+		return p.Platinum
+	}
+	return 0
+}
+
+func (p PlanPrices) MinorUnits() int64 {
+	return MinorUnits(p.Currency)
+}
+
+// ToBase converts an amount in Currency to BaseCurrency for reporting
+func (p PlanPrices) ToBase(amount int64) int64 {
+	if p.Currency == BaseCurrency {
+		return amount
+	}
+	return ConvertAmount(amount, p.Currency, BaseCurrency, 1/p.FXRate)
+}
+
+// FromBase converts an amount in BaseCurrency to Currency
+func (p PlanPrices) FromBase(amount int64) int64 {
+	if p.Currency == BaseCurrency {
+		return amount
+	}
+	return ConvertAmount(amount, BaseCurrency, p.Currency, p.FXRate)
+}
@@ -1,14 +1,13 @@
 package payment
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/golang-cafe/job-board/internal/job"
 
 	stripe "github.com/stripe/stripe-go"
+	charge "github.com/stripe/stripe-go/charge"
 	session "github.com/stripe/stripe-go/checkout/session"
-	webhook "github.com/stripe/stripe-go/webhook"
 
 	"strings"
 )
@@ -83,25 +82,34 @@ func (r Repository) CreateGenericSession(email, currency string, amount int) (*s
 	return session, nil
 }
 
-func (r Repository) CreateJobAdSession(jobRq *job.JobRq, jobToken string, monthlyAmount int64, numMonths int64) (*stripe.CheckoutSession, error) {
+// CreateJobAdSession starts the checkout of a job ad plan in the currency of jobRq, amounts are in its
+// minor unit. The discount of the promo code in jobRq is taken off the price of all months
+func (r Repository) CreateJobAdSession(jobRq *job.JobRq, jobToken string, monthlyAmount int64, numMonths int64, discountAmount int64) (*stripe.CheckoutSession, error) {
 	stripe.Key = r.stripeKey
+	lineItem := &stripe.CheckoutSessionLineItemParams{
+		Name:     stripe.String(fmt.Sprintf("%s Job Ad %s Plan", r.siteName, strings.Title(jobRq.PlanType))),
+		Amount:   stripe.Int64(monthlyAmount),
+		Currency: stripe.String(strings.ToLower(jobRq.CurrencyCode)),
+		Quantity: stripe.Int64(numMonths),
+	}
+	if discountAmount > 0 {
+		lineItem.Name = stripe.String(fmt.Sprintf("%s Job Ad %s Plan x %d months (promo code %s)", r.siteName, strings.Title(jobRq.PlanType), numMonths, jobRq.PromoCode))
+		lineItem.Amount = stripe.Int64(monthlyAmount*numMonths - discountAmount)
+		lineItem.Quantity = stripe.Int64(1)
+	}
 	params := &stripe.CheckoutSessionParams{
 		BillingAddressCollection: stripe.String("required"),
 		PaymentMethodTypes: stripe.StringSlice([]string{
 			"card",
 		}),
-		LineItems: []*stripe.CheckoutSessionLineItemParams{
-			{
-				Name:     stripe.String(fmt.Sprintf("%s Job Ad %s Plan", r.siteName, strings.Title(jobRq.PlanType))),
-				Amount:   stripe.Int64(monthlyAmount),
-				Currency: stripe.String("usd"),
-				Quantity: stripe.Int64(numMonths),
-			},
-		},
+		LineItems: []*stripe.CheckoutSessionLineItemParams{lineItem},
 		SuccessURL:    stripe.String(fmt.Sprintf("%s%s/edit/%s?payment=1&callback=1", r.siteProtocol, r.siteHost, jobToken)),
 		CancelURL:     stripe.String(fmt.Sprintf("%s%s/edit/%s?payment=0&callback=1", r.siteProtocol, r.siteHost, jobToken)),
 		CustomerEmail: &jobRq.Email,
 	}
+	// the company name, address and VAT ID entered at checkout are printed on the invoice
+	params.AddExtra("customer_creation", "always")
+	params.AddExtra("tax_id_collection[enabled]", "true")
 
 	session, err := session.New(params)
 	if err != nil {
@@ -111,14 +119,14 @@ func (r Repository) CreateJobAdSession(jobRq *job.JobRq, jobToken string, monthl
 	return session, nil
 }
 
-func (r Repository) CreateDevDirectorySession(email string, userID string, monthlyAmount int64, numMonths int64, isRenew bool) (*stripe.CheckoutSession, error) {
+// JobCreditPackDescription describes a pack of credits for a plan, e.g. 10 Pro Plan Job Credits
+func JobCreditPackDescription(planType string, credits int) string {
+	return fmt.Sprintf("%d %s Plan Job Credits", credits, strings.Title(planType))
+}
+
+// CreateJobCreditPackSession starts the checkout of a pack of job credits in US dollars for the recruiter
+func (r Repository) CreateJobCreditPackSession(email, recruiterID, planType string, credits int, amount int64) (*stripe.CheckoutSession, error) {
 	stripe.Key = r.stripeKey
-	successURL := stripe.String(fmt.Sprintf("%s%s/auth?payment=1&email=%s", r.siteProtocol, r.siteHost, email))
-	cancelURL := stripe.String(fmt.Sprintf("%s%s/auth?payment=0&email=%s", r.siteProtocol, r.siteHost, email))
-	if isRenew {
-		successURL = stripe.String(fmt.Sprintf("%s%s/profile/home?payment=1", r.siteProtocol, r.siteHost))
-		cancelURL = stripe.String(fmt.Sprintf("%s%s/profile/home?payment=0", r.siteProtocol, r.siteHost))
-	}
 	params := &stripe.CheckoutSessionParams{
 		BillingAddressCollection: stripe.String("required"),
 		PaymentMethodTypes: stripe.StringSlice([]string{
@@ -126,16 +134,20 @@ func (r Repository) CreateDevDirectorySession(email string, userID string, month
 		}),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
-				Name:     stripe.String(fmt.Sprintf("%s Developer Directory %d Months Plan", r.siteName, numMonths)),
-				Amount:   stripe.Int64(monthlyAmount),
-				Currency: stripe.String("usd"),
-				Quantity: stripe.Int64(numMonths),
+				Name:     stripe.String(fmt.Sprintf("%s %s", r.siteName, JobCreditPackDescription(planType, credits))),
+				Amount:   stripe.Int64(amount),
+				Currency: stripe.String(strings.ToLower(BaseCurrency)),
+				Quantity: stripe.Int64(1),
 			},
 		},
-		SuccessURL:    successURL,
-		CancelURL:     cancelURL,
-		CustomerEmail: &email,
+		ClientReferenceID: stripe.String(recruiterID),
+		SuccessURL:        stripe.String(fmt.Sprintf("%s%s/profile/jobs?credits=1", r.siteProtocol, r.siteHost)),
+		CancelURL:         stripe.String(fmt.Sprintf("%s%s/profile/jobs?credits=0", r.siteProtocol, r.siteHost)),
+		CustomerEmail:     stripe.String(email),
 	}
+	// the company name, address and VAT ID entered at checkout are printed on the invoice
+	params.AddExtra("customer_creation", "always")
+	params.AddExtra("tax_id_collection[enabled]", "true")
 
 	session, err := session.New(params)
 	if err != nil {
@@ -145,19 +157,101 @@ func (r Repository) CreateDevDirectorySession(email string, userID string, month
 	return session, nil
 }
 
-func HandleCheckoutSessionComplete(body []byte, endpointSecret, stripeSig string) (*stripe.CheckoutSession, error) {
-	event, err := webhook.ConstructEvent(body, stripeSig, endpointSecret)
-	if err != nil {
-		return nil, fmt.Errorf("error verifying webhook signature: %v\n", err)
-	}
+func HandleCheckoutSessionComplete(event stripe.Event) (*stripe.CheckoutSession, error) {
 	// Handle the checkout.session.completed event
-	if event.Type == "checkout.session.completed" {
+	if event.Type == EventCheckoutSessionCompleted {
 		var session stripe.CheckoutSession
-		err := json.Unmarshal(event.Data.Raw, &session)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing webhook JSON: %v\n", err)
+		if err := DecodeEventObject(event, &session); err != nil {
+			return nil, err
 		}
 		return &session, nil
 	}
 	return nil, nil
 }
+
+// GetCharge retrieves a charge, e.g. the one a dispute was opened against
+func (r Repository) GetCharge(id string) (*stripe.Charge, error) {
+	stripe.Key = r.stripeKey
+	ch, err := charge.Get(id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve stripe charge %s: %+v", id, err)
+	}
+	return ch, nil
+}
+
+type checkoutSessionListParams struct {
+	stripe.Params `form:"*"`
+	PaymentIntent *string `form:"payment_intent"`
+}
+
+type checkoutSessionList struct {
+	Data []*stripe.CheckoutSession `json:"data"`
+}
+
+// CheckoutSessionIDForPaymentIntent looks up the checkout session that created the payment intent,
+// for purchases completed before payment intents were stored alongside them
+func (r Repository) CheckoutSessionIDForPaymentIntent(paymentIntentID string) (string, error) {
+	params := &checkoutSessionListParams{PaymentIntent: stripe.String(paymentIntentID)}
+	list := &checkoutSessionList{}
+	if err := stripe.GetBackend(stripe.APIBackend).Call("GET", "/v1/checkout/sessions", r.stripeKey, params, list); err != nil {
+		return "", fmt.Errorf("unable to list stripe checkout sessions for payment intent %s: %+v", paymentIntentID, err)
+	}
+	if len(list.Data) == 0 {
+		return "", nil
+	}
+	return list.Data[0].ID, nil
+}
+
+// CheckoutBilling are the billing details entered on the checkout page and the amount paid
+type CheckoutBilling struct {
+	Name        string
+	Address     string // one address line per line
+	VATID       string
+	AmountTotal int64
+	Currency    string
+}
+
+type checkoutSessionBilling struct {
+	AmountTotal     int64  `json:"amount_total"`
+	Currency        string `json:"currency"`
+	CustomerDetails *struct {
+		Name    string          `json:"name"`
+		Address *stripe.Address `json:"address"`
+		TaxIDs  []struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"tax_ids"`
+	} `json:"customer_details"`
+}
+
+// DecodeCheckoutBilling reads the billing details of a checkout.session.completed event, the stripe
+// library predates the customer details on checkout sessions
+func DecodeCheckoutBilling(event stripe.Event) (CheckoutBilling, error) {
+	var sess checkoutSessionBilling
+	if err := DecodeEventObject(event, &sess); err != nil {
+		return CheckoutBilling{}, err
+	}
+	billing := CheckoutBilling{
+		AmountTotal: sess.AmountTotal,
+		Currency:    sess.Currency,
+	}
+	if sess.CustomerDetails == nil {
+		return billing, nil
+	}
+	billing.Name = sess.CustomerDetails.Name
+	if a := sess.CustomerDetails.Address; a != nil {
+		lines := make([]string, 0, 5)
+		for _, l := range []string{a.Line1, a.Line2, strings.TrimSpace(a.PostalCode + " " + a.City), a.State, a.Country} {
+			if l != "" {
+				lines = append(lines, l)
+			}
+		}
+		billing.Address = strings.Join(lines, "\n")
+	}
+	vatIDs := make([]string, 0, len(sess.CustomerDetails.TaxIDs))
+	for _, id := range sess.CustomerDetails.TaxIDs {
+		vatIDs = append(vatIDs, id.Value)
+	}
+	billing.VATID = strings.Join(vatIDs, ", ")
+	return billing, nil
+}
@@ -0,0 +1,167 @@
+package payment
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	stripe "github.com/stripe/stripe-go"
+	session "github.com/stripe/stripe-go/checkout/session"
+	plan "github.com/stripe/stripe-go/plan"
+)
+
+const (
+	SubscriptionIntervalMonth = string(stripe.PlanIntervalMonth)
+	SubscriptionIntervalYear  = string(stripe.PlanIntervalYear)
+
+	devDirectoryPlanPrefix = "dev-directory-"
+)
+
+type billingPortalSessionParams struct {
+	stripe.Params `form:"*"`
+	Customer      *string `form:"customer"`
+	ReturnURL     *string `form:"return_url"`
+}
+
+type billingPortalSession struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// DevDirectoryInvoicePeriod returns the subscription interval and the end of the period paid by a
+// developer directory subscription invoice. ok is false for invoices of any other product
+func DevDirectoryInvoicePeriod(inv stripe.Invoice) (interval string, periodEnd time.Time, ok bool) {
+	if inv.Lines == nil {
+		return "", time.Time{}, false
+	}
+	for _, line := range inv.Lines.Data {
+		if line.Plan == nil || !strings.HasPrefix(line.Plan.ID, devDirectoryPlanPrefix) || line.Period == nil {
+			continue
+		}
+		end := time.Unix(line.Period.End, 0).UTC()
+		if end.After(periodEnd) {
+			interval, periodEnd, ok = string(line.Plan.Interval), end, true
+		}
+	}
+	return interval, periodEnd, ok
+}
+
+// IsDevDirectorySubscription reports whether the subscription is for developer directory access
+func IsDevDirectorySubscription(sub stripe.Subscription) bool {
+	if sub.Plan != nil && strings.HasPrefix(sub.Plan.ID, devDirectoryPlanPrefix) {
+		return true
+	}
+	if sub.Items == nil {
+		return false
+	}
+	for _, item := range sub.Items.Data {
+		if item.Plan != nil && strings.HasPrefix(item.Plan.ID, devDirectoryPlanPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureDevDirectoryPlan returns the stripe plan billing amount cents every interval, creating it
+// the first time the price is used. Plan IDs are derived from the interval and amount so a price
+// change in the config creates a new plan and leaves existing subscriptions on the old one
+func (r Repository) ensureDevDirectoryPlan(interval string, amount int64) (string, error) {
+	id := fmt.Sprintf("%s%s-%d-usd", devDirectoryPlanPrefix, interval, amount)
+	p, err := plan.Get(id, nil)
+	if err == nil {
+		return p.ID, nil
+	}
+	if stripeErr, ok := err.(*stripe.Error); !ok || stripeErr.Code != stripe.ErrorCodeResourceMissing {
+		return "", fmt.Errorf("unable to retrieve stripe plan %s: %+v", id, err)
+	}
+	p, err = plan.New(&stripe.PlanParams{
+		ID:       stripe.String(id),
+		Amount:   stripe.Int64(amount),
+		Currency: stripe.String("usd"),
+		Interval: stripe.String(interval),
+		Product: &stripe.PlanProductParams{
+			Name: stripe.String(fmt.Sprintf("%s Developer Directory", r.siteName)),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to create stripe plan %s: %+v", id, err)
+	}
+	return p.ID, nil
+}
+
+// CreateDevDirectorySubscriptionSession starts a checkout for a recurring developer directory subscription.
+// customerID is the recruiter's existing stripe customer, if any, so the subscription lands on the same
+// customer and billing portal
+func (r Repository) CreateDevDirectorySubscriptionSession(email, recruiterID, customerID, interval string, amount int64, isRenew bool) (*stripe.CheckoutSession, error) {
+	stripe.Key = r.stripeKey
+	if interval != SubscriptionIntervalMonth && interval != SubscriptionIntervalYear {
+		return nil, fmt.Errorf("invalid subscription interval %q", interval)
+	}
+	planID, err := r.ensureDevDirectoryPlan(interval, amount)
+	if err != nil {
+		return nil, err
+	}
+	successURL := stripe.String(fmt.Sprintf("%s%s/auth?payment=1&email=%s", r.siteProtocol, r.siteHost, email))
+	cancelURL := stripe.String(fmt.Sprintf("%s%s/auth?payment=0&email=%s", r.siteProtocol, r.siteHost, email))
+	if isRenew {
+		successURL = stripe.String(fmt.Sprintf("%s%s/profile/home?payment=1", r.siteProtocol, r.siteHost))
+		cancelURL = stripe.String(fmt.Sprintf("%s%s/profile/home?payment=0", r.siteProtocol, r.siteHost))
+	}
+	params := &stripe.CheckoutSessionParams{
+		BillingAddressCollection: stripe.String("required"),
+		PaymentMethodTypes: stripe.StringSlice([]string{
+			"card",
+		}),
+		Mode:              stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		ClientReferenceID: stripe.String(recruiterID),
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Items: []*stripe.CheckoutSessionSubscriptionDataItemsParams{
+				{
+					Plan:     stripe.String(planID),
+					Quantity: stripe.Int64(1),
+				},
+			},
+		},
+		SuccessURL: successURL,
+		CancelURL:  cancelURL,
+	}
+	if customerID != "" {
+		params.Customer = stripe.String(customerID)
+	} else {
+		params.CustomerEmail = stripe.String(email)
+	}
+
+	session, err := session.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create stripe session: %+v", err)
+	}
+
+	return session, nil
+}
+
+// CreateBillingPortalSession returns the URL of a stripe customer portal session where the customer can
+// update the payment method, download invoices and cancel the subscription
+func (r Repository) CreateBillingPortalSession(customerID, returnURL string) (string, error) {
+	params := &billingPortalSessionParams{
+		Customer:  stripe.String(customerID),
+		ReturnURL: stripe.String(returnURL),
+	}
+	sess := &billingPortalSession{}
+	if err := stripe.GetBackend(stripe.APIBackend).Call("POST", "/v1/billing_portal/sessions", r.stripeKey, params, sess); err != nil {
+		return "", fmt.Errorf("unable to create stripe billing portal session: %+v", err)
+	}
+	return sess.URL, nil
+}
+
+// DevDirectorySubscriptionAmount returns the amount in cents billed every interval. Annual subscriptions
+// are billed at the 12 months plan monthly price
+func DevDirectorySubscriptionAmount(interval string, monthlyPrice, annualMonthlyPrice int64) int64 {
+	switch interval {
+	case SubscriptionIntervalMonth:
+		return monthlyPrice
+	case SubscriptionIntervalYear:
+		return annualMonthlyPrice * 12
+	}
+
+	return 0
+}
@@ -0,0 +1,187 @@
+package payment
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	stripe "github.com/stripe/stripe-go"
+	webhook "github.com/stripe/stripe-go/webhook"
+)
+
+const (
+	EventCheckoutSessionCompleted = "checkout.session.completed"
+	EventInvoicePaid              = "invoice.paid"
+	EventInvoicePaymentFailed     = "invoice.payment_failed"
+	EventSubscriptionDeleted      = "customer.subscription.deleted"
+	EventChargeRefunded           = "charge.refunded"
+	EventChargeDisputeCreated     = "charge.dispute.created"
+)
+
+const (
+	WebhookEventStatusPending    = "pending"
+	WebhookEventStatusProcessing = "processing"
+	WebhookEventStatusProcessed  = "processed"
+	WebhookEventStatusIgnored    = "ignored"
+	WebhookEventStatusFailed     = "failed"
+
+	// webhookEventClaimTimeout is how long a claimed event is hidden from concurrent deliveries,
+	// after that a crashed processing attempt can be picked up again
+	webhookEventClaimTimeout = 5 * time.Minute
+)
+
+// ConstructEvent verifies the webhook signature and returns the stripe event
+func ConstructEvent(body []byte, endpointSecret, stripeSig string) (stripe.Event, error) {
+	event, err := webhook.ConstructEvent(body, stripeSig, endpointSecret)
+	if err != nil {
+		return event, fmt.Errorf("error verifying webhook signature: %v", err)
+	}
+	return event, nil
+}
+
+// DecodeEventObject unmarshals the object the event refers to, e.g. a stripe.Invoice for invoice events
+func DecodeEventObject(event stripe.Event, v interface{}) error {
+	if err := json.Unmarshal(event.Data.Raw, v); err != nil {
+		return fmt.Errorf("error parsing webhook JSON: %v", err)
+	}
+	return nil
+}
+
+// WebhookEvent is a stripe webhook event as received, with the outcome of processing it
+type WebhookEvent struct {
+	ID          string
+	Type        string
+	Payload     []byte
+	Status      string
+	Attempts    int
+	LastError   string
+	CreatedAt   time.Time
+	ProcessedAt pq.NullTime
+}
+
+type WebhookEventStats struct {
+	Pending    int
+	Processing int
+	Processed  int
+	Ignored    int
+	Failed     int
+}
+
+// Event decodes the stored payload
+func (e WebhookEvent) Event() (stripe.Event, error) {
+	var event stripe.Event
+	err := json.Unmarshal(e.Payload, &event)
+	return event, err
+}
+
+// EventRepository stores every stripe webhook event received so each one is processed exactly once,
+// even when stripe delivers it more than once, and failed events can be replayed
+type EventRepository struct {
+	db *sql.DB
+}
+
+func NewEventRepository(db *sql.DB) *EventRepository {
+	return &EventRepository{db}
+}
+
+// Record stores the event unless it has been received before
+func (r *EventRepository) Record(event stripe.Event, payload []byte) error {
+	_, err := r.db.Exec(
+		`INSERT INTO stripe_webhook_event (id, type, payload, status, attempts, created_at) VALUES ($1, $2, $3, $4, 0, NOW()) ON CONFLICT (id) DO NOTHING`,
+		event.ID,
+		event.Type,
+		payload,
+		WebhookEventStatusPending,
+	)
+	return err
+}
+
+// Claim marks the event as being processed if its status is one of from, or if a previous attempt
+// was claimed more than webhookEventClaimTimeout ago and never completed. It returns false when
+// the event is not up for processing, e.g. a duplicate delivery of an event already processed
+func (r *EventRepository) Claim(id string, from ...string) (bool, error) {
+	var claimed string
+	err := r.db.QueryRow(
+		`UPDATE stripe_webhook_event SET status = $1, attempts = attempts + 1, claimed_at = NOW()
+		WHERE id = $2 AND (status = ANY($3) OR (status = $1 AND claimed_at < $4))
+		RETURNING id`,
+		WebhookEventStatusProcessing,
+		id,
+		pq.Array(from),
+		time.Now().UTC().Add(-webhookEventClaimTimeout),
+	).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Complete records the outcome of the last attempt at processing a claimed event, status is one of
+// processed, ignored or failed
+func (r *EventRepository) Complete(id, status string, processErr error) error {
+	var lastError sql.NullString
+	if processErr != nil {
+		lastError = sql.NullString{String: processErr.Error(), Valid: true}
+	}
+	_, err := r.db.Exec(
+		`UPDATE stripe_webhook_event SET status = $1, last_error = $2, processed_at = NOW() WHERE id = $3`,
+		status,
+		lastError,
+		id,
+	)
+	return err
+}
+
+func (r *EventRepository) Get(id string) (WebhookEvent, error) {
+	var e WebhookEvent
+	err := r.db.QueryRow(
+		`SELECT id, type, payload, status, attempts, COALESCE(last_error, ''), created_at, processed_at FROM stripe_webhook_event WHERE id = $1`,
+		id,
+	).Scan(&e.ID, &e.Type, &e.Payload, &e.Status, &e.Attempts, &e.LastError, &e.CreatedAt, &e.ProcessedAt)
+	return e, err
+}
+
+func (r *EventRepository) ListByStatus(status string, limit int) ([]WebhookEvent, error) {
+	rows, err := r.db.Query(
+		`SELECT id, type, status, attempts, COALESCE(last_error, ''), created_at, processed_at FROM stripe_webhook_event WHERE status = $1 ORDER BY created_at DESC LIMIT $2`,
+		status,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]WebhookEvent, 0)
+	for rows.Next() {
+		var e WebhookEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.Status, &e.Attempts, &e.LastError, &e.CreatedAt, &e.ProcessedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r *EventRepository) Stats() (WebhookEventStats, error) {
+	var stats WebhookEventStats
+	err := r.db.QueryRow(
+		`SELECT
+			COUNT(*) FILTER (WHERE status = $1),
+			COUNT(*) FILTER (WHERE status = $2),
+			COUNT(*) FILTER (WHERE status = $3),
+			COUNT(*) FILTER (WHERE status = $4),
+			COUNT(*) FILTER (WHERE status = $5)
+		FROM stripe_webhook_event`,
+		WebhookEventStatusPending,
+		WebhookEventStatusProcessing,
+		WebhookEventStatusProcessed,
+		WebhookEventStatusIgnored,
+		WebhookEventStatusFailed,
+	).Scan(&stats.Pending, &stats.Processing, &stats.Processed, &stats.Ignored, &stats.Failed)
+	return stats, err
+}
@@ -0,0 +1,117 @@
+package promocode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DiscountTypePercent = "percent"
+	DiscountTypeFixed   = "fixed"
+
+	// MinChargeAmount is roughly the lowest amount in US cents stripe accepts for a checkout, a
+	// discount can't bring the price below it
+	MinChargeAmount = 50
+)
+
+var (
+	ErrNotFound         = errors.New("this promo code does not exist")
+	ErrExpired          = errors.New("this promo code has expired")
+	ErrFullyRedeemed    = errors.New("this promo code has already been fully redeemed")
+	ErrPlanNotEligible  = errors.New("this promo code is not valid for the chosen plan")
+	ErrDiscountTooLarge = errors.New("this promo code can't be applied to the chosen plan")
+)
+
+// IsRedeemError reports whether err explains why a code can't be redeemed, as opposed to a failure
+// looking it up
+func IsRedeemError(err error) bool {
+	switch err {
+	case ErrNotFound, ErrExpired, ErrFullyRedeemed, ErrPlanNotEligible, ErrDiscountTooLarge:
+		return true
+	}
+	return false
+}
+
+// PromoCode is a discount on job ad plans created by an admin. Redemptions are the completed
+// purchases the code was used for
+type PromoCode struct {
+	ID             string
+	Code           string
+	DiscountType   string
+	DiscountValue  int      // percentage off, or US cents off for fixed discounts
+	PlanTypes      []string // plans the code applies to, any plan when empty
+	MaxRedemptions int      // 0 for unlimited redemptions
+	Redemptions    int
+	ExpiresAt      pq.NullTime
+	DisabledAt     pq.NullTime
+	CreatedAt      time.Time
+}
+
+// NormalizeCode makes codes case insensitive
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks the code can be redeemed now for the plan type, or for any plan if planType is empty
+func (p PromoCode) Validate(planType string, now time.Time) error {
+	if p.DisabledAt.Valid {
+		return ErrNotFound
+	}
+	if p.ExpiresAt.Valid && !now.Before(p.ExpiresAt.Time) {
+		return ErrExpired
+	}
+	if p.MaxRedemptions > 0 && p.Redemptions >= p.MaxRedemptions {
+		return ErrFullyRedeemed
+	}
+	if planType == "" || len(p.PlanTypes) == 0 {
+		return nil
+	}
+	for _, t := range p.PlanTypes {
+		if t == planType {
+			return nil
+		}
+	}
+	return ErrPlanNotEligible
+}
+
+// Discount returns the amount taken off amount, fromUSD converts US cents to the currency of amount
+// for fixed discounts
+func (p PromoCode) Discount(amount int64, fromUSD func(int64) int64) int64 {
+	var discount int64
+	switch p.DiscountType {
+	case DiscountTypePercent:
+		discount = amount * int64(p.DiscountValue) / 100
+	case DiscountTypeFixed:
+		discount = fromUSD(int64(p.DiscountValue))
+	}
+	if discount > amount {
+		return amount
+	}
+	return discount
+}
+
+// Apply returns the discounted amount and the discount, ErrDiscountTooLarge if it's below what stripe
+// can charge. fromUSD converts US cents to the currency of amount
+func (p PromoCode) Apply(amount int64, fromUSD func(int64) int64) (int64, int64, error) {
+	discount := p.Discount(amount, fromUSD)
+	if amount-discount < fromUSD(MinChargeAmount) {
+		return amount, 0, ErrDiscountTooLarge
+	}
+	return amount - discount, discount, nil
+}
+
+// DiscountString describes the discount, e.g. 20% off or US$10.00 off
+func (p PromoCode) DiscountString() string {
+	if p.DiscountType == DiscountTypePercent {
+		return fmt.Sprintf("%d%% off", p.DiscountValue)
+	}
+	return fmt.Sprintf("US$%d.%02d off", p.DiscountValue/100, p.DiscountValue%100)
+}
+
+func (p PromoCode) IsActive(now time.Time) bool {
+	return p.Validate("", now) == nil
+}
@@ -0,0 +1,110 @@
+package promocode
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/segmentio/ksuid"
+)
+
+const promoCodeColumns = `p.id, p.code, p.discount_type, p.discount_value, p.plan_types, p.max_redemptions,
+	(SELECT COUNT(*) FROM purchase_event pe WHERE pe.promo_code_id = p.id AND pe.completed_at IS NOT NULL),
+	p.expires_at, p.disabled_at, p.created_at`
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db}
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPromoCode(row scanner) (PromoCode, error) {
+	var p PromoCode
+	err := row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.DiscountType,
+		&p.DiscountValue,
+		pq.Array(&p.PlanTypes),
+		&p.MaxRedemptions,
+		&p.Redemptions,
+		&p.ExpiresAt,
+		&p.DisabledAt,
+		&p.CreatedAt,
+	)
+	return p, err
+}
+
+func (r *Repository) Create(p PromoCode) (PromoCode, error) {
+	k, err := ksuid.NewRandom()
+	if err != nil {
+		return p, err
+	}
+	p.ID = k.String()
+	p.Code = NormalizeCode(p.Code)
+	p.CreatedAt = time.Now().UTC()
+	if p.PlanTypes == nil {
+		p.PlanTypes = []string{}
+	}
+	_, err = r.db.Exec(
+		`INSERT INTO promo_code (id, code, discount_type, discount_value, plan_types, max_redemptions, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		p.ID,
+		p.Code,
+		p.DiscountType,
+		p.DiscountValue,
+		pq.Array(p.PlanTypes),
+		p.MaxRedemptions,
+		p.ExpiresAt,
+		p.CreatedAt,
+	)
+	return p, err
+}
+
+// ByCode returns the promo code, ErrNotFound if there is no such code
+func (r *Repository) ByCode(code string) (PromoCode, error) {
+	p, err := scanPromoCode(r.db.QueryRow(`SELECT `+promoCodeColumns+` FROM promo_code p WHERE p.code = $1`, NormalizeCode(code)))
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+func (r *Repository) List() ([]PromoCode, error) {
+	rows, err := r.db.Query(`SELECT ` + promoCodeColumns + ` FROM promo_code p ORDER BY p.created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	codes := make([]PromoCode, 0)
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, p)
+	}
+	return codes, rows.Err()
+}
+
+// Disable stops the code from being redeemed, it returns sql.ErrNoRows if the code doesn't exist or
+// is disabled already
+func (r *Repository) Disable(id string) error {
+	res, err := r.db.Exec(`UPDATE promo_code SET disabled_at = $1 WHERE id = $2 AND disabled_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
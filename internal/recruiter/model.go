@@ -1,9 +1,16 @@
 package recruiter
 
 import (
+	"database/sql"
 	"time"
 )
 
+const (
+	SubscriptionStatusActive   = "active"
+	SubscriptionStatusPastDue  = "past_due"
+	SubscriptionStatusCanceled = "canceled"
+)
+
 type Recruiter struct {
 	ID         string
 	Name       string
@@ -15,4 +22,15 @@ type Recruiter struct {
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 	PlanExpiredAt time.Time
+
+	StripeCustomerID      string
+	StripeSubscriptionID  string
+	SubscriptionStatus    string
+	SubscriptionInterval  string
+	SubscriptionPeriodEnd sql.NullTime
+}
+
+// HasSubscription reports whether developer directory access renews automatically
+func (r Recruiter) HasSubscription() bool {
+	return r.StripeSubscriptionID != "" && r.SubscriptionStatus != SubscriptionStatusCanceled
 }
@@ -61,8 +61,10 @@ func (r *Repository) ActivateRecruiterProfile(email string) error {
 	return err
 }
 
-func (r *Repository) RecruiterProfileByEmail(email string) (Recruiter, error) {
-	row := r.db.QueryRow(`SELECT id, email, name, company_url, slug, created_at, updated_at, plan_expired_at FROM recruiter_profile WHERE email = $1`, email)
+const recruiterProfileColumns = `id, email, name, company_url, slug, created_at, updated_at, plan_expired_at,
+	COALESCE(stripe_customer_id, ''), COALESCE(stripe_subscription_id, ''), subscription_status, subscription_interval, subscription_period_end`
+
+func scanRecruiterProfile(row *sql.Row) (Recruiter, error) {
 	obj := Recruiter{}
 	var nullTime sql.NullTime
 	err := row.Scan(
@@ -74,6 +76,11 @@ func (r *Repository) RecruiterProfileByEmail(email string) (Recruiter, error) {
 		&obj.CreatedAt,
 		&nullTime,
 		&obj.PlanExpiredAt,
+		&obj.StripeCustomerID,
+		&obj.StripeSubscriptionID,
+		&obj.SubscriptionStatus,
+		&obj.SubscriptionInterval,
+		&obj.SubscriptionPeriodEnd,
 	)
 	if nullTime.Valid {
 		obj.UpdatedAt = nullTime.Time
@@ -90,6 +97,69 @@ func (r *Repository) RecruiterProfileByEmail(email string) (Recruiter, error) {
 	return obj, nil
 }
 
+func (r *Repository) RecruiterProfileByEmail(email string) (Recruiter, error) {
+	return scanRecruiterProfile(r.db.QueryRow(`SELECT `+recruiterProfileColumns+` FROM recruiter_profile WHERE email = $1`, email))
+}
+
+func (r *Repository) RecruiterProfileByStripeCustomerID(customerID string) (Recruiter, error) {
+	return scanRecruiterProfile(r.db.QueryRow(`SELECT `+recruiterProfileColumns+` FROM recruiter_profile WHERE stripe_customer_id = $1`, customerID))
+}
+
+// SaveRecruiterSubscription links the recruiter profile to the stripe customer and subscription created at checkout
+func (r *Repository) SaveRecruiterSubscription(id, customerID, subscriptionID string) error {
+	_, err := r.db.Exec(
+		`UPDATE recruiter_profile SET stripe_customer_id = $1, stripe_subscription_id = $2, subscription_status = $3 WHERE id = $4`,
+		customerID,
+		subscriptionID,
+		SubscriptionStatusActive,
+		id,
+	)
+	return err
+}
+
+// RenewRecruiterSubscription records a paid subscription period, access lasts until expiredAt
+// which includes the grace period for the next renewal
+func (r *Repository) RenewRecruiterSubscription(id, customerID, subscriptionID, interval string, periodEnd, expiredAt time.Time) error {
+	_, err := r.db.Exec(
+		`UPDATE recruiter_profile SET
+			stripe_customer_id = $1,
+			stripe_subscription_id = $2,
+			subscription_status = $3,
+			subscription_interval = $4,
+			subscription_period_end = $5,
+			plan_expired_at = GREATEST(plan_expired_at, $6)
+		WHERE id = $7`,
+		customerID,
+		subscriptionID,
+		SubscriptionStatusActive,
+		interval,
+		periodEnd,
+		expiredAt,
+		id,
+	)
+	return err
+}
+
+func (r *Repository) UpdateRecruiterSubscriptionStatus(id, status string) error {
+	_, err := r.db.Exec(`UPDATE recruiter_profile SET subscription_status = $1 WHERE id = $2`, status, id)
+	return err
+}
+
+// CancelRecruiterSubscription marks the subscription as canceled. Access is kept until the end of the
+// last paid period, without the renewal grace period
+func (r *Repository) CancelRecruiterSubscription(id string) error {
+	_, err := r.db.Exec(
+		`UPDATE recruiter_profile SET
+			subscription_status = $1,
+			plan_expired_at = LEAST(plan_expired_at, GREATEST(COALESCE(subscription_period_end, $2), $2))
+		WHERE id = $3`,
+		SubscriptionStatusCanceled,
+		time.Now().UTC(),
+		id,
+	)
+	return err
+}
+
 func (r *Repository) SaveRecruiterProfile(dev Recruiter) error {
 	dev.Slug = slug.Make(fmt.Sprintf("%s %d", dev.Name, time.Now().UTC().Unix()))
 	_, err := r.db.Exec(
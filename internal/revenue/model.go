@@ -0,0 +1,248 @@
+package revenue
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/lib/pq"
+)
+
+const (
+	KindJobAd        = "job_ad"
+	KindDevDirectory = "dev_directory"
+	KindJobCredits   = "job_credits"
+
+	IntervalDay   = "day"
+	IntervalMonth = "month"
+
+	topCompaniesLimit = 10
+)
+
+var kindNames = map[string]string{
+	KindJobAd:        "Job Ads",
+	KindDevDirectory: "Developer Directory",
+	KindJobCredits:   "Job Credit Packs",
+}
+
+// Sale is a completed purchase of a job ad, developer directory access or a job credit pack
+type Sale struct {
+	Kind        string
+	SessionID   string // the invoice ID for developer directory subscription renewals
+	CompletedAt time.Time
+	Email       string
+	Company     string
+	Description string
+	PlanType    string // empty for developer directory access
+	Duration    int    // months of the plan, or the credits of a credit pack
+	Amount      int    // in the minor unit of Currency
+	Currency    string
+	BaseAmount  int         // Amount in US cents
+	ReversedAt  pq.NullTime // set when the payment was refunded or disputed
+}
+
+// Customer is who the sale is attributed to, the company when known
+func (s Sale) Customer() string {
+	if s.Company != "" {
+		return s.Company
+	}
+	return s.Email
+}
+
+// Conversion is how many of the job drafts saved in a period were paid for, by checkout or with credits
+type Conversion struct {
+	Drafts int
+	Paid   int
+}
+
+// Rate is the percentage of drafts paid for
+func (c Conversion) Rate() float64 {
+	if c.Drafts == 0 {
+		return 0
+	}
+	return float64(c.Paid) * 100 / float64(c.Drafts)
+}
+
+// Line sums up the sales of a breakdown, amounts are in US cents. Refunds are counted against the
+// sale they reverse, whenever they happened
+type Line struct {
+	Label    string
+	Sales    int
+	Revenue  int // before refunds
+	Refunds  int
+	Refunded int
+}
+
+func (l Line) Net() int {
+	return l.Revenue - l.Refunded
+}
+
+func (l *Line) add(s Sale) {
+	l.Sales++
+	l.Revenue += s.BaseAmount
+	if s.ReversedAt.Valid {
+		l.Refunds++
+		l.Refunded += s.BaseAmount
+	}
+}
+
+// Report breaks down the sales completed between From and To
+type Report struct {
+	From         time.Time
+	To           time.Time // exclusive
+	Interval     string
+	Totals       Line
+	ByPeriod     []Line
+	ByKind       []Line
+	ByPlanType   []Line
+	ByDuration   []Line // job ads only
+	TopCompanies []Line
+	Conversion   Conversion
+}
+
+// NewReport sums up sales per day or month, product, plan type, job ad duration and company
+func NewReport(sales []Sale, from, to time.Time, interval string, conversion Conversion) Report {
+	r := Report{
+		From:       from,
+		To:         to,
+		Interval:   interval,
+		Conversion: conversion,
+	}
+	r.ByPeriod = make([]Line, 0)
+	periods := make(map[string]int)
+	for t := periodStart(from, interval); t.Before(to); t = nextPeriod(t, interval) {
+		periods[periodLabel(t, interval)] = len(r.ByPeriod)
+		r.ByPeriod = append(r.ByPeriod, Line{Label: periodLabel(t, interval)})
+	}
+	byKind := make(map[string]*Line)
+	byPlanType := make(map[string]*Line)
+	byDuration := make(map[int]*Line)
+	byCompany := make(map[string]*Line)
+	for _, s := range sales {
+		r.Totals.add(s)
+		if i, ok := periods[periodLabel(s.CompletedAt, interval)]; ok {
+			r.ByPeriod[i].add(s)
+		}
+		lineFor(byKind, s.Kind, kindName(s.Kind)).add(s)
+		if s.PlanType != "" {
+			lineFor(byPlanType, s.PlanType, strings.Title(s.PlanType)).add(s)
+		}
+		if s.Kind == KindJobAd {
+			l, ok := byDuration[s.Duration]
+			if !ok {
+				l = &Line{Label: durationLabel(s.Duration)}
+				byDuration[s.Duration] = l
+			}
+			l.add(s)
+		}
+		lineFor(byCompany, strings.ToLower(s.Customer()), s.Customer()).add(s)
+	}
+	r.ByKind = orderedLines(byKind, []string{KindJobAd, KindDevDirectory, KindJobCredits})
+	r.ByPlanType = orderedLines(byPlanType, []string{job.JobPlanTypeBasic, job.JobPlanTypePro, job.JobPlanTypePlatinum})
+	durations := make([]int, 0, len(byDuration))
+	for d := range byDuration {
+		durations = append(durations, d)
+	}
+	sort.Ints(durations)
+	r.ByDuration = make([]Line, 0, len(durations))
+	for _, d := range durations {
+		r.ByDuration = append(r.ByDuration, *byDuration[d])
+	}
+	r.TopCompanies = make([]Line, 0, len(byCompany))
+	for _, l := range byCompany {
+		r.TopCompanies = append(r.TopCompanies, *l)
+	}
+	sort.Slice(r.TopCompanies, func(i, j int) bool {
+		if r.TopCompanies[i].Net() != r.TopCompanies[j].Net() {
+			return r.TopCompanies[i].Net() > r.TopCompanies[j].Net()
+		}
+		return r.TopCompanies[i].Label < r.TopCompanies[j].Label
+	})
+	if len(r.TopCompanies) > topCompaniesLimit {
+		r.TopCompanies = r.TopCompanies[:topCompaniesLimit]
+	}
+	return r
+}
+
+// BarWidth is the width of the line's bar in the revenue chart, as a percentage of the best period
+func (r Report) BarWidth(l Line) int {
+	max := 0
+	for _, p := range r.ByPeriod {
+		if p.Net() > max {
+			max = p.Net()
+		}
+	}
+	if max == 0 || l.Net() <= 0 {
+		return 0
+	}
+	return l.Net() * 100 / max
+}
+
+func kindName(kind string) string {
+	if name, ok := kindNames[kind]; ok {
+		return name
+	}
+	return kind
+}
+
+func durationLabel(months int) string {
+	if months == 1 {
+		return "1 month"
+	}
+	return fmt.Sprintf("%d months", months)
+}
+
+func lineFor(lines map[string]*Line, key, label string) *Line {
+	l, ok := lines[key]
+	if !ok {
+		l = &Line{Label: label}
+		lines[key] = l
+	}
+	return l
+}
+
+// orderedLines returns the lines in the order of keys, followed by the lines of any other key
+func orderedLines(lines map[string]*Line, keys []string) []Line {
+	ordered := make([]Line, 0, len(lines))
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		seen[k] = true
+		if l, ok := lines[k]; ok {
+			ordered = append(ordered, *l)
+		}
+	}
+	others := make([]string, 0)
+	for k := range lines {
+		if !seen[k] {
+			others = append(others, k)
+		}
+	}
+	sort.Strings(others)
+	for _, k := range others {
+		ordered = append(ordered, *lines[k])
+	}
+	return ordered
+}
+
+func periodStart(t time.Time, interval string) time.Time {
+	if interval == IntervalDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextPeriod(t time.Time, interval string) time.Time {
+	if interval == IntervalDay {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.AddDate(0, 1, 0)
+}
+
+func periodLabel(t time.Time, interval string) string {
+	if interval == IntervalDay {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("Jan 2006")
+}
@@ -0,0 +1,85 @@
+package revenue
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/golang-cafe/job-board/internal/payment"
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db}
+}
+
+// Sales returns the purchases completed between from and to, oldest first. Developer directory
+// access and credit packs are sold in US dollars, their company is the one of the latest job posted
+// with the buyer's email
+func (r *Repository) Sales(from, to time.Time) ([]Sale, error) {
+	rows, err := r.db.Query(
+		`SELECT $3::text, p.stripe_session_id, p.completed_at, p.email, COALESCE(j.company, ''), p.description, p.plan_type, p.plan_duration, p.amount, p.currency, p.base_amount, COALESCE(p.refunded_at, p.disputed_at)
+		FROM purchase_event p LEFT JOIN job j ON j.id = p.job_id
+		WHERE p.completed_at >= $1 AND p.completed_at < $2
+		UNION ALL
+		SELECT $4::text, d.stripe_session_id, d.completed_at, d.email, COALESCE((SELECT j.company FROM job j WHERE lower(j.company_email) = lower(d.email) ORDER BY j.created_at DESC LIMIT 1), ''), d.description, '', d.duration, d.amount, d.currency, d.amount, COALESCE(d.refunded_at, d.disputed_at)
+		FROM developer_directory_purchase_event d
+		WHERE d.completed_at >= $1 AND d.completed_at < $2
+		UNION ALL
+		SELECT $5::text, c.stripe_session_id, c.completed_at, c.email, COALESCE((SELECT j.company FROM job j WHERE lower(j.company_email) = lower(c.email) ORDER BY j.created_at DESC LIMIT 1), ''), '', c.plan_type, c.credits, c.amount, c.currency, c.amount, c.revoked_at
+		FROM job_credit_pack c
+		WHERE c.completed_at >= $1 AND c.completed_at < $2
+		ORDER BY 3 ASC`,
+		from,
+		to,
+		KindJobAd,
+		KindDevDirectory,
+		KindJobCredits,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sales := make([]Sale, 0)
+	for rows.Next() {
+		var s Sale
+		err := rows.Scan(
+			&s.Kind,
+			&s.SessionID,
+			&s.CompletedAt,
+			&s.Email,
+			&s.Company,
+			&s.Description,
+			&s.PlanType,
+			&s.Duration,
+			&s.Amount,
+			&s.Currency,
+			&s.BaseAmount,
+			&s.ReversedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if s.Kind == KindJobCredits {
+			s.Description = payment.JobCreditPackDescription(s.PlanType, s.Duration)
+		}
+		sales = append(sales, s)
+	}
+	return sales, rows.Err()
+}
+
+// Conversion counts the job drafts saved between from and to and how many of them were paid for
+func (r *Repository) Conversion(from, to time.Time) (Conversion, error) {
+	var c Conversion
+	err := r.db.QueryRow(
+		`SELECT count(*), count(*) FILTER (WHERE
+			EXISTS (SELECT 1 FROM purchase_event p WHERE p.job_id = j.id AND p.completed_at IS NOT NULL)
+			OR EXISTS (SELECT 1 FROM job_credit_spend s WHERE s.job_id = j.id))
+		FROM job j WHERE j.created_at >= $1 AND j.created_at < $2`,
+		from,
+		to,
+	).Scan(&c.Drafts, &c.Paid)
+	return c, err
+}
@@ -0,0 +1,58 @@
+package savedsearch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	FrequencyInstant = "instant"
+	FrequencyDaily   = "daily"
+
+	// MaxPerUser caps how many saved searches a single user can have
+	MaxPerUser = 20
+)
+
+var ValidFrequencies = map[string]struct{}{
+	FrequencyInstant: {},
+	FrequencyDaily:   {},
+}
+
+type SavedSearch struct {
+	ID         string
+	UserID     string
+	Email      string
+	Location   string
+	Tag        string
+	Salary     int
+	Currency   string
+	Frequency  string
+	Token      string
+	CreatedAt  time.Time
+	LastSentAt time.Time
+}
+
+// Description returns a short human readable summary of the search, e.g. "Kubernetes jobs in London paying 100000 USD or more"
+func (s SavedSearch) Description() string {
+	parts := []string{}
+	if s.Tag != "" {
+		parts = append(parts, s.Tag)
+	}
+	parts = append(parts, "jobs")
+	if s.Location != "" {
+		parts = append(parts, "in "+s.Location)
+	}
+	if s.Salary > 0 {
+		parts = append(parts, fmt.Sprintf("paying %d %s or more", s.Salary, s.Currency))
+	}
+	return strings.Join(parts, " ")
+}
+
+type CreateRq struct {
+	Location  string `json:"location"`
+	Tag       string `json:"tag"`
+	Salary    int    `json:"salary"`
+	Currency  string `json:"currency"`
+	Frequency string `json:"frequency"`
+}
@@ -0,0 +1,116 @@
+package savedsearch
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db}
+}
+
+func (r *Repository) Create(s SavedSearch) (SavedSearch, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return s, err
+	}
+	token, err := ksuid.NewRandom()
+	if err != nil {
+		return s, err
+	}
+	s.ID = id.String()
+	s.Token = token.String()
+	s.CreatedAt = time.Now().UTC()
+	s.LastSentAt = s.CreatedAt
+	_, err = r.db.Exec(
+		`INSERT INTO saved_search (id, user_id, location, tag, salary, currency, frequency, token, created_at, last_sent_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		s.ID,
+		s.UserID,
+		s.Location,
+		s.Tag,
+		s.Salary,
+		s.Currency,
+		s.Frequency,
+		s.Token,
+		s.CreatedAt,
+		s.LastSentAt,
+	)
+	return s, err
+}
+
+func (r *Repository) GetForUser(userID string) ([]*SavedSearch, error) {
+	return r.query(`SELECT s.id, s.user_id, u.email, s.location, s.tag, s.salary, s.currency, s.frequency, s.token, s.created_at, s.last_sent_at
+		FROM saved_search s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.user_id = $1
+		ORDER BY s.created_at DESC`, userID)
+}
+
+// GetByFrequency returns all saved searches that should be alerted with the given frequency
+func (r *Repository) GetByFrequency(frequency string) ([]*SavedSearch, error) {
+	return r.query(`SELECT s.id, s.user_id, u.email, s.location, s.tag, s.salary, s.currency, s.frequency, s.token, s.created_at, s.last_sent_at
+		FROM saved_search s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.frequency = $1
+		ORDER BY s.last_sent_at ASC`, frequency)
+}
+
+func (r *Repository) CountForUser(userID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM saved_search WHERE user_id = $1`, userID).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) UpdateLastSentAt(id string, sentAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE saved_search SET last_sent_at = $1 WHERE id = $2`, sentAt, id)
+	return err
+}
+
+func (r *Repository) Delete(id, userID string) error {
+	return r.exec(`DELETE FROM saved_search WHERE id = $1 AND user_id = $2`, id, userID)
+}
+
+func (r *Repository) DeleteByToken(token string) error {
+	return r.exec(`DELETE FROM saved_search WHERE token = $1`, token)
+}
+
+func (r *Repository) exec(stmt string, args ...interface{}) error {
+	res, err := r.db.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) query(stmt string, args ...interface{}) ([]*SavedSearch, error) {
+	searches := []*SavedSearch{}
+	rows, err := r.db.Query(stmt, args...)
+	if err != nil {
+		return searches, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := &SavedSearch{}
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Email, &s.Location, &s.Tag, &s.Salary, &s.Currency, &s.Frequency, &s.Token, &s.CreatedAt, &s.LastSentAt); err != nil {
+			return searches, err
+		}
+		searches = append(searches, s)
+	}
+	if err := rows.Err(); err != nil {
+		return searches, err
+	}
+	return searches, nil
+}
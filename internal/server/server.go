@@ -27,6 +27,7 @@ import (
 	"github.com/golang-cafe/job-board/internal/email"
 	"github.com/golang-cafe/job-board/internal/job"
 	"github.com/golang-cafe/job-board/internal/middleware"
+	"github.com/golang-cafe/job-board/internal/payment"
 	"github.com/golang-cafe/job-board/internal/template"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
@@ -284,14 +285,20 @@ func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		s.Log(err, "unable to compile regex (this should never happen)")
 	}
-	tag = reg.ReplaceAllString(tag, "")
+	// keep quotes and dashes in search terms for phrase and exclusion queries
+	searchReg, err := regexp.Compile("[^a-zA-Z0-9\\s\"-]+")
+	if err != nil {
+		s.Log(err, "unable to compile regex (this should never happen)")
+	}
+	tag = searchReg.ReplaceAllString(tag, "")
 	location = reg.ReplaceAllString(location, "")
 	pageID, err := strconv.Atoi(page)
 	if err != nil {
 		pageID = 1
 		showPage = false
 	}
-	isLandingPage := tag == "" && location == "" && page == "1" && salary == ""
+	filters := job.ParseJobFiltersFromQuery(r.URL.Query())
+	isLandingPage := tag == "" && location == "" && page == "1" && salary == "" && filters.IsEmpty()
 	var newJobsLastWeek, newJobsLastMonth int
 	newJobsLastWeekCached, okWeek := s.CacheGet(CacheKeyNewJobsLastWeek)
 	newJobsLastMonthCached, okMonth := s.CacheGet(CacheKeyNewJobsLastMonth)
@@ -345,7 +352,7 @@ func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Reque
 			}
 		}
 	}
-	jobsForPage, totalJobCount, err := jobRepo.JobsByQuery(location, tag, pageID, salaryInt, currency, s.cfg.JobsPerPage, !isLandingPage)
+	jobsForPage, totalJobCount, err := jobRepo.JobsByQuery(location, tag, pageID, salaryInt, currency, s.cfg.JobsPerPage, !isLandingPage, filters)
 	if err != nil {
 		s.Log(err, "unable to get jobs by query")
 		s.JSON(w, http.StatusInternalServerError, "Oops! An internal error has occurred")
@@ -354,9 +361,9 @@ func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Reque
 	var complementaryRemote bool
 	if len(jobsForPage) == 0 {
 		complementaryRemote = true
-		jobsForPage, totalJobCount, err = jobRepo.JobsByQuery("Remote", tag, pageID, salaryInt, currency, s.cfg.JobsPerPage, !isLandingPage)
+		jobsForPage, totalJobCount, err = jobRepo.JobsByQuery("Remote", tag, pageID, salaryInt, currency, s.cfg.JobsPerPage, !isLandingPage, filters)
 		if len(jobsForPage) == 0 {
-			jobsForPage, totalJobCount, err = jobRepo.JobsByQuery("Remote", "", pageID, salaryInt, currency, s.cfg.JobsPerPage, !isLandingPage)
+			jobsForPage, totalJobCount, err = jobRepo.JobsByQuery("Remote", "", pageID, salaryInt, currency, s.cfg.JobsPerPage, !isLandingPage, filters)
 		}
 	}
 	if err != nil {
@@ -393,6 +400,7 @@ func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Reque
 		jobsForPage[i].SalaryRange = fmt.Sprintf("%s%s to %s%s", j.SalaryCurrency, humanize.Comma(j.SalaryMin), j.SalaryCurrency, humanize.Comma(j.SalaryMax))
 		jobsForPage[i].PerksHTML = s.tmpl.MarkdownToHTML(j.Perks)
 		jobsForPage[i].InterviewProcessHTML = s.tmpl.MarkdownToHTML(j.InterviewProcess)
+		jobsForPage[i].SearchSnippetHTML = s.StringToHTML(j.SearchSnippet)
 		if s.IsEmail(j.HowToApply) {
 			jobsForPage[i].IsQuickApply = true
 		}
@@ -463,6 +471,9 @@ func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	// filters are already validated so the encoded query is safe to use in links
+	filtersQuery := stdtemplate.URL(filters.Query().Encode())
+
 	s.Render(r, w, http.StatusOK, htmlView, map[string]interface{}{
 		"Jobs":                               jobsForPage,
 		"PinnedJobs":                         pinnedJobs,
@@ -476,6 +487,12 @@ func (s Server) RenderPageForLocationAndTag(w http.ResponseWriter, r *http.Reque
 		"AvailableCurrencies":                s.GetConfig().AvailableCurrencies,
 		"AvailableSalaryBands":               s.GetConfig().AvailableSalaryBands,
 		"TagFilterURLEnc":                    url.PathEscape(tag),
+		"VisaSponsorshipFilter":              filters.VisaSponsorship,
+		"RemoteOnlyFilter":                   filters.RemoteOnly,
+		"SalaryPeriodFilter":                 filters.SalaryPeriod,
+		"PostedWithinDaysFilter":             filters.PostedWithinDays,
+		"CompanyFilter":                      filters.Company,
+		"FiltersQuery":                       filtersQuery,
 		"CurrentPage":                        pageID,
 		"ShowPage":                           showPage,
 		"PageSize":                           s.cfg.JobsPerPage,
@@ -918,7 +935,8 @@ func (s Server) RenderPageForLocationAndTagAdmin(r *http.Request, w http.Respons
 	for i, j := range pendingJobs {
 		pendingJobs[i].SalaryRange = fmt.Sprintf("%s%s to %s%s", j.SalaryCurrency, humanize.Comma(j.SalaryMin), j.SalaryCurrency, humanize.Comma(j.SalaryMax))
 	}
-	jobsForPage, totalJobCount, err := jobRepo.JobsByQuery(location, tag, pageID, salaryInt, currency, s.cfg.JobsPerPage, false)
+	filters := job.ParseJobFiltersFromQuery(r.URL.Query())
+	jobsForPage, totalJobCount, err := jobRepo.JobsByQuery(location, tag, pageID, salaryInt, currency, s.cfg.JobsPerPage, false, filters)
 	if err != nil {
 		s.Log(err, "unable to get jobs by query")
 		s.JSON(w, http.StatusInternalServerError, "Oops! An internal error has occurred")
@@ -927,9 +945,9 @@ func (s Server) RenderPageForLocationAndTagAdmin(r *http.Request, w http.Respons
 	var complementaryRemote bool
 	if len(jobsForPage) == 0 {
 		complementaryRemote = true
-		jobsForPage, totalJobCount, err = jobRepo.JobsByQuery("Remote", tag, pageID, salaryInt, currency, s.cfg.JobsPerPage, false)
+		jobsForPage, totalJobCount, err = jobRepo.JobsByQuery("Remote", tag, pageID, salaryInt, currency, s.cfg.JobsPerPage, false, filters)
 		if len(jobsForPage) == 0 {
-			jobsForPage, totalJobCount, err = jobRepo.JobsByQuery("Remote", "", pageID, salaryInt, currency, s.cfg.JobsPerPage, false)
+			jobsForPage, totalJobCount, err = jobRepo.JobsByQuery("Remote", "", pageID, salaryInt, currency, s.cfg.JobsPerPage, false, filters)
 		}
 	}
 	if err != nil {
@@ -1035,9 +1053,72 @@ func (s Server) RenderPostAJobForLocation(w http.ResponseWriter, r *http.Request
 		"NewJobsLastMonth":         newJobsLastMonth,
 		"DefaultPlanExpiration":    time.Now().UTC().AddDate(0, 0, 30),
 		"StripePublishableKey":     s.GetConfig().StripePublishableKey,
+		"PlanPrices":               s.AvailableJobAdPlanPrices(),
 	})
 }
 
+// JobAdPlanPrices returns the monthly job ad plan prices in currency, either the prices configured for
+// it or the USD prices converted at the latest FX rate and rounded. It returns
+// payment.ErrCurrencyNotAvailable if job ads can't be paid in currency
+func (s Server) JobAdPlanPrices(currency string) (payment.PlanPrices, error) {
+	currency = strings.ToUpper(currency)
+	prices := payment.PlanPrices{
+		Currency: payment.BaseCurrency,
+		Basic:    int64(s.GetConfig().PlanID1Price),
+		Pro:      int64(s.GetConfig().PlanID2Price),
+		Platinum: int64(s.GetConfig().PlanID3Price),
+		FXRate:   1,
+	}
+	if currency == payment.BaseCurrency {
+		return prices, nil
+	}
+	var accepted bool
+	for _, c := range s.GetConfig().PlanCurrencies {
+		accepted = accepted || c == currency
+	}
+	if !accepted {
+		return payment.PlanPrices{}, payment.ErrCurrencyNotAvailable
+	}
+	// the rate is needed for configured prices too, to report revenue in USD
+	fx, err := database.GetFXRate(s.Conn, payment.BaseCurrency, currency)
+	if err == sql.ErrNoRows || (err == nil && fx.Value <= 0) {
+		return payment.PlanPrices{}, payment.ErrCurrencyNotAvailable
+	}
+	if err != nil {
+		return payment.PlanPrices{}, err
+	}
+	if configured, ok := s.GetConfig().PlanCurrencyPrices[currency]; ok {
+		return payment.PlanPrices{
+			Currency: currency,
+			Basic:    int64(configured[0]),
+			Pro:      int64(configured[1]),
+			Platinum: int64(configured[2]),
+			FXRate:   fx.Value,
+		}, nil
+	}
+	return payment.PlanPrices{
+		Currency: currency,
+		Basic:    payment.RoundPrice(payment.ConvertAmount(prices.Basic, payment.BaseCurrency, currency, fx.Value), currency),
+		Pro:      payment.RoundPrice(payment.ConvertAmount(prices.Pro, payment.BaseCurrency, currency, fx.Value), currency),
+		Platinum: payment.RoundPrice(payment.ConvertAmount(prices.Platinum, payment.BaseCurrency, currency, fx.Value), currency),
+		FXRate:   fx.Value,
+	}, nil
+}
+
+// AvailableJobAdPlanPrices returns the plan prices in each currency job ads can currently be paid in
+func (s Server) AvailableJobAdPlanPrices() []payment.PlanPrices {
+	available := make([]payment.PlanPrices, 0, len(s.GetConfig().PlanCurrencies))
+	for _, currency := range s.GetConfig().PlanCurrencies {
+		prices, err := s.JobAdPlanPrices(currency)
+		if err != nil {
+			s.Log(err, fmt.Sprintf("unable to price job ads in %s", currency))
+			continue
+		}
+		available = append(available, prices)
+	}
+	return available
+}
+
 func (s Server) Render(r *http.Request, w http.ResponseWriter, status int, htmlView string, data interface{}) error {
 	dataMap := make(map[string]interface{}, 0)
 	if data != nil {
@@ -1074,6 +1155,54 @@ func (s Server) Render(r *http.Request, w http.ResponseWriter, status int, htmlV
 	return s.tmpl.Render(w, status, htmlView, dataMap)
 }
 
+// RenderEmail renders the <name>.html and <name>.txt email templates from static/views
+func (s Server) RenderEmail(name string, data map[string]interface{}) (string, string, error) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["SiteName"] = s.GetConfig().SiteName
+	data["SiteJobCategory"] = strings.Title(strings.ToLower(s.GetConfig().SiteJobCategory))
+	data["SiteJobCategoryURLEncoded"] = strings.ReplaceAll(strings.Title(strings.ToLower(s.GetConfig().SiteJobCategory)), " ", "-")
+	data["SiteHost"] = s.GetConfig().SiteHost
+	data["SiteURL"] = s.GetConfig().URLProtocol + s.GetConfig().SiteHost
+	data["PrimaryColor"] = s.GetConfig().PrimaryColor
+	data["SiteLogoImageID"] = s.GetConfig().SiteLogoImageID
+	html, err := s.tmpl.RenderString(name+".html", data)
+	if err != nil {
+		return "", "", err
+	}
+	text, err := s.tmpl.RenderTextString(name+".txt", data)
+	if err != nil {
+		return "", "", err
+	}
+	return html, text, nil
+}
+
+// SendTemplatedEmail renders the <name> email templates and sends them as a multipart email
+func (s Server) SendTemplatedEmail(from, to, replyTo email.Address, subject, name string, data map[string]interface{}, attachments ...email.Attachment) error {
+	_, err := s.QueueTemplatedEmail(from, to, replyTo, subject, name, data, attachments...)
+	return err
+}
+
+// QueueTemplatedEmail sends the email like SendTemplatedEmail and returns the ID of its outbox message
+func (s Server) QueueTemplatedEmail(from, to, replyTo email.Address, subject, name string, data map[string]interface{}, attachments ...email.Attachment) (string, error) {
+	html, text, err := s.RenderEmail(name, data)
+	if err != nil {
+		return "", err
+	}
+	return s.GetEmail().QueueEmail(email.EmailMessageWithAttachment{
+		EmailMessage: email.EmailMessage{
+			Sender:      from,
+			To:          []email.Address{to},
+			ReplyTo:     replyTo,
+			Subject:     subject,
+			TextContent: text,
+			HtmlContent: html,
+		},
+		Attachment: attachments,
+	})
+}
+
 func (s Server) XML(w http.ResponseWriter, status int, data []byte) {
 	w.Header().Set("Content-Type", "text/xml")
 	w.WriteHeader(status)
@@ -1,19 +1,23 @@
 package template
 
 import (
+	"bytes"
 	"net/http"
 	"strings"
 	"embed"
 	"time"
 
 	stdtemplate "html/template"
+	texttemplate "text/template"
 	humanize "github.com/dustin/go-humanize"
+	"github.com/golang-cafe/job-board/internal/payment"
 	blackfriday "gopkg.in/russross/blackfriday.v2"
 )
 
 type Template struct {
-	templates *stdtemplate.Template
-	funcMap   stdtemplate.FuncMap
+	templates     *stdtemplate.Template
+	textTemplates *texttemplate.Template
+	funcMap       stdtemplate.FuncMap
 }
 
 func NewTemplate(fs embed.FS) *Template {
@@ -54,6 +58,9 @@ func NewTemplate(fs embed.FS) *Template {
 		"mul": func(a int, b int) int {
 			return a*b
 		},
+		"currencyAmount": func(amount int, currency string) string {
+			return payment.FormatAmount(int64(amount), currency)
+		},
 		"jobOlderThanMonths": func(monthYearCreated string, monthsAgo int) bool {
 			t, err := time.Parse("January 2006", monthYearCreated)
 			if err != nil {
@@ -92,7 +99,8 @@ func NewTemplate(fs embed.FS) *Template {
 		},
 	}
 	return &Template{
-		templates: stdtemplate.Must(stdtemplate.New("stdtmpl").Funcs(funcMap).ParseFS(fs, "static/views/*.html")),
+		templates:     stdtemplate.Must(stdtemplate.New("stdtmpl").Funcs(funcMap).ParseFS(fs, "static/views/*.html")),
+		textTemplates: texttemplate.Must(texttemplate.New("texttmpl").Funcs(texttemplate.FuncMap(funcMap)).ParseFS(fs, "static/views/*.txt")),
 	}
 }
 
@@ -105,6 +113,24 @@ func (t *Template) Render(w http.ResponseWriter, status int, name string, data i
 	return t.templates.ExecuteTemplate(w, name, data)
 }
 
+// RenderString executes the html template with the given name and returns the output, e.g. for email bodies
+func (t *Template) RenderString(name string, data interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := t.templates.ExecuteTemplate(buf, name, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderTextString executes the plain text template (static/views/*.txt) with the given name
+func (t *Template) RenderTextString(name string, data interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := t.textTemplates.ExecuteTemplate(buf, name, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 func (t *Template) StringToHTML(s string) stdtemplate.HTML {
 	return stdtemplate.HTML(s)
 }
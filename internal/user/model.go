@@ -16,3 +16,9 @@ type User struct {
 	IsAdmin            bool
 	Type               string
 }
+
+// SignOn is a sign on link requested for the user's email address
+type SignOn struct {
+	UserType  string
+	CreatedAt time.Time
+}
@@ -149,3 +149,107 @@ func (r *Repository) GetUserTypeByEmailOrCreateUserIfRecruiter(email string, job
 
 	return "", errors.New("not found")
 }
+
+// GetUserByID returns the user with the given id
+func (r *Repository) GetUserByID(id string) (User, error) {
+	u := User{}
+	var userType sql.NullString
+	row := r.db.QueryRow(`SELECT id, email, created_at, user_type FROM users WHERE id = $1`, id)
+	if err := row.Scan(&u.ID, &u.Email, &u.CreatedAt, &userType); err != nil {
+		return u, err
+	}
+	u.Type = userType.String
+	u.IsAdmin = u.Type == UserTypeAdmin
+	u.CreatedAtHumanised = humanize.Time(u.CreatedAt.UTC())
+	return u, nil
+}
+
+// SignOnHistory returns the sign on links requested for the given email, most recent first. Sign on
+// tokens are deleted after a week so the history doesn't go further back
+func (r *Repository) SignOnHistory(email string) ([]SignOn, error) {
+	history := []SignOn{}
+	rows, err := r.db.Query(`SELECT COALESCE(user_type, ''), created_at FROM user_sign_on_token WHERE email = $1 ORDER BY created_at DESC`, email)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s SignOn
+		if err := rows.Scan(&s.UserType, &s.CreatedAt); err != nil {
+			return history, err
+		}
+		history = append(history, s)
+	}
+	return history, rows.Err()
+}
+
+// EraseUser deletes the user and everything linked to their account: developer and recruiter profiles,
+// messages sent and received, bookmarks, job applications and CVs, blog posts, job alerts, API keys,
+// sign on tokens, emails sent to or about them and their newsletter and suppression records. Job posts
+// and payment records are kept
+func (r *Repository) EraseUser(u User) error {
+	stmts := []struct {
+		query string
+		arg   string
+	}{
+		{`DELETE FROM applicant_note WHERE apply_token IN (SELECT token FROM apply_token WHERE lower(email) = lower($1))`, u.Email},
+		{`DELETE FROM apply_token WHERE lower(email) = lower($1)`, u.Email},
+		{`DELETE FROM bookmark WHERE user_id = $1`, u.ID},
+		{`DELETE FROM developer_profile_message WHERE sender_id = $1`, u.ID},
+		{`DELETE FROM developer_profile_message WHERE profile_id IN (SELECT id FROM developer_profile WHERE lower(email) = lower($1))`, u.Email},
+		{`DELETE FROM developer_profile_event WHERE developer_profile_id IN (SELECT id FROM developer_profile WHERE lower(email) = lower($1))`, u.Email},
+		{`DELETE FROM developer_metadata WHERE developer_profile_id IN (SELECT id FROM developer_profile WHERE lower(email) = lower($1))`, u.Email},
+		{`DELETE FROM developer_profile WHERE lower(email) = lower($1)`, u.Email},
+		{`DELETE FROM recruiter_profile WHERE lower(email) = lower($1)`, u.Email},
+		{`DELETE FROM blog_post WHERE created_by = $1`, u.ID},
+		{`DELETE FROM saved_search WHERE user_id = $1`, u.ID},
+		{`DELETE FROM api_key_usage WHERE api_key_id IN (SELECT id FROM api_key WHERE user_id = $1)`, u.ID},
+		{`DELETE FROM api_key WHERE user_id = $1`, u.ID},
+		{`DELETE FROM user_sign_on_token WHERE lower(email) = lower($1)`, u.Email},
+		{`DELETE FROM email_subscribers WHERE lower(email) = lower($1)`, u.Email},
+		{`DELETE FROM newsletter_send_log WHERE lower(email) = lower($1)`, u.Email},
+		{`DELETE FROM email_suppression WHERE lower(email) = lower($1)`, u.Email},
+		{`DELETE FROM email_outbox WHERE lower(message->'replyTo'->>'email') = lower($1)
+			OR EXISTS (SELECT 1 FROM jsonb_array_elements(message->'to') t WHERE lower(t->>'email') = lower($1))`, u.Email},
+		{`DELETE FROM users WHERE id = $1`, u.ID},
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	// the profile image can only be deleted once the developer profile referencing it is gone
+	var imageIDs []string
+	rows, err := tx.Query(`SELECT image_id FROM developer_profile WHERE lower(email) = lower($1) AND image_id IS NOT NULL`, u.Email)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for rows.Next() {
+		var imageID string
+		if err := rows.Scan(&imageID); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		imageIDs = append(imageIDs, imageID)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		tx.Rollback()
+		return err
+	}
+	rows.Close()
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt.query, stmt.arg); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, imageID := range imageIDs {
+		if _, err := tx.Exec(`DELETE FROM image WHERE id = $1`, imageID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
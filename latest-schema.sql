@@ -877,4 +877,253 @@ CREATE INDEX job_event_event_type_idx ON job_event(event_type);
 CREATE INDEX job_event_created_at_idx ON job_event(created_at);
 CREATE INDEX users_email_idx ON users(email);
 CREATE INDEX job_event_job_id ON job_event (job_id);
-DROP TABLE search_event;
\ No newline at end of file
+DROP TABLE search_event;
+CREATE TABLE public.api_key (
+    id CHAR(27) NOT NULL,
+    user_id CHAR(27) NOT NULL REFERENCES public.users(id),
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes VARCHAR(255) NOT NULL,
+    rate_limit INTEGER NOT NULL DEFAULT 60,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP DEFAULT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX api_key_user_id_idx ON api_key (user_id);
+
+CREATE TABLE public.api_key_usage (
+    api_key_id CHAR(27) NOT NULL REFERENCES public.api_key(id),
+    day DATE NOT NULL,
+    request_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day)
+);
+
+ALTER TABLE public.job ADD COLUMN search_document tsvector;
+UPDATE public.job SET search_document = setweight(to_tsvector(coalesce(job_title, '')), 'A') || setweight(to_tsvector(coalesce(company, '')), 'B') || setweight(to_tsvector(coalesce(description, '')), 'C');
+CREATE INDEX job_search_document_idx ON public.job USING GIN (search_document);
+
+CREATE TABLE public.saved_search (
+    id CHAR(27) NOT NULL,
+    user_id CHAR(27) NOT NULL REFERENCES public.users(id),
+    location VARCHAR(255) NOT NULL DEFAULT '',
+    tag VARCHAR(255) NOT NULL DEFAULT '',
+    salary INTEGER NOT NULL DEFAULT 0,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    frequency VARCHAR(20) NOT NULL,
+    token CHAR(27) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    last_sent_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX saved_search_user_id_idx ON saved_search (user_id);
+CREATE INDEX saved_search_frequency_idx ON saved_search (frequency);
+
+CREATE TABLE public.newsletter_campaign (
+    id CHAR(27) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    job_ids INTEGER[] NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    recipient_count INTEGER NOT NULL DEFAULT 0,
+    sent_count INTEGER NOT NULL DEFAULT 0,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE public.newsletter_send_log (
+    campaign_id CHAR(27) NOT NULL REFERENCES public.newsletter_campaign(id),
+    email VARCHAR(255) NOT NULL,
+    sent_at TIMESTAMP NOT NULL,
+    error TEXT DEFAULT NULL,
+    PRIMARY KEY (campaign_id, email)
+);
+INSERT INTO public.meta (key, value) VALUES ('newsletter_current_campaign_id', ''), ('newsletter_last_recipient_email', '') ON CONFLICT DO NOTHING;
+
+CREATE TABLE public.email_outbox (
+    id CHAR(27) NOT NULL,
+    message JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT DEFAULT NULL,
+    next_attempt_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX email_outbox_status_next_attempt_at_idx ON public.email_outbox (status, next_attempt_at);
+
+CREATE TABLE public.email_suppression (
+    email VARCHAR(255) NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    detail TEXT DEFAULT NULL,
+    bounce_count INTEGER NOT NULL DEFAULT 0,
+    suppressed_at TIMESTAMP DEFAULT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (email)
+);
+
+ALTER TABLE public.apply_token ADD COLUMN stage VARCHAR(20) NOT NULL DEFAULT 'new';
+ALTER TABLE public.apply_token ADD COLUMN rating SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE public.apply_token ADD COLUMN stage_updated_at TIMESTAMP DEFAULT NULL;
+
+CREATE TABLE public.applicant_note (
+    id CHAR(27) NOT NULL,
+    apply_token CHAR(27) NOT NULL,
+    text TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX applicant_note_apply_token_idx ON public.applicant_note (apply_token);
+
+ALTER TABLE public.bookmark ADD COLUMN withdrawn_at TIMESTAMP DEFAULT NULL;
+
+ALTER TABLE public.job ADD COLUMN screening_questions JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE public.apply_token ADD COLUMN screening_answers JSONB NOT NULL DEFAULT '[]';
+
+ALTER TABLE public.apply_token ADD COLUMN cv_content_type VARCHAR(100) NOT NULL DEFAULT 'application/pdf';
+ALTER TABLE public.apply_token ADD COLUMN cv_text TEXT NOT NULL DEFAULT '';
+ALTER TABLE public.apply_token ADD COLUMN cv_search_document tsvector;
+CREATE INDEX apply_token_cv_search_document_idx ON public.apply_token USING GIN (cv_search_document);
+-- CVs uploaded before this migration have no cv_search_document, run the /x/task/cv-text-backfill task once to index them
+
+ALTER TABLE public.job ADD COLUMN expired_at TIMESTAMP DEFAULT NULL;
+UPDATE public.job SET expired_at = NOW() WHERE expired = true;
+ALTER TABLE public.job ADD COLUMN cv_purge_notified_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE public.apply_token ADD COLUMN cv_purged_at TIMESTAMP DEFAULT NULL;
+
+CREATE TABLE public.cv_purge_log (
+    id CHAR(27) NOT NULL,
+    job_id INTEGER NOT NULL,
+    applicants INTEGER NOT NULL,
+    bytes BIGINT NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX cv_purge_log_job_id_idx ON public.cv_purge_log (job_id);
+
+ALTER TABLE public.recruiter_profile ADD COLUMN stripe_customer_id VARCHAR(255) DEFAULT NULL;
+ALTER TABLE public.recruiter_profile ADD COLUMN stripe_subscription_id VARCHAR(255) DEFAULT NULL;
+ALTER TABLE public.recruiter_profile ADD COLUMN subscription_status VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE public.recruiter_profile ADD COLUMN subscription_interval VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE public.recruiter_profile ADD COLUMN subscription_period_end TIMESTAMP DEFAULT NULL;
+CREATE INDEX recruiter_profile_stripe_customer_id_idx ON public.recruiter_profile (stripe_customer_id);
+
+CREATE TABLE public.stripe_webhook_event (
+    id VARCHAR(255) NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL,
+    claimed_at TIMESTAMP DEFAULT NULL,
+    processed_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX stripe_webhook_event_status_created_at_idx ON public.stripe_webhook_event (status, created_at);
+
+ALTER TABLE public.purchase_event ADD COLUMN stripe_payment_intent_id VARCHAR(255) DEFAULT NULL;
+ALTER TABLE public.purchase_event ADD COLUMN refunded_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE public.purchase_event ADD COLUMN disputed_at TIMESTAMP DEFAULT NULL;
+CREATE INDEX purchase_event_stripe_payment_intent_id_idx ON public.purchase_event (stripe_payment_intent_id);
+ALTER TABLE public.developer_directory_purchase_event ADD COLUMN stripe_payment_intent_id VARCHAR(255) DEFAULT NULL;
+ALTER TABLE public.developer_directory_purchase_event ADD COLUMN refunded_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE public.developer_directory_purchase_event ADD COLUMN disputed_at TIMESTAMP DEFAULT NULL;
+CREATE INDEX developer_directory_purchase_event_stripe_payment_intent_id_idx ON public.developer_directory_purchase_event (stripe_payment_intent_id);
+
+CREATE TABLE public.invoice (
+    id CHAR(27) NOT NULL,
+    number INTEGER NOT NULL,
+    job_id INTEGER NOT NULL,
+    stripe_session_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    billing_name VARCHAR(255) NOT NULL DEFAULT '',
+    billing_address TEXT NOT NULL DEFAULT '',
+    billing_vat_id VARCHAR(100) NOT NULL DEFAULT '',
+    description VARCHAR(255) NOT NULL,
+    amount INTEGER NOT NULL,
+    currency CHAR(3) NOT NULL,
+    pdf BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX invoice_number_idx ON public.invoice (number);
+CREATE UNIQUE INDEX invoice_stripe_session_id_idx ON public.invoice (stripe_session_id);
+CREATE INDEX invoice_job_id_idx ON public.invoice (job_id);
+CREATE INDEX invoice_email_idx ON public.invoice (email);
+
+CREATE TABLE public.promo_code (
+    id CHAR(27) NOT NULL,
+    code VARCHAR(50) NOT NULL,
+    discount_type VARCHAR(20) NOT NULL,
+    discount_value INTEGER NOT NULL,
+    plan_types TEXT[] NOT NULL DEFAULT '{}',
+    max_redemptions INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP DEFAULT NULL,
+    disabled_at TIMESTAMP DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX promo_code_code_idx ON public.promo_code (code);
+ALTER TABLE public.purchase_event ADD COLUMN promo_code_id CHAR(27) DEFAULT NULL;
+ALTER TABLE public.purchase_event ADD COLUMN discount_amount INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX purchase_event_promo_code_id_idx ON public.purchase_event (promo_code_id);
+
+ALTER TABLE public.purchase_event ADD COLUMN base_amount INTEGER NOT NULL DEFAULT 0;
+UPDATE public.purchase_event SET base_amount = amount WHERE currency = 'USD';
+
+CREATE TABLE public.job_credit_pack (
+    id CHAR(27) NOT NULL,
+    recruiter_id CHAR(27) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    plan_type VARCHAR(20) NOT NULL,
+    credits INTEGER NOT NULL,
+    remaining INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    currency CHAR(3) NOT NULL,
+    stripe_session_id VARCHAR(255) NOT NULL,
+    stripe_payment_intent_id VARCHAR(255) DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP DEFAULT NULL,
+    expires_at TIMESTAMP DEFAULT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX job_credit_pack_stripe_session_id_idx ON public.job_credit_pack (stripe_session_id);
+CREATE INDEX job_credit_pack_stripe_payment_intent_id_idx ON public.job_credit_pack (stripe_payment_intent_id);
+CREATE INDEX job_credit_pack_recruiter_id_idx ON public.job_credit_pack (recruiter_id);
+
+CREATE TABLE public.job_credit_spend (
+    job_credit_pack_id CHAR(27) NOT NULL REFERENCES public.job_credit_pack (id),
+    job_id INTEGER NOT NULL,
+    credits INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX job_credit_spend_job_id_idx ON public.job_credit_spend (job_id);
+
+ALTER TABLE public.invoice ALTER COLUMN job_id DROP NOT NULL;
+
+CREATE TABLE public.job_plan_history (
+    id CHAR(27) NOT NULL,
+    job_id INTEGER NOT NULL,
+    event VARCHAR(30) NOT NULL,
+    plan_type VARCHAR(20) NOT NULL,
+    plan_duration INTEGER NOT NULL,
+    previous_plan_type VARCHAR(20) NOT NULL DEFAULT '',
+    plan_expired_at TIMESTAMP DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX job_plan_history_job_id_event_idx ON public.job_plan_history (job_id, event);
+
+ALTER TABLE public.newsletter_send_log ADD COLUMN email_outbox_id CHAR(27) DEFAULT NULL;
+ALTER TABLE public.newsletter_send_log ADD COLUMN delivered_at TIMESTAMP DEFAULT NULL;
+UPDATE public.newsletter_send_log SET delivered_at = sent_at WHERE error IS NULL;
+CREATE INDEX newsletter_send_log_email_outbox_id_idx ON public.newsletter_send_log (email_outbox_id);
+
+CREATE UNIQUE INDEX developer_directory_purchase_event_stripe_session_id_idx ON public.developer_directory_purchase_event (stripe_session_id);
@@ -6,11 +6,13 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"strings"
+	"time"
 	"embed"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 
+	"github.com/golang-cafe/job-board/internal/apikey"
 	"github.com/golang-cafe/job-board/internal/blog"
 	"github.com/golang-cafe/job-board/internal/bookmark"
 	"github.com/golang-cafe/job-board/internal/company"
@@ -19,9 +21,15 @@ import (
 	"github.com/golang-cafe/job-board/internal/developer"
 	"github.com/golang-cafe/job-board/internal/email"
 	"github.com/golang-cafe/job-board/internal/handler"
+	"github.com/golang-cafe/job-board/internal/invoice"
 	"github.com/golang-cafe/job-board/internal/job"
+	"github.com/golang-cafe/job-board/internal/jobcredit"
 	"github.com/golang-cafe/job-board/internal/payment"
+	"github.com/golang-cafe/job-board/internal/promocode"
+	"github.com/golang-cafe/job-board/internal/newsletter"
 	"github.com/golang-cafe/job-board/internal/recruiter"
+	"github.com/golang-cafe/job-board/internal/revenue"
+	"github.com/golang-cafe/job-board/internal/savedsearch"
 	"github.com/golang-cafe/job-board/internal/server"
 	"github.com/golang-cafe/job-board/internal/template"
 	"github.com/golang-cafe/job-board/internal/user"
@@ -46,30 +54,34 @@ func main() {
 	if err != nil {
 		log.Fatalf("unable to connect to postgres: %v", err)
 	}
+	emailSender, err := email.NewSender(cfg.EmailTransport, cfg.SmtpUser, cfg.SmtpPassword, cfg.SmtpHost, cfg.EmailDropDir)
+	if err != nil {
+		log.Fatalf("unable to create email sender: %v", err)
+	}
+	emailRepo := email.NewRepository(conn)
 	emailClient, err := email.NewClient(
-		cfg.SmtpUser,
-		cfg.SmtpPassword,
-		cfg.SmtpHost,
+		emailSender,
+		emailRepo,
 		cfg.SupportEmail,
 		cfg.NoReplyEmail,
 		cfg.SiteName,
-		cfg.Env == "dev",
 	)
 	if err != nil {
-		log.Fatalf("unable to connect to sparkpost API: %v", err)
+		log.Fatalf("unable to create email client: %v", err)
 	}
+	go emailClient.RunOutboxWorker(10*time.Second, 50)
 	sessionStore := sessions.NewCookieStore(cfg.SessionKey)
 	robotsTxtContent, err := staticFS.ReadFile("static/robots.txt")
 	if err != nil {
-		log.Fatalf("unable to read robots.txt placeholder file: %w", err)
+		log.Fatalf("unable to read robots.txt placeholder file: %v", err)
 	}
 	securityTxtContent, err := staticFS.ReadFile("static/security.txt")
 	if err != nil {
-		log.Fatalf("unable to read security.txt placeholder file: %w", err)
+		log.Fatalf("unable to read security.txt placeholder file: %v", err)
 	}
 	adsTxtContent, err := staticFS.ReadFile("static/ads.txt")
 	if err != nil {
-		log.Fatalf("unable to read security.txt placeholder file: %w", err)
+		log.Fatalf("unable to read security.txt placeholder file: %v", err)
 	}
 
 	devRepo := developer.NewRepository(conn)
@@ -79,7 +91,15 @@ func main() {
 	companyRepo := company.NewRepository(conn)
 	jobRepo := job.NewRepository(conn)
 	paymentRepo := payment.NewRepository(cfg.StripeKey, cfg.SiteName, cfg.SiteHost, cfg.URLProtocol)
+	stripeEventRepo := payment.NewEventRepository(conn)
+	invoiceRepo := invoice.NewRepository(conn)
+	promoRepo := promocode.NewRepository(conn)
+	jobCreditRepo := jobcredit.NewRepository(conn)
+	revenueRepo := revenue.NewRepository(conn)
 	bookmarkRepo := bookmark.NewRepository(conn)
+	apiKeyRepo := apikey.NewRepository(conn)
+	savedSearchRepo := savedsearch.NewRepository(conn)
+	newsletterRepo := newsletter.NewRepository(conn)
 
 	svr := server.NewServer(
 		cfg,
@@ -172,7 +192,7 @@ func main() {
 	svr.RegisterRoute("/x/auth/message/{id}", handler.DeliverMessageDeveloperProfileHandler(svr, devRepo), []string{"GET"})
 
 	// blog
-	svr.RegisterRoute("/profile/home", handler.ProfileHomepageHandler(svr, devRepo, recRepo), []string{"GET"})
+	svr.RegisterRoute("/profile/home", handler.ProfileHomepageHandler(svr, devRepo, recRepo, apiKeyRepo), []string{"GET"})
 	svr.RegisterRoute("/profile/{id}/edit", handler.EditProfileHandler(svr, devRepo, recRepo), []string{"GET"})
 	svr.RegisterRoute("/profile/blog/create", handler.CreateDraftBlogPostHandler(svr, blogRepo), []string{"GET"})
 	svr.RegisterRoute("/profile/blog/list", handler.GetUserBlogPostsHandler(svr, blogRepo), []string{"GET"})
@@ -185,14 +205,15 @@ func main() {
 	svr.RegisterRoute("/blog", handler.GetAllPublishedBlogPostsHandler(svr, blogRepo), []string{"GET"})
 
 	// recruiter
-	svr.RegisterRoute("/profile/jobs", handler.RecruiterJobPosts(svr, devRepo, recRepo, jobRepo), []string{"GET"})
+	svr.RegisterRoute("/profile/jobs", handler.RecruiterJobPosts(svr, devRepo, recRepo, jobRepo, invoiceRepo, jobCreditRepo), []string{"GET"})
+	svr.RegisterRoute("/profile/invoices/{id}", handler.DownloadInvoiceHandler(svr, invoiceRepo), []string{"GET"})
 	svr.RegisterRoute("/profile/sent", handler.SentMessages(svr, devRepo), []string{"GET"})
 
 	// developer
 	svr.RegisterRoute("/profile/messages", handler.ReceivedMessages(svr, devRepo), []string{"GET"})
 
 	// tasks
-	svr.RegisterRoute("/x/task/weekly-newsletter", handler.TriggerWeeklyNewsletter(svr, jobRepo), []string{"POST"})
+	svr.RegisterRoute("/x/task/weekly-newsletter", handler.TriggerWeeklyNewsletter(svr, jobRepo, newsletterRepo), []string{"POST"})
 	svr.RegisterRoute("/x/task/ads-manager", handler.TriggerAdsManager(svr, jobRepo), []string{"POST"})
 	svr.RegisterRoute("/x/task/twitter-scheduler", handler.TriggerTwitterScheduler(svr, jobRepo), []string{"POST"})
 	svr.RegisterRoute("/x/task/telegram-scheduler", handler.TriggerTelegramScheduler(svr, jobRepo), []string{"POST"})
@@ -204,6 +225,11 @@ func main() {
 	svr.RegisterRoute("/x/task/monthly-highlights", handler.TriggerMonthlyHighlights(svr, jobRepo), []string{"POST"})
 	svr.RegisterRoute("/x/task/fx-rate-update", handler.TriggerFXRateUpdate(svr), []string{"POST"})
 	svr.RegisterRoute("/x/task/expire-sign-on-tokens", handler.TriggerExpiredUserSignOnTokensTask(svr, userRepo), []string{"POST"})
+	svr.RegisterRoute("/x/task/job-alerts-instant", handler.TriggerSavedSearchAlerts(svr, jobRepo, savedSearchRepo, savedsearch.FrequencyInstant), []string{"POST"})
+	svr.RegisterRoute("/x/task/job-alerts-daily", handler.TriggerSavedSearchAlerts(svr, jobRepo, savedSearchRepo, savedsearch.FrequencyDaily), []string{"POST"})
+	svr.RegisterRoute("/x/task/email-outbox-cleanup", handler.TriggerEmailOutboxCleanup(svr, emailRepo), []string{"POST"})
+	svr.RegisterRoute("/x/task/cv-retention", handler.TriggerCvRetention(svr, jobRepo), []string{"POST"})
+	svr.RegisterRoute("/x/task/cv-text-backfill", handler.TriggerCvTextBackfill(svr, jobRepo), []string{"POST"})
 
 	// view newsletter
 	svr.RegisterRoute("/newsletter", handler.ViewNewsletterPageHandler(svr, jobRepo, devRepo, bookmarkRepo), []string{"GET"})
@@ -229,12 +255,23 @@ func main() {
 	svr.RegisterRoute("/apply/{token}", handler.ApplyToJobConfirmation(svr, jobRepo), []string{"GET"})
 
 	// submit job post
-	svr.RegisterRoute("/x/s", handler.SubmitJobPostPageHandler(svr, jobRepo, paymentRepo), []string{"POST"})
+	svr.RegisterRoute("/x/s", handler.SubmitJobPostPageHandler(svr, jobRepo, paymentRepo, promoRepo, recRepo, jobCreditRepo, savedSearchRepo), []string{"POST"})
+
+	// check a promo code entered at job ad checkout
+	svr.RegisterRoute("/x/promo-code", handler.ValidatePromoCodeHandler(svr, promoRepo), []string{"POST"})
+	svr.RegisterRoute("/x/job-credits", handler.JobCreditsHandler(svr, recRepo, jobCreditRepo), []string{"GET"})
+	svr.RegisterRoute("/x/job-credits/checkout", handler.BuyJobCreditPackHandler(svr, recRepo, paymentRepo, jobCreditRepo), []string{"POST"})
 
 	// re-submit job post payment for upsell
-	svr.RegisterRoute("/x/s/upsell", handler.SubmitJobPostPaymentUpsellPageHandler(svr, jobRepo, paymentRepo), []string{"POST"})
+	svr.RegisterRoute("/x/s/upsell", handler.SubmitJobPostPaymentUpsellPageHandler(svr, jobRepo, paymentRepo, promoRepo), []string{"POST"})
+	// one-click plan renewal link of the renewal emails
+	svr.RegisterRoute("/x/s/upsell", handler.JobPostUpsellLinkPageHandler(svr, jobRepo), []string{"GET"})
 	// dev directory upsell/renew
-	svr.RegisterRoute("/x/s/d/upsell", handler.DeveloperDirectoryUpsellPageHandler(svr, jobRepo, paymentRepo), []string{"POST"})
+	svr.RegisterRoute("/x/s/d/upsell", handler.DeveloperDirectoryUpsellPageHandler(svr, recRepo, paymentRepo), []string{"POST"})
+	// dev directory subscription billing portal
+	svr.RegisterRoute("/x/s/d/billing", handler.BillingPortalHandler(svr, recRepo, paymentRepo), []string{"GET"})
+	// job ad checkout link
+	svr.RegisterRoute("/x/s/checkout/{id}", handler.CheckoutRedirectPageHandler(svr), []string{"GET"})
 
 	// save media file
 	svr.RegisterRoute("/x/s/m", handler.SaveMediaPageHandler(svr), []string{"POST"})
@@ -245,8 +282,13 @@ func main() {
 	// retrieve meta image media file
 	svr.RegisterRoute("/x/s/m/meta/{id}", handler.RetrieveMediaMetaPageHandler(svr, jobRepo), []string{"GET"})
 
+	// email bounce and complaint webhook, only enabled with a shared secret
+	if svr.GetConfig().EmailWebhookToken != "" {
+		svr.RegisterRoute("/x/email/feedback", handler.EmailFeedbackWebhookHandler(svr, emailRepo), []string{"POST"})
+	}
+
 	// stripe payment confirmation webhook
-	svr.RegisterRoute("/x/stripe/checkout/completed", handler.StripePaymentConfirmationWebhookHandler(svr, jobRepo, recRepo), []string{"POST"})
+	svr.RegisterRoute("/x/stripe/checkout/completed", handler.StripePaymentConfirmationWebhookHandler(svr, jobRepo, recRepo, paymentRepo, stripeEventRepo, invoiceRepo, jobCreditRepo, savedSearchRepo), []string{"POST"})
 
 	// track job clickout
 	svr.RegisterRoute("/x/j/c/{id}", handler.TrackJobClickoutPageHandler(svr, jobRepo), []string{"GET"})
@@ -270,6 +312,20 @@ func main() {
 	svr.RegisterRoute("/profile/bookmarks", handler.BookmarkListHandler(svr, bookmarkRepo), []string{"GET"})
 	svr.RegisterRoute("/x/bookmark", handler.BookmarkJobHandler(svr, bookmarkRepo, jobRepo), []string{"POST", "DELETE"})
 
+	// applications
+	svr.RegisterRoute("/profile/applications", handler.ApplicationListHandler(svr, bookmarkRepo), []string{"GET"})
+	svr.RegisterRoute("/x/profile/applications/withdraw", handler.WithdrawApplicationHandler(svr, bookmarkRepo, jobRepo), []string{"POST"})
+
+	// personal data export and account erasure
+	svr.RegisterRoute("/x/profile/data-export", handler.ExportUserDataHandler(svr, userRepo, devRepo, recRepo, bookmarkRepo, jobRepo, blogRepo, savedSearchRepo, apiKeyRepo), []string{"GET"})
+	svr.RegisterRoute("/x/profile/erase", handler.EraseUserHandler(svr, userRepo, recRepo), []string{"POST"})
+
+	// saved searches (job alerts)
+	svr.RegisterRoute("/profile/job-alerts", handler.SavedSearchListHandler(svr, savedSearchRepo), []string{"GET"})
+	svr.RegisterRoute("/x/profile/job-alerts", handler.CreateSavedSearchHandler(svr, savedSearchRepo), []string{"POST"})
+	svr.RegisterRoute("/x/profile/job-alerts/{id}/delete", handler.DeleteSavedSearchHandler(svr, savedSearchRepo), []string{"POST"})
+	svr.RegisterRoute("/x/job-alerts/unsubscribe", handler.UnsubscribeSavedSearchHandler(svr, savedSearchRepo), []string{"GET"})
+
 	//
 	// auth routes
 	//
@@ -295,6 +351,12 @@ func main() {
 	// @private: download an applicants cv by applicant token
 	svr.RegisterRoute("/download-cv/{token}", handler.DownloadJobApplicationCvHandler(svr, jobRepo), []string{"GET"})
 
+	// @private: applicant tracking by job edit token or recruiter account
+	svr.RegisterRoute("/x/applicants/stage", handler.UpdateApplicantsStageHandler(svr, jobRepo), []string{"POST"})
+	svr.RegisterRoute("/x/applicants/rating", handler.UpdateApplicantRatingHandler(svr, jobRepo), []string{"POST"})
+	svr.RegisterRoute("/x/applicants/note", handler.AddApplicantNoteHandler(svr, jobRepo), []string{"POST"})
+	svr.RegisterRoute("/x/applicants/export", handler.ExportApplicantsHandler(svr, jobRepo), []string{"GET"})
+
 	// @private: disapprove job by token
 	svr.RegisterRoute("/x/d", handler.DisapproveJobPageHandler(svr, jobRepo), []string{"POST"})
 
@@ -477,6 +539,16 @@ func main() {
 	// RSS feed
 	svr.RegisterRoute("/rss", handler.ServeRSSFeed(svr, jobRepo), []string{"GET"})
 
+	// public json api
+	svr.RegisterRoute("/api/v1/jobs", handler.APIListJobsHandler(svr, jobRepo, apiKeyRepo), []string{"GET"})
+	svr.RegisterRoute("/api/v1/jobs/{id}", handler.APIJobHandler(svr, jobRepo, apiKeyRepo), []string{"GET"})
+	svr.RegisterRoute("/api/v1/jobs", handler.APICreateJobHandler(svr, jobRepo, paymentRepo, promoRepo, userRepo, apiKeyRepo), []string{"POST"})
+	svr.RegisterRoute("/api/v1/jobs/{id}", handler.APIUpdateJobHandler(svr, jobRepo, apiKeyRepo), []string{"PATCH"})
+
+	// api keys
+	svr.RegisterRoute("/x/profile/api-keys", handler.CreateAPIKeyHandler(svr, apiKeyRepo), []string{"POST"})
+	svr.RegisterRoute("/x/profile/api-keys/{id}/revoke", handler.RevokeAPIKeyHandler(svr, apiKeyRepo), []string{"POST"})
+
 	//
 	// admin routes
 	// protected by jwt auth
@@ -488,6 +560,21 @@ func main() {
 	// @admin: list/search jobs as admin
 	svr.RegisterRoute("/manage/list", handler.ListJobsAsAdminPageHandler(svr, jobRepo), []string{"GET"})
 
+	// @admin: email outbox, pending/sent/dead-lettered emails
+	svr.RegisterRoute("/manage/email-outbox", handler.EmailOutboxAdminPageHandler(svr, emailRepo), []string{"GET"})
+
+	// @admin: bounced and complained email addresses report
+	svr.RegisterRoute("/manage/email-suppressions", handler.EmailSuppressionsAdminPageHandler(svr, emailRepo), []string{"GET"})
+
+	// @admin: stripe webhook events, failed/ignored/processed
+	svr.RegisterRoute("/manage/stripe-events", handler.StripeEventsAdminPageHandler(svr, stripeEventRepo), []string{"GET"})
+
+	// @admin: job ad promo codes and their redemptions
+	svr.RegisterRoute("/manage/promo-codes", handler.PromoCodesAdminPageHandler(svr, promoRepo), []string{"GET"})
+
+	// @admin: revenue and sales report, csv export with ?format=csv
+	svr.RegisterRoute("/manage/revenue", handler.RevenueAdminPageHandler(svr, revenueRepo), []string{"GET"})
+
 	// @admin: view job as admin (alias to manage/edit/{token})
 	svr.RegisterRoute("/manage/job/{slug}", handler.ManageJobBySlugViewPageHandler(svr, jobRepo), []string{"GET"})
 
@@ -498,10 +585,25 @@ func main() {
 	svr.RegisterRoute("/x/sp", handler.SubmitJobPostWithoutPaymentHandler(svr, jobRepo), []string{"POST"})
 
 	// @admin: approve job
-	svr.RegisterRoute("/x/a", handler.ApproveJobPageHandler(svr, jobRepo), []string{"POST"})
+	svr.RegisterRoute("/x/a", handler.ApproveJobPageHandler(svr, jobRepo, savedSearchRepo), []string{"POST"})
 
 	// @admin: permanently delete job and all child resources (image, clickouts, edit token)
 	svr.RegisterRoute("/x/j/d", handler.PermanentlyDeleteJobByToken(svr, jobRepo), []string{"POST"})
 
+	// @admin: put a dead-lettered email back in the outbox
+	svr.RegisterRoute("/x/manage/email-outbox/{id}/retry", handler.RetryEmailOutboxMessageHandler(svr, emailRepo), []string{"POST"})
+
+	// @admin: allow sending to a suppressed email address again
+	svr.RegisterRoute("/x/manage/email-suppressions/remove", handler.RemoveEmailSuppressionHandler(svr, emailRepo), []string{"POST"})
+
+	// @admin: process a failed or ignored stripe webhook event again
+	svr.RegisterRoute("/x/manage/stripe-events/{id}/replay", handler.ReplayStripeEventHandler(svr, jobRepo, recRepo, paymentRepo, stripeEventRepo, invoiceRepo, jobCreditRepo, savedSearchRepo), []string{"POST"})
+
+	// @admin: create a promo code
+	svr.RegisterRoute("/x/manage/promo-codes", handler.CreatePromoCodeHandler(svr, promoRepo), []string{"POST"})
+
+	// @admin: stop a promo code from being redeemed
+	svr.RegisterRoute("/x/manage/promo-codes/{id}/disable", handler.DisablePromoCodeHandler(svr, promoRepo), []string{"POST"})
+
 	log.Fatal(svr.Run())
 }